@@ -1,6 +1,32 @@
 package alert
 
+import (
+	"fmt"
+	"time"
+)
+
+// 报警的级别
+const (
+	LevelWarning  = "warning"
+	LevelCritical = "critical"
+)
+
 type Alert struct {
 }
+
+// AlertEvent 是一条触发的报警
 type AlertEvent struct {
+	Rule      string    // 触发的规则
+	Level     string    // LevelWarning 或 LevelCritical
+	Line      string    // 业务线
+	Host      string    // 触发报警的主机
+	Subject   string    // 报警的对象，例如慢日志的指纹
+	Value     float64   // 触发报警时的值
+	Threshold float64   // 规则的阈值
+	Message   string    // 可以直接发送给用户的描述
+	Time      time.Time // 触发报警的数据的时间
+}
+
+func (e *AlertEvent) String() string {
+	return fmt.Sprintf("[%s] %s %s@%s: %s", e.Level, e.Rule, e.Line, e.Host, e.Message)
 }
@@ -0,0 +1,397 @@
+package analyzer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ssp4599815/monitors/redis/rdb"
+)
+
+// parseDump 解析 redis/rdb/dumps 中的测试文件
+func parseDump(t *testing.T, name string, handler rdb.Handler) {
+	f, err := os.Open(path.Join("..", "rdb", "dumps", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = rdb.NewRDB(bufio.NewReader(f), handler).Parse(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMallocOverhead(t *testing.T) {
+	cases := map[uint64]uint64{1: 8, 8: 8, 9: 16, 17: 32, 33: 48, 65: 80, 129: 160, 1000: 1024, 1025: 1280}
+	for size, want := range cases {
+		if got := mallocOverhead(size); got != want {
+			t.Fatalf("mallocOverhead(%d) = %d, want %d", size, got, want)
+		}
+	}
+}
+
+func TestMemoryEstimator(t *testing.T) {
+	var records []*MemoryRecord
+	parseDump(t, "dump.rdb", NewMemoryEstimator(func(r *MemoryRecord) {
+		records = append(records, r)
+	}))
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+
+	hello := records[1]
+	// dictEntry 32 + sds("hello") 8 + robj 16 + sds("world") 8
+	if hello.Key != "hello" || hello.Type != "string" || hello.Size != 64 || hello.LenLargestElement != 5 {
+		t.Fatalf("unexpected record %+v", hello)
+	}
+	if records[0].Expiry == 0 || records[0].Size <= hello.Size-8 {
+		t.Fatalf("expected expiry overhead on %+v", records[0])
+	}
+	hash := records[2]
+	if hash.Encoding != rdb.EncodingZiplist || hash.NumElements != 1 || hash.LenLargestElement != 5 {
+		t.Fatalf("unexpected record %+v", hash)
+	}
+
+	var buf bytes.Buffer
+	w := NewMemoryCSVWriter(&buf)
+	for _, r := range records {
+		w.Write(r)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "0,string,hello,64,string,1,5,\n") {
+		t.Fatalf("unexpected csv output:\n%s", buf.String())
+	}
+}
+
+func TestBigKeys(t *testing.T) {
+	b := NewBigKeys(2)
+	for i, size := range []uint64{10, 50, 30, 40} {
+		b.Add(&MemoryRecord{Key: string(rune('a' + i)), Type: "string", Size: size, NumElements: int64(i)})
+	}
+	b.Add(&MemoryRecord{DB: 1, Key: "h", Type: "hash", Size: 5, NumElements: 100, Expiry: 1})
+
+	top := b.TopBySize()
+	if len(top) != 2 || top[0].Key != "b" || top[1].Key != "d" {
+		t.Fatalf("unexpected top by size %v %v", top[0], top[1])
+	}
+	top = b.TopByElements()
+	if top[0].Key != "h" || top[1].Key != "d" {
+		t.Fatalf("unexpected top by elements %v %v", top[0], top[1])
+	}
+	if b.Keys != 5 || b.ExpiringKeys != 1 || b.Types["string"].Bytes != 130 {
+		t.Fatalf("unexpected summary %+v", b)
+	}
+
+	var buf bytes.Buffer
+	if err := b.Report(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "# db1 hash: top 2 by memory") {
+		t.Fatalf("missing per db section:\n%s", buf.String())
+	}
+}
+
+func TestPrefixAggregator(t *testing.T) {
+	p := NewPrefixAggregator(":", 2, nil)
+	for key, want := range map[string]string{
+		"order:item:1001": "order:item",
+		"order:item:1:x":  "order:item",
+		"user:42":         "user",
+		"counter":         NoPrefix,
+	} {
+		if got := p.Prefix(key); got != want {
+			t.Fatalf("Prefix(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	p.Add(&MemoryRecord{Key: "order:item:1", Type: "hash", Size: 100, Expiry: 1})
+	p.Add(&MemoryRecord{Key: "order:item:2", Type: "string", Size: 50})
+	p.Add(&MemoryRecord{Key: "user:1", Type: "string", Size: 10})
+	stats := p.Stats()
+	if len(stats) != 2 || stats[0].Prefix != "order:item" || stats[0].Bytes != 150 || stats[0].TTLCoverage() != 0.5 {
+		t.Fatalf("unexpected stats %+v", stats[0])
+	}
+	if formatTypeMix(stats[0].Types) != "hash=1,string=1" {
+		t.Fatalf("unexpected type mix %v", stats[0].Types)
+	}
+
+	re := NewPrefixAggregator(":", 2, []*regexp.Regexp{regexp.MustCompile(`^(session):`), regexp.MustCompile(`^tmp`)})
+	for key, want := range map[string]string{"session:abc": "session", "tmp:1": "^tmp", "x": OtherPrefix} {
+		if got := re.Prefix(key); got != want {
+			t.Fatalf("Prefix(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestExpiryAnalyzer(t *testing.T) {
+	e := NewExpiryAnalyzer(NewPrefixAggregator(":", 1, nil).Prefix)
+	e.Aux([]byte("ctime"), []byte("1000"))
+	if e.DumpTime != 1000000 {
+		t.Fatalf("unexpected dump time %d", e.DumpTime)
+	}
+	cases := map[int64]int{
+		0:                     BucketNoTTL,
+		999999:                BucketExpired,
+		1000000 + 30*1000:     BucketLess1m,
+		1000000 + 2*3600*1000: BucketLess6h,
+		1000000 + 90*86400000: BucketMore30d,
+	}
+	for expiry, want := range cases {
+		if got := e.Bucket(expiry); got != want {
+			t.Fatalf("Bucket(%d) = %s, want %s", expiry, BucketNames[got], BucketNames[want])
+		}
+	}
+
+	e.Add(&MemoryRecord{Key: "a:1", Expiry: 1000000 + 30*1000})
+	e.Add(&MemoryRecord{Key: "a:2", Expiry: 1000000 + 40*1000})
+	e.Add(&MemoryRecord{Key: "b:1"})
+	e.Add(&MemoryRecord{DB: 1, Key: "b:2", Expiry: 1})
+	if s := e.Prefix("a"); s.Keys != 2 || s.Buckets[BucketLess1m] != 2 {
+		t.Fatalf("unexpected prefix stat %+v", s)
+	}
+	if s := e.DB(1); s.Keys != 1 || s.Buckets[BucketExpired] != 1 {
+		t.Fatalf("unexpected db stat %+v", s)
+	}
+	if cliffs := e.Cliffs(1); len(cliffs) != 1 || cliffs[0].Keys != 2 {
+		t.Fatalf("unexpected cliffs %+v", cliffs)
+	}
+
+	// 窗口小于 1ms 时按 1ms 统计，不会除以 0
+	e.CliffWindow = 0
+	e.Add(&MemoryRecord{Key: "c:1", Expiry: 1000000 + 50*1000})
+	if cliffs := e.Cliffs(0); len(cliffs) != 2 {
+		t.Fatalf("unexpected cliffs %+v", cliffs)
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	if LFUAccesses(5, 10) != 0 || LFUAccesses(6, 10) != 1 || LFUAccesses(8, 10) != 33 {
+		t.Fatalf("unexpected lfu accesses %d %d %d", LFUAccesses(5, 10), LFUAccesses(6, 10), LFUAccesses(8, 10))
+	}
+
+	lfu := NewHotKeys(1)
+	parseDump(t, "dump-lfu.rdb", NewMemoryEstimator(lfu.Add))
+	if hot := lfu.Hottest(); len(hot) != 1 || hot[0].Key != "key" || hot[0].Freq != 4 {
+		t.Fatalf("unexpected hottest keys %+v", hot)
+	}
+	if len(lfu.Coldest()) != 0 {
+		t.Fatal("lfu dump should have no idle info")
+	}
+
+	lru := NewHotKeys(1)
+	parseDump(t, "dump-lru.rdb", NewMemoryEstimator(lru.Add))
+	if cold := lru.Coldest(); len(cold) != 1 || cold[0].Idle <= 0 {
+		t.Fatalf("unexpected coldest keys %+v", cold)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	d := NewDiff(10, NewPrefixAggregator(":", 2, nil).Prefix)
+	parseDump(t, "dump-lru.rdb", d.Index())
+	parseDump(t, "dump.rdb", d.Compare())
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [changeKindCount]uint64{KeyAdded: 2, KeyRemoved: 1, KeyTTLChanged: 1, KeyValueChanged: 1}
+	for kind, n := range want {
+		if d.Count(kind) != n {
+			t.Fatalf("%s: got %d keys, want %d", ChangeKindNames[kind], d.Count(kind), n)
+		}
+	}
+	if removed := d.Changes(KeyRemoved); removed[0].Old.Key != "key1" || removed[0].New != nil {
+		t.Fatalf("unexpected removed key %+v", removed[0])
+	}
+	growth := d.Growth()
+	if len(growth) != 1 || growth[0].Prefix != NoPrefix || growth[0].Keys != 1 {
+		t.Fatalf("unexpected growth %+v", growth)
+	}
+
+	// 同一个 RDB 比较自己没有任何变化
+	d = NewDiff(10, nil)
+	parseDump(t, "dump.rdb", d.Index())
+	parseDump(t, "dump.rdb", d.Compare())
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	for kind := range ChangeKindNames {
+		if d.Count(kind) != 0 {
+			t.Fatalf("%s: expected no changes, got %d", ChangeKindNames[kind], d.Count(kind))
+		}
+	}
+}
+
+// writeStringDump 生成只有字符串的 RDB，kv 依次为 key 和 value
+func writeStringDump(t *testing.T, kv ...string) []byte {
+	var buf bytes.Buffer
+	w := rdb.NewWriter(&buf, 9)
+	w.WriteHeader()
+	w.SelectDB(0)
+	for i := 0; i < len(kv); i += 2 {
+		if err := w.WriteString([]byte(kv[i]), []byte(kv[i+1]), &rdb.KeyInfo{Idle: -1, Freq: -1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDiffSpill(t *testing.T) {
+	// 旧 RDB 有 k0..k999，新 RDB 删除了 k0..k99，修改了 k100..k199，新增了 n0..n99，顺序与旧 RDB 相反
+	var oldKV, newKV []string
+	for i := 0; i < 1000; i++ {
+		oldKV = append(oldKV, fmt.Sprintf("k%d", i), "v")
+	}
+	for i := 999; i >= 100; i-- {
+		value := "v"
+		if i < 200 {
+			value = "changed"
+		}
+		newKV = append(newKV, fmt.Sprintf("k%d", i), value)
+	}
+	for i := 0; i < 100; i++ {
+		newKV = append(newKV, fmt.Sprintf("n%d", i), "v")
+	}
+	oldDump, newDump := writeStringDump(t, oldKV...), writeStringDump(t, newKV...)
+
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	parse := func(data []byte, h rdb.Handler) {
+		if err := rdb.NewRDB(bufio.NewReader(bytes.NewReader(data)), h).Parse(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, maxMemory := range []int{0, 4 << 10} {
+		d := NewDiff(3, func(key string) string { return key[:1] })
+		d.MaxMemory, d.TempDir = maxMemory, dir
+		parse(oldDump, d.Index())
+		parse(newDump, d.Compare())
+		if spilled := len(d.old.runs) + len(d.cur.runs); (maxMemory > 0) != (spilled > 0) {
+			t.Fatalf("max memory %d: %d spill files", maxMemory, spilled)
+		}
+		if err := d.Finish(); err != nil {
+			t.Fatal(err)
+		}
+
+		want := [changeKindCount]uint64{KeyAdded: 100, KeyRemoved: 100, KeyValueChanged: 100}
+		for kind, n := range want {
+			if d.Count(kind) != n {
+				t.Fatalf("max memory %d, %s: got %d keys, want %d", maxMemory, ChangeKindNames[kind], d.Count(kind), n)
+			}
+		}
+		var keys []string
+		for _, c := range d.Changes(KeyRemoved) {
+			keys = append(keys, c.Old.Key)
+		}
+		for _, c := range d.Changes(KeyValueChanged) {
+			keys = append(keys, c.New.Key)
+		}
+		if fmt.Sprint(keys) != "[k0 k1 k10 k100 k101 k102]" {
+			t.Fatalf("max memory %d: unexpected keys %v", maxMemory, keys)
+		}
+		keys = nil
+		for _, g := range d.Growth() {
+			keys = append(keys, fmt.Sprintf("%s%+d", g.Prefix, g.Keys))
+		}
+		if sort.Strings(keys); fmt.Sprint(keys) != "[k-100 n+100]" {
+			t.Fatalf("max memory %d: unexpected growth %v", maxMemory, keys)
+		}
+		if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+			t.Fatalf("max memory %d: %d temporary files left", maxMemory, len(files))
+		}
+	}
+}
+
+// writeLargeDump 用 rdb.Writer 生成 n 个 hash、list 和 zset 组成的 RDB
+func writeLargeDump(t testing.TB, n int) []byte {
+	var buf bytes.Buffer
+	w := rdb.NewWriter(&buf, 11)
+	w.Compress = true
+	w.WriteHeader()
+	w.SelectDB(0)
+	items := make([][]byte, 100)
+	scores := make([]float64, len(items))
+	for i := range items {
+		items[i] = []byte(fmt.Sprintf("item:%d:%s", i, strings.Repeat("v", i%16)))
+		scores[i] = float64(i)
+	}
+	for i := 0; i < n; i++ {
+		info := &rdb.KeyInfo{Encoding: rdb.EncodingListpack, Expiry: int64(i % 3), Idle: -1, Freq: -1}
+		err := w.WriteHash([]byte(fmt.Sprintf("user:%d", i)), items, items, info)
+		if err == nil {
+			info.Encoding = rdb.EncodingQuicklist
+			err = w.WriteList([]byte(fmt.Sprintf("queue:%d", i)), items, info)
+		}
+		if err == nil {
+			info.Encoding = rdb.EncodingSkiplist
+			err = w.WriteZSet([]byte(fmt.Sprintf("rank:%d", i)), items, scores, info)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMemoryPipeline(t *testing.T) {
+	data := writeLargeDump(t, 200)
+	var want, got []MemoryRecord
+	err := rdb.NewRDB(bufio.NewReader(bytes.NewReader(data)), NewMemoryEstimator(func(r *MemoryRecord) {
+		want = append(want, *r)
+	})).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewMemoryPipeline(4, func(r *MemoryRecord) { got = append(got, *r) })
+	if err = p.Parse(bufio.NewReader(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("record %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func BenchmarkMemoryEstimator(b *testing.B) {
+	data := writeLargeDump(b, 2000)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		agg := NewPrefixAggregator(":", 1, nil)
+		if err := rdb.NewRDB(bufio.NewReader(bytes.NewReader(data)), NewMemoryEstimator(agg.Add)).Parse(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemoryPipeline(b *testing.B) {
+	data := writeLargeDump(b, 2000)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		agg := NewPrefixAggregator(":", 1, nil)
+		if err := NewMemoryPipeline(0, agg.Add).Parse(bufio.NewReader(bytes.NewReader(data))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
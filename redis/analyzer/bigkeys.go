@@ -0,0 +1,186 @@
+package analyzer
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// topKeys 用小顶堆保留 less 意义下最大的 n 个 key
+type topKeys struct {
+	n     int
+	less  func(a, b *MemoryRecord) bool
+	items []*MemoryRecord
+}
+
+func newTopKeys(n int, less func(a, b *MemoryRecord) bool) *topKeys {
+	return &topKeys{n: n, less: less}
+}
+
+func (t *topKeys) Len() int           { return len(t.items) }
+func (t *topKeys) Less(i, j int) bool { return t.less(t.items[i], t.items[j]) }
+func (t *topKeys) Swap(i, j int)      { t.items[i], t.items[j] = t.items[j], t.items[i] }
+func (t *topKeys) Push(x interface{}) { t.items = append(t.items, x.(*MemoryRecord)) }
+func (t *topKeys) Pop() interface{} {
+	last := t.items[len(t.items)-1]
+	t.items = t.items[:len(t.items)-1]
+	return last
+}
+
+func (t *topKeys) add(r *MemoryRecord) {
+	if t.n <= 0 {
+		return
+	}
+	if len(t.items) < t.n {
+		heap.Push(t, r)
+		return
+	}
+	if t.less(t.items[0], r) {
+		t.items[0] = r
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted 返回从大到小排好序的 key
+func (t *topKeys) sorted() []*MemoryRecord {
+	items := append([]*MemoryRecord(nil), t.items...)
+	sort.Slice(items, func(i, j int) bool { return t.less(items[j], items[i]) })
+	return items
+}
+
+func bySize(a, b *MemoryRecord) bool {
+	if a.Size != b.Size {
+		return a.Size < b.Size
+	}
+	return a.Key > b.Key
+}
+
+func byElements(a, b *MemoryRecord) bool {
+	if a.NumElements != b.NumElements {
+		return a.NumElements < b.NumElements
+	}
+	return a.Key > b.Key
+}
+
+// TypeStat 是某一种类型的 key 的汇总
+type TypeStat struct {
+	Keys  uint64
+	Bytes uint64
+}
+
+// bigKeysGroup 是一个数据库里某一种类型的 top N
+type bigKeysGroup struct {
+	db       int
+	typ      string
+	size     *topKeys
+	elements *topKeys
+}
+
+// BigKeys 统计内存占用和元素个数最多的 key，以及各类型的汇总信息
+type BigKeys struct {
+	N            int
+	Keys         uint64
+	ExpiringKeys uint64
+	Bytes        uint64
+	Types        map[string]*TypeStat
+
+	size     *topKeys
+	elements *topKeys
+	groups   map[string]*bigKeysGroup
+}
+
+func NewBigKeys(n int) *BigKeys {
+	return &BigKeys{
+		N:        n,
+		Types:    make(map[string]*TypeStat),
+		size:     newTopKeys(n, bySize),
+		elements: newTopKeys(n, byElements),
+		groups:   make(map[string]*bigKeysGroup),
+	}
+}
+
+// Add 统计一个 key，可以直接作为 NewMemoryEstimator 的 callback
+func (b *BigKeys) Add(r *MemoryRecord) {
+	b.Keys++
+	b.Bytes += r.Size
+	if r.Expiry != 0 {
+		b.ExpiringKeys++
+	}
+	ts, ok := b.Types[r.Type]
+	if !ok {
+		ts = &TypeStat{}
+		b.Types[r.Type] = ts
+	}
+	ts.Keys++
+	ts.Bytes += r.Size
+
+	b.size.add(r)
+	b.elements.add(r)
+
+	name := fmt.Sprintf("%d/%s", r.DB, r.Type)
+	g, ok := b.groups[name]
+	if !ok {
+		g = &bigKeysGroup{db: r.DB, typ: r.Type, size: newTopKeys(b.N, bySize), elements: newTopKeys(b.N, byElements)}
+		b.groups[name] = g
+	}
+	g.size.add(r)
+	g.elements.add(r)
+}
+
+// TopBySize 返回内存占用最大的 N 个 key
+func (b *BigKeys) TopBySize() []*MemoryRecord {
+	return b.size.sorted()
+}
+
+// TopByElements 返回元素个数最多的 N 个 key
+func (b *BigKeys) TopByElements() []*MemoryRecord {
+	return b.elements.sorted()
+}
+
+// Report 以文本表格的形式输出统计结果
+func (b *BigKeys) Report(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "# Summary\n")
+	fmt.Fprintf(w, "keys\texpiring keys\ttotal bytes\n")
+	fmt.Fprintf(w, "%d\t%d\t%d\n\n", b.Keys, b.ExpiringKeys, b.Bytes)
+
+	fmt.Fprintf(w, "type\tkeys\tbytes\n")
+	types := make([]string, 0, len(b.Types))
+	for typ := range b.Types {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", typ, b.Types[typ].Keys, b.Types[typ].Bytes)
+	}
+
+	writeTop(w, fmt.Sprintf("# Top %d keys by memory", b.N), b.TopBySize())
+	writeTop(w, fmt.Sprintf("# Top %d keys by elements", b.N), b.TopByElements())
+
+	groups := make([]*bigKeysGroup, 0, len(b.groups))
+	for _, g := range b.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].db != groups[j].db {
+			return groups[i].db < groups[j].db
+		}
+		return groups[i].typ < groups[j].typ
+	})
+	for _, g := range groups {
+		writeTop(w, fmt.Sprintf("# db%d %s: top %d by memory", g.db, g.typ, b.N), g.size.sorted())
+		writeTop(w, fmt.Sprintf("# db%d %s: top %d by elements", g.db, g.typ, b.N), g.elements.sorted())
+	}
+	return w.Flush()
+}
+
+func writeTop(w io.Writer, title string, records []*MemoryRecord) {
+	fmt.Fprintf(w, "\n%s\n", title)
+	fmt.Fprintf(w, "db\ttype\tkey\tbytes\telements\tlargest element\tencoding\n")
+	for _, r := range records {
+		fmt.Fprintf(w, "%d\t%s\t%q\t%d\t%d\t%d\t%s\n", r.DB, r.Type, r.Key, r.Size, r.NumElements, r.LenLargestElement, r.Encoding)
+	}
+}
@@ -0,0 +1,358 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ssp4599815/monitors/redis/rdb"
+)
+
+// 两个 RDB 之间 key 的变化类型
+const (
+	KeyAdded = iota
+	KeyRemoved
+	KeyTypeChanged
+	KeyTTLChanged
+	KeyValueChanged
+	changeKindCount
+)
+
+// ChangeKindNames 是各变化类型在报告中的名字
+var ChangeKindNames = [changeKindCount]string{"added", "removed", "type changed", "ttl changed", "value changed"}
+
+// KeyChange 是一个 key 在两个 RDB 之间的变化，Old 在 KeyAdded 时为 nil，New 在 KeyRemoved 时为 nil
+type KeyChange struct {
+	Kind int
+	Old  *MemoryRecord
+	New  *MemoryRecord
+}
+
+// PrefixGrowth 是一个前缀在两个 RDB 之间的净增长
+type PrefixGrowth struct {
+	Prefix string
+	Keys   int64
+	Bytes  int64
+}
+
+// DefaultDiffMemory 是 Diff 在内存中排序的 key 摘要的默认上限
+const DefaultDiffMemory = 512 << 20
+
+// Diff 比较两个 RDB 的内容。
+//
+// 用 Index 解析旧的 RDB、Compare 解析新的 RDB，每个 key 只记录 key、类型、过期时间、
+// value 的摘要和内存估算，按 (db, key) 做外部排序：内存中的记录超过 MaxMemory 的一半时
+// 排好序写入 TempDir 下的临时文件。Finish 把两边的有序记录归并，得到新增、删除和修改的 key，
+// 因此两个 RDB 各解析一遍，内存占用与 key 的个数无关，临时文件大约为 key 的总长度加上每个 key 30 个字节。
+type Diff struct {
+	// N 为每种变化最多保留的 key 个数
+	N int
+	// MaxMemory 为两个 RDB 合计在内存中排序的 key 摘要的字节数，默认为 DefaultDiffMemory
+	MaxMemory int
+	// TempDir 为临时文件的目录，默认为 os.TempDir()
+	TempDir string
+
+	prefix  func(key string) string
+	old     *recordSorter
+	cur     *recordSorter
+	err     error // 写临时文件的第一个错误，由 Finish 返回
+	counts  [changeKindCount]uint64
+	samples [changeKindCount][]*KeyChange
+	growth  map[string]*PrefixGrowth
+}
+
+// NewDiff 创建一个 Diff，prefix 不为 nil 时按前缀统计净增长
+func NewDiff(n int, prefix func(key string) string) *Diff {
+	return &Diff{
+		N:      n,
+		prefix: prefix,
+		growth: make(map[string]*PrefixGrowth),
+	}
+}
+
+func (d *Diff) newSorter() *recordSorter {
+	budget := d.MaxMemory
+	if budget <= 0 {
+		budget = DefaultDiffMemory
+	}
+	return newRecordSorter(d.TempDir, budget/2)
+}
+
+// Index 返回解析旧 RDB 用的 Handler
+func (d *Diff) Index() rdb.Handler {
+	d.old = d.newSorter()
+	return d.handler(d.old, -1)
+}
+
+// Compare 返回解析新 RDB 用的 Handler
+func (d *Diff) Compare() rdb.Handler {
+	d.cur = d.newSorter()
+	return d.handler(d.cur, 1)
+}
+
+// handler 把每个 key 的摘要加入 sorter，并按 sign 累计前缀的增长
+func (d *Diff) handler(sorter *recordSorter, sign int64) rdb.Handler {
+	return newDigestHandler(func(r *MemoryRecord, digest uint64) {
+		g := d.prefixGrowth(d.prefixOf(r.Key))
+		g.Keys += sign
+		g.Bytes += sign * int64(r.Size)
+		if d.err != nil {
+			return
+		}
+		d.err = sorter.add(diffRecord{db: r.DB, key: r.Key, typ: r.Type, expiry: r.Expiry, size: r.Size, digest: digest})
+	})
+}
+
+// Finish 在两个 RDB 都解析完成之后比较两边的 key，并删除临时文件
+func (d *Diff) Finish() error {
+	if d.old == nil || d.cur == nil {
+		return fmt.Errorf("diff: both Index and Compare must be parsed before Finish")
+	}
+	defer d.old.remove()
+	defer d.cur.remove()
+	if d.err != nil {
+		return d.err
+	}
+	olds, err := d.old.iterator()
+	if err != nil {
+		return err
+	}
+	defer olds.close()
+	curs, err := d.cur.iterator()
+	if err != nil {
+		return err
+	}
+	defer curs.close()
+
+	old, err := olds.next()
+	if err != nil {
+		return err
+	}
+	cur, err := curs.next()
+	if err != nil {
+		return err
+	}
+	for old != nil || cur != nil {
+		switch {
+		case cur == nil || old != nil && recordLess(old, cur):
+			d.record(&KeyChange{Kind: KeyRemoved, Old: old.memoryRecord()})
+			old, err = olds.next()
+		case old == nil || recordLess(cur, old):
+			d.record(&KeyChange{Kind: KeyAdded, New: cur.memoryRecord()})
+			cur, err = curs.next()
+		default:
+			d.compare(old, cur)
+			if old, err = olds.next(); err == nil {
+				cur, err = curs.next()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compare 比较同一个 key 在两个 RDB 中的摘要
+func (d *Diff) compare(old, cur *diffRecord) {
+	if old.typ == cur.typ && old.digest == cur.digest && old.expiry == cur.expiry {
+		return
+	}
+	oldRecord, newRecord := old.memoryRecord(), cur.memoryRecord()
+	switch {
+	case old.typ != cur.typ:
+		d.record(&KeyChange{Kind: KeyTypeChanged, Old: oldRecord, New: newRecord})
+	case old.digest != cur.digest:
+		d.record(&KeyChange{Kind: KeyValueChanged, Old: oldRecord, New: newRecord})
+	}
+	if old.expiry != cur.expiry {
+		d.record(&KeyChange{Kind: KeyTTLChanged, Old: oldRecord, New: newRecord})
+	}
+}
+
+func (r *diffRecord) memoryRecord() *MemoryRecord {
+	return &MemoryRecord{DB: r.db, Key: r.key, Type: r.typ, Size: r.size, Expiry: r.expiry}
+}
+
+func (d *Diff) record(c *KeyChange) {
+	d.counts[c.Kind]++
+	if len(d.samples[c.Kind]) < d.N {
+		d.samples[c.Kind] = append(d.samples[c.Kind], c)
+	}
+}
+
+func (d *Diff) prefixOf(key string) string {
+	if d.prefix == nil {
+		return ""
+	}
+	return d.prefix(key)
+}
+
+func (d *Diff) prefixGrowth(prefix string) *PrefixGrowth {
+	g, ok := d.growth[prefix]
+	if !ok {
+		g = &PrefixGrowth{Prefix: prefix}
+		d.growth[prefix] = g
+	}
+	return g
+}
+
+// Count 返回某种变化的 key 个数
+func (d *Diff) Count(kind int) uint64 {
+	return d.counts[kind]
+}
+
+// Changes 返回某种变化按 (db, key) 排序的前 N 个 key
+func (d *Diff) Changes(kind int) []*KeyChange {
+	return d.samples[kind]
+}
+
+// Growth 返回按内存净增长绝对值从大到小排序的前缀
+func (d *Diff) Growth() []*PrefixGrowth {
+	growth := make([]*PrefixGrowth, 0, len(d.growth))
+	for _, g := range d.growth {
+		if g.Keys != 0 || g.Bytes != 0 {
+			growth = append(growth, g)
+		}
+	}
+	sort.Slice(growth, func(i, j int) bool {
+		a, b := math.Abs(float64(growth[i].Bytes)), math.Abs(float64(growth[j].Bytes))
+		if a != b {
+			return a > b
+		}
+		return growth[i].Prefix < growth[j].Prefix
+	})
+	return growth
+}
+
+// Report 以文本表格的形式输出比较结果
+func (d *Diff) Report(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "# Summary\n")
+	for kind, name := range ChangeKindNames {
+		fmt.Fprintf(w, "%s\t%d\n", name, d.counts[kind])
+	}
+
+	for kind, name := range ChangeKindNames {
+		changes := d.samples[kind]
+		if len(changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n# %s (first %d)\n", name, len(changes))
+		fmt.Fprintf(w, "db\tkey\ttype\tttl\tbytes\n")
+		for _, c := range changes {
+			r := c.New
+			if r == nil {
+				r = c.Old
+			}
+			fmt.Fprintf(w, "%d\t%q\t%s\t%s\t%s\n", r.DB, r.Key,
+				diffField(c, func(r *MemoryRecord) string { return r.Type }),
+				diffField(c, func(r *MemoryRecord) string { return formatExpiry(r.Expiry) }),
+				diffField(c, func(r *MemoryRecord) string { return fmt.Sprint(r.Size) }))
+		}
+	}
+
+	if d.prefix != nil {
+		fmt.Fprintf(w, "\n# Net growth by prefix\n")
+		fmt.Fprintf(w, "prefix\tkeys\tbytes\n")
+		for _, g := range d.Growth() {
+			fmt.Fprintf(w, "%q\t%+d\t%+d\n", g.Prefix, g.Keys, g.Bytes)
+		}
+	}
+	return w.Flush()
+}
+
+// diffField 输出一个字段的旧值和新值，没有变化时只输出一个
+func diffField(c *KeyChange, field func(*MemoryRecord) string) string {
+	switch {
+	case c.Old == nil:
+		return field(c.New)
+	case c.New == nil:
+		return field(c.Old)
+	}
+	old, cur := field(c.Old), field(c.New)
+	if old == cur {
+		return cur
+	}
+	return old + " -> " + cur
+}
+
+func formatExpiry(expiry int64) string {
+	if expiry == 0 {
+		return "-"
+	}
+	return time.Unix(0, expiry*int64(time.Millisecond)).Format(time.RFC3339)
+}
+
+// digestHandler 在估算内存的同时计算 value 的摘要。
+// list 和 stream 按顺序计算；hash、set、zset 把每个元素的哈希相加，与元素顺序和编码无关。
+type digestHandler struct {
+	rdb.NopHandler
+
+	digest uint64
+}
+
+func newDigestHandler(callback func(r *MemoryRecord, digest uint64)) rdb.Handler {
+	d := &digestHandler{}
+	// digestHandler 在前，保证 MemoryEstimator 回调时摘要已经计算完成
+	return rdb.MultiHandler(d, NewMemoryEstimator(func(r *MemoryRecord) {
+		callback(r, d.digest)
+	}))
+}
+
+func (d *digestHandler) Set(key, value []byte, info *rdb.KeyInfo) {
+	d.digest = hashParts(value)
+}
+
+func (d *digestHandler) StartHash(key []byte, length int64, info *rdb.KeyInfo) { d.digest = 0 }
+func (d *digestHandler) Hset(key, field, value []byte)                         { d.digest += hashParts(field, value) }
+
+func (d *digestHandler) StartSet(key []byte, cardinality int64, info *rdb.KeyInfo) { d.digest = 0 }
+func (d *digestHandler) Sadd(key, member []byte)                                   { d.digest += hashParts(member) }
+
+func (d *digestHandler) StartZSet(key []byte, cardinality int64, info *rdb.KeyInfo) { d.digest = 0 }
+func (d *digestHandler) Zadd(key []byte, score float64, member []byte) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(score))
+	d.digest += hashParts(member, b[:])
+}
+
+func (d *digestHandler) StartList(key []byte, length int64, info *rdb.KeyInfo) { d.digest = 0 }
+func (d *digestHandler) Rpush(key, value []byte)                               { d.digest = chain(d.digest, value) }
+
+func (d *digestHandler) StartStream(key []byte, info *rdb.KeyInfo) { d.digest = 0 }
+func (d *digestHandler) StreamEntry(key []byte, entry *rdb.StreamEntry) {
+	d.digest = chain(d.digest, []byte(entry.ID.String()))
+	for _, f := range entry.Fields {
+		d.digest = chain(d.digest, f)
+	}
+}
+
+func (d *digestHandler) Module(key []byte, module string, value interface{}, info *rdb.KeyInfo) {
+	d.digest = hashParts([]byte(module), []byte(fmt.Sprintf("%v", value)))
+}
+
+// hashParts 计算若干字节串的哈希，各部分之间带上长度以避免拼接产生歧义
+func hashParts(parts ...[]byte) uint64 {
+	h := fnv.New64a()
+	var b [8]byte
+	for _, p := range parts {
+		binary.LittleEndian.PutUint64(b[:], uint64(len(p)))
+		h.Write(b[:])
+		h.Write(p)
+	}
+	return h.Sum64()
+}
+
+// chain 把 value 按顺序合并到摘要 digest 中
+func chain(digest uint64, value []byte) uint64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], digest)
+	return hashParts(b[:], value)
+}
@@ -0,0 +1,222 @@
+package analyzer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ssp4599815/monitors/redis/rdb"
+)
+
+// TTL 分布的分桶：没有过期时间、生成 RDB 时已过期，以及按数量级划分的剩余 TTL
+const (
+	BucketNoTTL = iota
+	BucketExpired
+	BucketLess1m
+	BucketLess10m
+	BucketLess1h
+	BucketLess6h
+	BucketLess1d
+	BucketLess7d
+	BucketLess30d
+	BucketMore30d
+	bucketCount
+)
+
+// BucketNames 是各分桶在报告中的名字
+var BucketNames = [bucketCount]string{"no ttl", "expired", "<1m", "<10m", "<1h", "<6h", "<1d", "<7d", "<30d", ">=30d"}
+
+var bucketBounds = []time.Duration{
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+}
+
+// ExpiryStat 是一组 key 的 TTL 分布
+type ExpiryStat struct {
+	Keys    uint64
+	Buckets [bucketCount]uint64
+}
+
+// ExpiryAnalyzer 统计 key 的 TTL 分布，TTL 相对于 RDB 的生成时间（aux 字段 ctime）计算。
+//
+// 它需要同时作为 rdb.Handler 接收 aux 字段，并作为 MemoryEstimator 的 callback 接收 key，
+// 一般通过 rdb.MultiHandler(estimator, analyzer) 使用。
+type ExpiryAnalyzer struct {
+	rdb.NopHandler
+
+	// DumpTime 为 RDB 的生成时间，毫秒时间戳；RDB 中有 ctime 时会被覆盖
+	DumpTime int64
+	// CliffWindow 为统计集中过期时的时间窗口，小于 1ms 时按 1ms 统计
+	CliffWindow time.Duration
+
+	prefix   func(key string) string
+	dbs      map[int]*ExpiryStat
+	prefixes map[string]*ExpiryStat
+	cliffs   map[int64]uint64 // 窗口起始时间 -> 在该窗口内过期的 key 数
+}
+
+// NewExpiryAnalyzer 创建一个 ExpiryAnalyzer，prefix 不为 nil 时同时按前缀统计
+func NewExpiryAnalyzer(prefix func(key string) string) *ExpiryAnalyzer {
+	return &ExpiryAnalyzer{
+		DumpTime:    time.Now().UnixNano() / int64(time.Millisecond),
+		CliffWindow: time.Minute,
+		prefix:      prefix,
+		dbs:         make(map[int]*ExpiryStat),
+		prefixes:    make(map[string]*ExpiryStat),
+		cliffs:      make(map[int64]uint64),
+	}
+}
+
+func (e *ExpiryAnalyzer) Aux(key, value []byte) {
+	if string(key) != "ctime" {
+		return
+	}
+	if ctime, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+		e.DumpTime = ctime * 1000
+	}
+}
+
+// Bucket 返回过期时间 expiry（毫秒时间戳）所在的分桶
+func (e *ExpiryAnalyzer) Bucket(expiry int64) int {
+	if expiry == 0 {
+		return BucketNoTTL
+	}
+	ttl := time.Duration(expiry-e.DumpTime) * time.Millisecond
+	if ttl <= 0 {
+		return BucketExpired
+	}
+	for i, bound := range bucketBounds {
+		if ttl < bound {
+			return BucketLess1m + i
+		}
+	}
+	return BucketMore30d
+}
+
+// Add 统计一个 key，可以直接作为 NewMemoryEstimator 的 callback
+func (e *ExpiryAnalyzer) Add(r *MemoryRecord) {
+	bucket := e.Bucket(r.Expiry)
+	s, ok := e.dbs[r.DB]
+	if !ok {
+		s = &ExpiryStat{}
+		e.dbs[r.DB] = s
+	}
+	s.add(bucket)
+
+	if e.prefix != nil {
+		prefix := e.prefix(r.Key)
+		s, ok := e.prefixes[prefix]
+		if !ok {
+			s = &ExpiryStat{}
+			e.prefixes[prefix] = s
+		}
+		s.add(bucket)
+	}
+
+	if bucket > BucketExpired {
+		window := int64(e.CliffWindow / time.Millisecond)
+		if window < 1 {
+			window = 1 // 过期时间的精度是毫秒，小于 1ms 的窗口按 1ms 统计
+		}
+		e.cliffs[r.Expiry/window*window]++
+	}
+}
+
+func (s *ExpiryStat) add(bucket int) {
+	s.Keys++
+	s.Buckets[bucket]++
+}
+
+// DB 返回某个数据库的 TTL 分布
+func (e *ExpiryAnalyzer) DB(db int) *ExpiryStat {
+	return e.dbs[db]
+}
+
+// Prefix 返回某个前缀的 TTL 分布
+func (e *ExpiryAnalyzer) Prefix(prefix string) *ExpiryStat {
+	return e.prefixes[prefix]
+}
+
+// Cliff 是一个时间窗口内集中过期的 key 数
+type Cliff struct {
+	Start time.Time
+	Keys  uint64
+}
+
+// Cliffs 返回过期 key 数最多的 n 个时间窗口
+func (e *ExpiryAnalyzer) Cliffs(n int) []Cliff {
+	cliffs := make([]Cliff, 0, len(e.cliffs))
+	for start, keys := range e.cliffs {
+		cliffs = append(cliffs, Cliff{Start: time.Unix(0, start*int64(time.Millisecond)), Keys: keys})
+	}
+	sort.Slice(cliffs, func(i, j int) bool {
+		if cliffs[i].Keys != cliffs[j].Keys {
+			return cliffs[i].Keys > cliffs[j].Keys
+		}
+		return cliffs[i].Start.Before(cliffs[j].Start)
+	})
+	if n > 0 && len(cliffs) > n {
+		cliffs = cliffs[:n]
+	}
+	return cliffs
+}
+
+// Report 以文本表格的形式输出各数据库、各前缀的 TTL 分布和集中过期的时间窗口
+func (e *ExpiryAnalyzer) Report(out io.Writer, cliffs int) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "# TTL distribution relative to %s\n", time.Unix(0, e.DumpTime*int64(time.Millisecond)).Format(time.RFC3339))
+
+	dbs := make([]int, 0, len(e.dbs))
+	for db := range e.dbs {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	writeBucketHeader(w, "db")
+	for _, db := range dbs {
+		writeBucketRow(w, "db"+strconv.Itoa(db), e.dbs[db])
+	}
+
+	if len(e.prefixes) > 0 {
+		prefixes := make([]string, 0, len(e.prefixes))
+		for prefix := range e.prefixes {
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Strings(prefixes)
+		fmt.Fprintln(w)
+		writeBucketHeader(w, "prefix")
+		for _, prefix := range prefixes {
+			writeBucketRow(w, strconv.Quote(prefix), e.prefixes[prefix])
+		}
+	}
+
+	fmt.Fprintf(w, "\n# Top %d expiry windows (%s)\n", cliffs, e.CliffWindow)
+	fmt.Fprintf(w, "window start\tkeys\n")
+	for _, c := range e.Cliffs(cliffs) {
+		fmt.Fprintf(w, "%s\t%d\n", c.Start.Format(time.RFC3339), c.Keys)
+	}
+	return w.Flush()
+}
+
+func writeBucketHeader(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s\tkeys", name)
+	for _, b := range BucketNames {
+		fmt.Fprintf(w, "\t%s", b)
+	}
+	fmt.Fprintln(w)
+}
+
+func writeBucketRow(w io.Writer, name string, s *ExpiryStat) {
+	fmt.Fprintf(w, "%s\t%d", name, s.Keys)
+	for _, n := range s.Buckets {
+		fmt.Fprintf(w, "\t%d", n)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,102 @@
+package analyzer
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// LFUInitVal 是新 key 的 LFU 计数器初始值
+	LFUInitVal = 5
+	// DefaultLFULogFactor 是 lfu-log-factor 的默认值
+	DefaultLFULogFactor = 10
+)
+
+// LFUAccesses 把 8 位的对数 LFU 计数器换算成大致的访问次数。
+//
+// Redis 每次访问时以 1/((counter-LFUInitVal)*logFactor+1) 的概率把计数器加 1，
+// 所以从 LFUInitVal 增加到 counter 平均需要 sum((i-LFUInitVal)*logFactor+1) 次访问。
+func LFUAccesses(counter, logFactor int) uint64 {
+	if counter <= LFUInitVal {
+		return 0
+	}
+	n := uint64(counter - LFUInitVal)
+	return n + uint64(logFactor)*n*(n-1)/2
+}
+
+// HotKeys 根据 RDB 中记录的 LFU 计数器找出最热的 key，根据 LRU idle 时间找出最冷的 key。
+// 只有 maxmemory-policy 为 LFU 或 LRU 相关策略时 RDB 中才会记录这些信息。
+type HotKeys struct {
+	N         int
+	LogFactor int
+
+	hottest *topKeys
+	coldest *topKeys
+	lfuKeys uint64
+	lruKeys uint64
+}
+
+func NewHotKeys(n int) *HotKeys {
+	return &HotKeys{
+		N:         n,
+		LogFactor: DefaultLFULogFactor,
+		hottest:   newTopKeys(n, byFreq),
+		coldest:   newTopKeys(n, byIdle),
+	}
+}
+
+func byFreq(a, b *MemoryRecord) bool {
+	if a.Freq != b.Freq {
+		return a.Freq < b.Freq
+	}
+	return bySize(a, b)
+}
+
+func byIdle(a, b *MemoryRecord) bool {
+	if a.Idle != b.Idle {
+		return a.Idle < b.Idle
+	}
+	return bySize(a, b)
+}
+
+// Add 统计一个 key，可以直接作为 NewMemoryEstimator 的 callback
+func (h *HotKeys) Add(r *MemoryRecord) {
+	if r.Freq >= 0 {
+		h.lfuKeys++
+		h.hottest.add(r)
+	}
+	if r.Idle >= 0 {
+		h.lruKeys++
+		h.coldest.add(r)
+	}
+}
+
+// Hottest 返回 LFU 计数器最大的 N 个 key
+func (h *HotKeys) Hottest() []*MemoryRecord {
+	return h.hottest.sorted()
+}
+
+// Coldest 返回 LRU idle 时间最长的 N 个 key
+func (h *HotKeys) Coldest() []*MemoryRecord {
+	return h.coldest.sorted()
+}
+
+// Report 以文本表格的形式输出最热和最冷的 key
+func (h *HotKeys) Report(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "# Top %d hottest keys by LFU counter (%d keys with LFU info)\n", h.N, h.lfuKeys)
+	fmt.Fprintf(w, "db\ttype\tkey\tlfu counter\test. accesses\tbytes\n")
+	for _, r := range h.Hottest() {
+		fmt.Fprintf(w, "%d\t%s\t%q\t%d\t%d\t%d\n", r.DB, r.Type, r.Key, r.Freq, LFUAccesses(r.Freq, h.LogFactor), r.Size)
+	}
+
+	fmt.Fprintf(w, "\n# Top %d coldest keys by LRU idle time (%d keys with LRU info)\n", h.N, h.lruKeys)
+	fmt.Fprintf(w, "db\ttype\tkey\tidle\tbytes\n")
+	for _, r := range h.Coldest() {
+		fmt.Fprintf(w, "%d\t%s\t%q\t%s\t%d\n", r.DB, r.Type, r.Key, time.Duration(r.Idle)*time.Second, r.Size)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,384 @@
+package analyzer
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+
+	"github.com/ssp4599815/monitors/redis/rdb"
+)
+
+/*
+按照 redis-rdb-tools 的方式估算每个 key 在 Redis 中占用的内存，
+以 64 位、jemalloc 分配器为准，只是一个近似值：
+  key 占用 = 主字典 dictEntry + key 的 sds + value 的 robj + 过期字典的 dictEntry
+           + 不同编码下 value 本身的开销
+*/
+
+const (
+	pointerSize = 8
+	longSize    = 8
+
+	// Redis 共享的小整数对象 0 ~ 9999，不额外占用内存
+	redisSharedIntegers = 10000
+	// 跳表的平均层数 1/(1-p)，p = 0.25
+	zsetAverageLevel = 4.0 / 3.0
+)
+
+// MemoryRecord 是一个 key 的内存估算结果
+type MemoryRecord struct {
+	DB                int
+	Key               string
+	Type              string
+	Encoding          string
+	Size              uint64 // 估算的内存字节数
+	NumElements       int64  // 元素个数，string 为 1
+	LenLargestElement int64  // 最大元素的字节数
+	Expiry            int64  // 过期时间，毫秒时间戳，0 表示没有过期时间
+	Idle              int64  // LRU idle 秒数，-1 表示没有
+	Freq              int    // LFU 计数器，-1 表示没有
+}
+
+// MemoryEstimator 是一个 rdb.Handler，每解析完一个 key 就把估算结果交给 callback
+type MemoryEstimator struct {
+	rdb.NopHandler
+
+	callback func(*MemoryRecord)
+	version  int
+	current  *MemoryRecord
+	skiplist bool // 当前 zset 是否为 skiplist 编码
+}
+
+func NewMemoryEstimator(callback func(*MemoryRecord)) *MemoryEstimator {
+	return &MemoryEstimator{callback: callback}
+}
+
+func (m *MemoryEstimator) StartRDB(version int) {
+	m.version = version
+}
+
+func (m *MemoryEstimator) Set(key, value []byte, info *rdb.KeyInfo) {
+	m.start(key, info)
+	m.current.Size += m.sizeofString(value)
+	m.current.NumElements = 1
+	m.current.LenLargestElement = elementLength(value)
+	m.end(info)
+}
+
+func (m *MemoryEstimator) StartHash(key []byte, length int64, info *rdb.KeyInfo) {
+	m.start(key, info)
+	if info.Encoding == rdb.EncodingHashtable {
+		m.current.Size += hashtableOverhead(length)
+	}
+}
+
+func (m *MemoryEstimator) Hset(key, field, value []byte) {
+	m.element(field, value)
+	if m.current.Encoding == rdb.EncodingHashtable {
+		m.current.Size += m.sizeofString(field) + m.sizeofString(value) + hashtableEntryOverhead()
+	}
+}
+
+func (m *MemoryEstimator) EndHash(key []byte, info *rdb.KeyInfo) {
+	m.end(info)
+}
+
+func (m *MemoryEstimator) StartSet(key []byte, cardinality int64, info *rdb.KeyInfo) {
+	m.start(key, info)
+	if info.Encoding == rdb.EncodingHashtable {
+		m.current.Size += hashtableOverhead(cardinality)
+	}
+}
+
+func (m *MemoryEstimator) Sadd(key, member []byte) {
+	m.element(member)
+	if m.current.Encoding == rdb.EncodingHashtable {
+		m.current.Size += m.sizeofString(member) + hashtableEntryOverhead()
+	}
+}
+
+func (m *MemoryEstimator) EndSet(key []byte, info *rdb.KeyInfo) {
+	m.end(info)
+}
+
+func (m *MemoryEstimator) StartList(key []byte, length int64, info *rdb.KeyInfo) {
+	m.start(key, info)
+	if info.Encoding == rdb.EncodingLinkedList {
+		m.current.Size += linkedlistOverhead()
+	}
+}
+
+func (m *MemoryEstimator) Rpush(key, value []byte) {
+	m.element(value)
+	if m.current.Encoding == rdb.EncodingLinkedList {
+		m.current.Size += m.sizeofString(value) + linkedlistEntryOverhead() + robjOverhead()
+	}
+}
+
+func (m *MemoryEstimator) EndList(key []byte, info *rdb.KeyInfo) {
+	if info.Encoding == rdb.EncodingQuicklist {
+		m.current.Size += quicklistOverhead(info.Nodes)
+	}
+	m.end(info)
+}
+
+func (m *MemoryEstimator) StartZSet(key []byte, cardinality int64, info *rdb.KeyInfo) {
+	m.start(key, info)
+	m.skiplist = info.Encoding == rdb.EncodingSkiplist
+	if m.skiplist {
+		m.current.Size += skiplistOverhead(cardinality)
+	}
+}
+
+func (m *MemoryEstimator) Zadd(key []byte, score float64, member []byte) {
+	m.element(member)
+	if m.skiplist {
+		m.current.Size += 8 + m.sizeofString(member) + skiplistEntryOverhead()
+	}
+}
+
+func (m *MemoryEstimator) EndZSet(key []byte, info *rdb.KeyInfo) {
+	m.end(info)
+}
+
+func (m *MemoryEstimator) StartStream(key []byte, info *rdb.KeyInfo) {
+	m.start(key, info)
+}
+
+func (m *MemoryEstimator) StreamEntry(key []byte, entry *rdb.StreamEntry) {
+	m.current.NumElements++
+	for _, field := range entry.Fields {
+		if l := int64(len(field)); l > m.current.LenLargestElement {
+			m.current.LenLargestElement = l
+		}
+	}
+}
+
+func (m *MemoryEstimator) EndStream(key []byte, meta *rdb.StreamMeta, info *rdb.KeyInfo) {
+	// stream 结构体本身 + 每个 listpack 节点对应一个 rax 节点和 16 字节的 master id
+	m.current.Size += mallocOverhead(3*pointerSize+4*longSize) + info.Nodes*(raxNodeOverhead()+16)
+	for i := range meta.Groups {
+		g := &meta.Groups[i]
+		m.current.Size += mallocOverhead(uint64(len(g.Name))+2*longSize+2*pointerSize) + raxNodeOverhead()
+		// 每个 PEL 元素是一个 streamNACK，同时在消费组和消费者的 rax 中各有一份
+		m.current.Size += uint64(len(g.Pending)) * (mallocOverhead(longSize+longSize+pointerSize) + 2*raxNodeOverhead())
+		for _, c := range g.Consumers {
+			m.current.Size += mallocOverhead(uint64(len(c.Name))+2*longSize+2*pointerSize) + raxNodeOverhead()
+		}
+	}
+	m.end(info)
+}
+
+// Module 以解码器报告的内存或 value 在 RDB 中的字节数估算 module 类型的 key
+func (m *MemoryEstimator) Module(key []byte, module string, value interface{}, info *rdb.KeyInfo) {
+	m.start(key, info)
+	m.current.NumElements = 1
+	m.end(info)
+}
+
+// start 开始统计一个 key，先计算所有类型都有的顶层开销
+func (m *MemoryEstimator) start(key []byte, info *rdb.KeyInfo) {
+	m.current = &MemoryRecord{
+		DB:       info.DB,
+		Key:      string(key),
+		Type:     info.Type,
+		Encoding: info.Encoding,
+		Expiry:   info.Expiry,
+		Idle:     info.Idle,
+		Freq:     info.Freq,
+	}
+	m.current.Size = m.topLevelObjectOverhead(key, info.Expiry)
+}
+
+// element 统计一个元素，hash 的 field 和 value 算作一个元素
+func (m *MemoryEstimator) element(parts ...[]byte) {
+	m.current.NumElements++
+	for _, b := range parts {
+		if l := elementLength(b); l > m.current.LenLargestElement {
+			m.current.LenLargestElement = l
+		}
+	}
+}
+
+func (m *MemoryEstimator) end(info *rdb.KeyInfo) {
+	// ziplist/listpack/intset/zipmap 都是一整块连续内存
+	if info.SizeOfValue > 0 {
+		m.current.Size += mallocOverhead(info.SizeOfValue)
+	}
+	m.callback(m.current)
+	m.current = nil
+}
+
+func (m *MemoryEstimator) topLevelObjectOverhead(key []byte, expiry int64) uint64 {
+	return hashtableEntryOverhead() + m.sizeofString(key) + robjOverhead() + keyExpiryOverhead(expiry)
+}
+
+// sizeofString 返回一个字符串以 sds 保存时占用的内存
+func (m *MemoryEstimator) sizeofString(b []byte) uint64 {
+	if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		// 可以用整数表示的字符串直接保存在 robj 的指针里
+		if n >= 0 && n < redisSharedIntegers {
+			return 0
+		}
+		return 8
+	}
+
+	l := uint64(len(b))
+	if m.version > 0 && m.version < 7 {
+		// Redis 3.2 之前的 sds 头固定为 8 字节
+		return mallocOverhead(l + 8 + 1)
+	}
+	switch {
+	case l < 1<<5:
+		return mallocOverhead(l + 1 + 1)
+	case l < 1<<8:
+		return mallocOverhead(l + 1 + 2 + 1)
+	case l < 1<<16:
+		return mallocOverhead(l + 1 + 4 + 1)
+	case l < 1<<32:
+		return mallocOverhead(l + 1 + 8 + 1)
+	}
+	return mallocOverhead(l + 1 + 16 + 1)
+}
+
+// elementLength 返回一个元素的长度，可以用整数表示的元素按 8 字节计算
+func elementLength(b []byte) int64 {
+	if _, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		return 8
+	}
+	return int64(len(b))
+}
+
+func robjOverhead() uint64 {
+	return pointerSize + 8
+}
+
+func hashtableEntryOverhead() uint64 {
+	// key 指针、value 指针和 next 指针
+	return mallocOverhead(2*pointerSize + pointerSize)
+}
+
+func keyExpiryOverhead(expiry int64) uint64 {
+	if expiry == 0 {
+		return 0
+	}
+	// 过期字典中的 dictEntry 以及保存过期时间的 8 字节
+	return hashtableEntryOverhead() + 8
+}
+
+// hashtableOverhead 返回一个 dict 的固定开销，包括两个 dictht 和 bucket 数组
+func hashtableOverhead(size int64) uint64 {
+	return 4 + 7*longSize + 4*pointerSize + uint64(float64(nextPower(size)*pointerSize)*1.5)
+}
+
+func linkedlistOverhead() uint64 {
+	// head、tail、dup、free、match 指针和 len
+	return mallocOverhead(5*pointerSize + longSize)
+}
+
+func linkedlistEntryOverhead() uint64 {
+	// prev、next、value 指针
+	return mallocOverhead(3 * pointerSize)
+}
+
+func quicklistOverhead(nodes uint64) uint64 {
+	quicklist := mallocOverhead(2*pointerSize + longSize + 2*4)
+	node := mallocOverhead(3*pointerSize + 4 + 4)
+	return quicklist + nodes*node
+}
+
+func skiplistOverhead(size int64) uint64 {
+	// zset 结构中的 dict + zskiplist 结构 + 32 层的头节点
+	return 2*pointerSize + hashtableOverhead(size) + mallocOverhead(2*pointerSize+16) + mallocOverhead(pointerSize+8+32*(pointerSize+8))
+}
+
+func skiplistEntryOverhead() uint64 {
+	level := uint64(math.Round(zsetAverageLevel * (pointerSize + 8)))
+	return hashtableEntryOverhead() + mallocOverhead(2*pointerSize+8+level)
+}
+
+func raxNodeOverhead() uint64 {
+	return mallocOverhead(4 + pointerSize*2)
+}
+
+func nextPower(size int64) uint64 {
+	power := uint64(1)
+	for power <= uint64(size) {
+		power <<= 1
+	}
+	return power
+}
+
+// mallocOverhead 把申请的内存大小向上取整到 jemalloc 的 size class
+func mallocOverhead(size uint64) uint64 {
+	if size <= 8 {
+		return 8
+	}
+	// 每个 2 的幂区间 (2^k, 2^(k+1)] 被分成 4 个 size class，最小间隔为 16
+	k := uint(bits.Len64(size-1) - 1)
+	spacing := uint64(1) << k >> 2
+	if spacing < 16 {
+		spacing = 16
+	}
+	return (size + spacing - 1) / spacing * spacing
+}
+
+// MemoryCSVWriter 把内存估算结果以 CSV 格式写出，格式和 redis-rdb-tools 的 memory 报告一致
+type MemoryCSVWriter struct {
+	w *csv.Writer
+}
+
+func NewMemoryCSVWriter(w io.Writer) *MemoryCSVWriter {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"database", "type", "key", "size_in_bytes", "encoding", "num_elements", "len_largest_element", "expiry"})
+	return &MemoryCSVWriter{w: cw}
+}
+
+// Write 写出一条记录，可以直接作为 NewMemoryEstimator 的 callback
+func (c *MemoryCSVWriter) Write(record *MemoryRecord) {
+	expiry := ""
+	if record.Expiry != 0 {
+		expiry = strconv.FormatInt(record.Expiry, 10)
+	}
+	_ = c.w.Write([]string{
+		strconv.Itoa(record.DB),
+		record.Type,
+		record.Key,
+		strconv.FormatUint(record.Size, 10),
+		record.Encoding,
+		strconv.FormatInt(record.NumElements, 10),
+		strconv.FormatInt(record.LenLargestElement, 10),
+		expiry,
+	})
+}
+
+// Flush 把缓冲区的数据写出，返回写入过程中遇到的错误
+func (c *MemoryCSVWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// NewMemoryPipeline 返回并行估算内存的 rdb.Pipeline，每个 worker 使用自己的 MemoryEstimator，
+// callback 在合并 goroutine 中按 key 在文件中的顺序调用，结果与顺序解析时相同
+func NewMemoryPipeline(workers int, callback func(*MemoryRecord)) *rdb.Pipeline {
+	return &rdb.Pipeline{
+		Workers: workers,
+		NewDecoder: func() func(v *rdb.RawValue) (interface{}, error) {
+			var record *MemoryRecord
+			m := NewMemoryEstimator(func(r *MemoryRecord) { record = r })
+			return func(v *rdb.RawValue) (interface{}, error) {
+				record = nil
+				m.StartRDB(v.Version)
+				err := v.Decode(m)
+				return record, err
+			}
+		},
+		Merge: func(v *rdb.RawValue, result interface{}) {
+			if record, ok := result.(*MemoryRecord); ok && record != nil {
+				callback(record)
+			}
+		},
+	}
+}
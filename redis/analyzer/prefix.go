@@ -0,0 +1,146 @@
+package analyzer
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	// NoPrefix 是没有分隔符的 key 所在的分组
+	NoPrefix = "(none)"
+	// OtherPrefix 是没有匹配任何正则的 key 所在的分组
+	OtherPrefix = "(other)"
+)
+
+// PrefixStat 是一个 key 前缀的汇总
+type PrefixStat struct {
+	Prefix       string
+	Keys         uint64
+	Bytes        uint64
+	ExpiringKeys uint64
+	Types        map[string]uint64 // 各类型的 key 个数
+}
+
+// TTLCoverage 返回设置了过期时间的 key 所占的比例
+func (p *PrefixStat) TTLCoverage() float64 {
+	if p.Keys == 0 {
+		return 0
+	}
+	return float64(p.ExpiringKeys) / float64(p.Keys)
+}
+
+// PrefixAggregator 按照 key 的前缀分组统计。
+//
+// 默认按 Separator 切分 key 并取前 Depth 段作为前缀，例如 Depth 为 2 时
+// "order:item:1001" 的前缀为 "order:item"；设置了 Patterns 时改为按正则分组，
+// 正则中有捕获组时以第一个捕获组的内容作为前缀，否则以正则本身作为前缀。
+type PrefixAggregator struct {
+	Separator string
+	Depth     int
+	Patterns  []*regexp.Regexp
+
+	stats map[string]*PrefixStat
+}
+
+func NewPrefixAggregator(separator string, depth int, patterns []*regexp.Regexp) *PrefixAggregator {
+	if depth < 1 {
+		depth = 1
+	}
+	return &PrefixAggregator{
+		Separator: separator,
+		Depth:     depth,
+		Patterns:  patterns,
+		stats:     make(map[string]*PrefixStat),
+	}
+}
+
+// Prefix 返回 key 所属的前缀
+func (p *PrefixAggregator) Prefix(key string) string {
+	if len(p.Patterns) > 0 {
+		for _, re := range p.Patterns {
+			m := re.FindStringSubmatch(key)
+			if m == nil {
+				continue
+			}
+			if len(m) > 1 {
+				return m[1]
+			}
+			return re.String()
+		}
+		return OtherPrefix
+	}
+
+	parts := strings.SplitN(key, p.Separator, p.Depth+1)
+	switch {
+	case len(parts) > p.Depth:
+		return strings.Join(parts[:p.Depth], p.Separator)
+	case len(parts) > 1:
+		// 分隔符不够 Depth 段时，去掉最后一段（通常是 id）
+		return strings.Join(parts[:len(parts)-1], p.Separator)
+	}
+	return NoPrefix
+}
+
+// Add 统计一个 key，可以直接作为 NewMemoryEstimator 的 callback
+func (p *PrefixAggregator) Add(r *MemoryRecord) {
+	prefix := p.Prefix(r.Key)
+	s, ok := p.stats[prefix]
+	if !ok {
+		s = &PrefixStat{Prefix: prefix, Types: make(map[string]uint64)}
+		p.stats[prefix] = s
+	}
+	s.Keys++
+	s.Bytes += r.Size
+	if r.Expiry != 0 {
+		s.ExpiringKeys++
+	}
+	s.Types[r.Type]++
+}
+
+// Stats 返回按内存占用从大到小排序的统计结果
+func (p *PrefixAggregator) Stats() []*PrefixStat {
+	stats := make([]*PrefixStat, 0, len(p.stats))
+	for _, s := range p.stats {
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Bytes != stats[j].Bytes {
+			return stats[i].Bytes > stats[j].Bytes
+		}
+		return stats[i].Prefix < stats[j].Prefix
+	})
+	return stats
+}
+
+// Report 以文本表格的形式输出前 n 个前缀，n <= 0 时输出全部
+func (p *PrefixAggregator) Report(out io.Writer, n int) error {
+	stats := p.Stats()
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "prefix\tkeys\tbytes\tttl coverage\ttypes\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%q\t%d\t%d\t%.1f%%\t%s\n", s.Prefix, s.Keys, s.Bytes, s.TTLCoverage()*100, formatTypeMix(s.Types))
+	}
+	return w.Flush()
+}
+
+// formatTypeMix 把各类型的 key 个数格式化为 "hash=3,string=10"
+func formatTypeMix(types map[string]uint64) string {
+	names := make([]string, 0, len(types))
+	for typ := range types {
+		names = append(names, typ)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, typ := range names {
+		parts[i] = fmt.Sprintf("%s=%d", typ, types[typ])
+	}
+	return strings.Join(parts, ",")
+}
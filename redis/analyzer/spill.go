@@ -0,0 +1,257 @@
+package analyzer
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// diffRecord 是参与比较的一个 key 的摘要
+type diffRecord struct {
+	db     int
+	key    string
+	typ    string
+	expiry int64
+	size   uint64
+	digest uint64
+}
+
+// recordOverhead 是一个 diffRecord 在内存中除 key 以外占用的字节数的估算
+const recordOverhead = 96
+
+func recordLess(a, b *diffRecord) bool {
+	if a.db != b.db {
+		return a.db < b.db
+	}
+	return a.key < b.key
+}
+
+// recordSorter 按 (db, key) 对 diffRecord 做外部排序：
+// 内存中的记录超过 budget 字节时排序后写入 dir 下的临时文件，最后把所有文件和内存中的记录归并
+type recordSorter struct {
+	dir    string
+	budget int
+
+	records []diffRecord
+	bytes   int
+	runs    []string // 已经写入的有序临时文件
+}
+
+func newRecordSorter(dir string, budget int) *recordSorter {
+	return &recordSorter{dir: dir, budget: budget}
+}
+
+func (s *recordSorter) add(r diffRecord) error {
+	s.records = append(s.records, r)
+	s.bytes += len(r.key) + recordOverhead
+	if s.bytes < s.budget {
+		return nil
+	}
+	return s.spill()
+}
+
+func (s *recordSorter) sort() {
+	sort.Slice(s.records, func(i, j int) bool { return recordLess(&s.records[i], &s.records[j]) })
+}
+
+// spill 把内存中的记录排序后写入一个临时文件
+func (s *recordSorter) spill() error {
+	s.sort()
+	f, err := ioutil.TempFile(s.dir, "rdb-diff-")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f.Name())
+	w := bufio.NewWriterSize(f, 64<<10)
+	for i := range s.records {
+		if err = writeRecord(w, &s.records[i]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	s.records, s.bytes = s.records[:0], 0
+	return err
+}
+
+// iterator 返回按 (db, key) 有序读取所有记录的迭代器，之后不能再 add
+func (s *recordSorter) iterator() (*recordIterator, error) {
+	s.sort()
+	it := &recordIterator{}
+	if len(s.records) > 0 {
+		it.sources = append(it.sources, &sliceSource{records: s.records})
+	}
+	for _, name := range s.runs {
+		f, err := os.Open(name)
+		if err != nil {
+			it.close()
+			return nil, err
+		}
+		it.files = append(it.files, f)
+		it.sources = append(it.sources, &fileSource{rd: bufio.NewReaderSize(f, 64<<10)})
+	}
+	for _, src := range it.sources {
+		if err := src.advance(); err != nil {
+			it.close()
+			return nil, err
+		}
+		if !src.done() {
+			it.heap = append(it.heap, src)
+		}
+	}
+	heap.Init(&it.heap)
+	return it, nil
+}
+
+// remove 删除临时文件
+func (s *recordSorter) remove() {
+	for _, name := range s.runs {
+		os.Remove(name)
+	}
+	s.runs, s.records = nil, nil
+}
+
+func writeRecord(w *bufio.Writer, r *diffRecord) error {
+	var b [binary.MaxVarintLen64]byte
+	put := func(n int) {
+		w.Write(b[:n])
+	}
+	put(binary.PutUvarint(b[:], uint64(r.db)))
+	put(binary.PutUvarint(b[:], uint64(len(r.key))))
+	w.WriteString(r.key)
+	put(binary.PutUvarint(b[:], uint64(len(r.typ))))
+	w.WriteString(r.typ)
+	put(binary.PutVarint(b[:], r.expiry))
+	put(binary.PutUvarint(b[:], r.size))
+	binary.LittleEndian.PutUint64(b[:], r.digest)
+	_, err := w.Write(b[:8])
+	return err
+}
+
+func readRecord(rd *bufio.Reader, r *diffRecord) error {
+	db, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return err // 记录之间的 io.EOF 表示文件结束
+	}
+	readString := func() (string, error) {
+		n, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return "", err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(rd, b)
+		return string(b), err
+	}
+	r.db = int(db)
+	if r.key, err = readString(); err != nil {
+		return unexpectedEOF(err)
+	}
+	if r.typ, err = readString(); err != nil {
+		return unexpectedEOF(err)
+	}
+	if r.expiry, err = binary.ReadVarint(rd); err != nil {
+		return unexpectedEOF(err)
+	}
+	if r.size, err = binary.ReadUvarint(rd); err != nil {
+		return unexpectedEOF(err)
+	}
+	var b [8]byte
+	if _, err = io.ReadFull(rd, b[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	r.digest = binary.LittleEndian.Uint64(b[:])
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// recordSource 是一个有序的记录序列，current 为当前记录
+type recordSource interface {
+	advance() error
+	done() bool
+	current() *diffRecord
+}
+
+type sliceSource struct {
+	records []diffRecord
+	pos     int // current 的下标 + 1
+}
+
+func (s *sliceSource) advance() error       { s.pos++; return nil }
+func (s *sliceSource) done() bool           { return s.pos > len(s.records) }
+func (s *sliceSource) current() *diffRecord { return &s.records[s.pos-1] }
+
+type fileSource struct {
+	rd  *bufio.Reader
+	r   diffRecord
+	eof bool
+}
+
+func (s *fileSource) advance() error {
+	err := readRecord(s.rd, &s.r)
+	if err == io.EOF {
+		s.eof = true
+		return nil
+	}
+	return err
+}
+func (s *fileSource) done() bool           { return s.eof }
+func (s *fileSource) current() *diffRecord { return &s.r }
+
+type sourceHeap []recordSource
+
+func (h sourceHeap) Len() int            { return len(h) }
+func (h sourceHeap) Less(i, j int) bool  { return recordLess(h[i].current(), h[j].current()) }
+func (h sourceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sourceHeap) Push(x interface{}) { *h = append(*h, x.(recordSource)) }
+func (h *sourceHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// recordIterator 归并多个有序的记录序列
+type recordIterator struct {
+	sources []recordSource
+	heap    sourceHeap
+	files   []*os.File
+}
+
+// next 返回下一条记录，没有更多记录时返回 nil
+func (it *recordIterator) next() (*diffRecord, error) {
+	if len(it.heap) == 0 {
+		return nil, nil
+	}
+	src := it.heap[0]
+	r := *src.current()
+	if err := src.advance(); err != nil {
+		return nil, err
+	}
+	if src.done() {
+		heap.Pop(&it.heap)
+	} else {
+		heap.Fix(&it.heap, 0)
+	}
+	return &r, nil
+}
+
+func (it *recordIterator) close() {
+	for _, f := range it.files {
+		f.Close()
+	}
+	it.files = nil
+}
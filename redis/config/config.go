@@ -1,6 +1,8 @@
 package config
 
 import (
+	"net"
+	"strings"
 	"time"
 )
 
@@ -9,6 +11,8 @@ type Config struct {
 	Redis   []RedisHost
 	Kafka   KafkaConfig
 	Email   EmailConfig
+	Alert   AlertRules // 慢日志报警规则的默认值，可以在 redis 的每个业务线中覆盖
+	Slowlog SlowlogConfig
 }
 
 // 监控相关配置
@@ -19,9 +23,82 @@ type MonitorConfig struct {
 
 // redis 相关配置
 type RedisHost struct {
-	Line     string   `yaml:"line"`
-	Password string   `yaml:"password"`
-	Addr     []string `yaml:"addr"`
+	Line        string     `yaml:"line"`
+	Password    string     `yaml:"password"`
+	Addr        []string   `yaml:"addr"`
+	Hosts       []string   `yaml:"hosts"`        // Filebeat 上报的 host.name，用来把 Kafka 或文件中的慢日志对应到这个业务线
+	KeyPatterns []string   `yaml:"key_patterns"` // 分析 RDB 时按正则对 key 分组，第一个捕获组作为前缀
+	Alert       AlertRules `yaml:"alert"`        // 这个业务线的报警规则，没有设置的项使用全局的 alert
+}
+
+// 慢日志报警相关配置，数值为 0 的规则不检查
+type AlertRules struct {
+	SlowPerMinute     int                `yaml:"slow_per_minute"`    // 一台主机每分钟的慢日志超过这个条数时报警
+	MaxDuration       time.Duration      `yaml:"max_duration"`       // 单条命令耗时超过这个值时报警，例如 100ms
+	DangerousCommands []DangerousCommand `yaml:"dangerous_commands"` // 出现就报警的危险命令
+	// 同一台主机上同一个指纹的 max_duration 和 dangerous_command 报警在这段时间内只报警一次，为 0 时使用 10m
+	Suppress time.Duration `yaml:"suppress"`
+}
+
+type DangerousCommand struct {
+	Cmd         string        `yaml:"cmd"`
+	MinDuration time.Duration `yaml:"min_duration"` // 耗时超过这个值才报警，例如只关心大 hash 上的 HGETALL
+}
+
+// RulesFor 返回业务线 line 的报警规则，业务线中没有设置的项使用全局的默认值
+func (c *Config) RulesFor(line string) AlertRules {
+	rules := c.Alert
+	for _, host := range c.Redis {
+		if host.Line != line {
+			continue
+		}
+		if host.Alert.SlowPerMinute > 0 {
+			rules.SlowPerMinute = host.Alert.SlowPerMinute
+		}
+		if host.Alert.MaxDuration > 0 {
+			rules.MaxDuration = host.Alert.MaxDuration
+		}
+		if len(host.Alert.DangerousCommands) > 0 {
+			rules.DangerousCommands = host.Alert.DangerousCommands
+		}
+		if host.Alert.Suppress > 0 {
+			rules.Suppress = host.Alert.Suppress
+		}
+		break
+	}
+	return rules
+}
+
+// LineOf 返回地址或主机名 host 所属的业务线，host 可以是 addr 中的 "ip:port"、只有 ip 或者 hosts 中的主机名，
+// 找不到时返回空字符串
+func (c *Config) LineOf(host string) string {
+	for _, h := range c.Redis {
+		for _, name := range h.Hosts {
+			if strings.EqualFold(name, host) {
+				return h.Line
+			}
+		}
+		for _, addr := range h.Addr {
+			if addr == host {
+				return h.Line
+			}
+			if ip, _, err := net.SplitHostPort(addr); err == nil && ip == host {
+				return h.Line
+			}
+		}
+	}
+	return ""
+}
+
+// 慢日志来源相关配置
+type SlowlogConfig struct {
+	// 慢日志的来源：kafka（默认）、poll、file、stdin 或者 memory。
+	// poll 直接在 redis 的 addr 上执行 SLOWLOG GET，file 和 stdin 读取 Filebeat 输出的 JSON 行，memory 只接收程序中通过 Push 提交的慢日志，配置为 memory 时没有输入，用于测试或者空跑检查配置和输出
+	Input        string        `yaml:"input"`
+	PollInterval time.Duration `yaml:"poll_interval"` // poll 的间隔
+	PollCount    int           `yaml:"poll_count"`    // poll 时每次最多读取的条数
+	File         string        `yaml:"file"`          // file 读取的文件
+	OffsetFile   string        `yaml:"offset_file"`   // 保存 file 已经处理完的位置，重启后从这里继续读取
 }
 
 // kafka 相关配置
@@ -0,0 +1,367 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ssp4599815/monitors/redis/rdb"
+	"github.com/ssp4599815/monitors/redis/resp"
+)
+
+// exportDump 用导出器 e 解析 redis/rdb/dumps 中的测试文件
+func exportDump(t *testing.T, name string, e interface {
+	rdb.Handler
+	Flush() error
+}) {
+	f, err := os.Open(path.Join("..", "rdb", "dumps", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = rdb.NewRDB(bufio.NewReader(f), e).Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSONLExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exportDump(t, "dump.rdb", NewJSONLExporter(&buf))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", buf.String())
+	}
+	want := []string{
+		`{"db":0,"key":"key","type":"string","encoding":"string","expiry":1574093693054,"value":"123"}`,
+		`{"db":0,"key":"hello","type":"string","encoding":"string","expiry":0,"value":"world"}`,
+		`{"db":0,"key":"hset_key","type":"hash","encoding":"ziplist","expiry":0,"value":{"field":"value"}}`,
+	}
+	for i, line := range lines {
+		if line != want[i] {
+			t.Fatalf("line %d = %s, want %s", i, line, want[i])
+		}
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("line %d is not valid json: %v", i, err)
+		}
+	}
+}
+
+func TestJSONLExporterStream(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewJSONLExporter(&buf)
+	info := &rdb.KeyInfo{Type: "stream", Encoding: "listpack", Idle: -1, Freq: -1}
+	id := func(ms uint64) rdb.StreamID { return rdb.StreamID{Ms: ms} }
+	e.StartStream([]byte("s"), info)
+	e.StreamEntry([]byte("s"), &rdb.StreamEntry{ID: id(1), Fields: [][]byte{[]byte("f"), []byte("v")}})
+	e.StreamEntry([]byte("s"), &rdb.StreamEntry{ID: id(2), Fields: [][]byte{[]byte("f"), []byte("w")}})
+	e.EndStream([]byte("s"), &rdb.StreamMeta{Length: 2, LastID: id(2), EntriesAdded: 2, Groups: []rdb.StreamGroup{
+		{Name: []byte("g1"), LastID: id(1), EntriesRead: 1},
+		{Name: []byte("g2"), LastID: id(1), EntriesRead: -1},
+	}}, info)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"db":0,"key":"s","type":"stream","encoding":"listpack","expiry":0,"value":{"entries":[` +
+		`{"id":"1-0","fields":{"f":"v"}},{"id":"2-0","fields":{"f":"w"}}],"length":2,"last_id":"2-0","groups":[` +
+		`{"name":"g1","last_id":"1-0","pending":0,"lag":1,"consumers":[]},` +
+		`{"name":"g2","last_id":"1-0","pending":0,"lag":null,"consumers":[]}]}}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("got %s, want %s", buf.String(), want)
+	}
+}
+
+func TestJSONLExporterFilter(t *testing.T) {
+	re, err := GlobToRegexp("h*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	e := NewJSONLExporter(&buf)
+	e.Filter = &Filter{Key: re, Types: ParseTypes("hash")}
+	e.Base64 = true
+	exportDump(t, "dump.rdb", e)
+
+	want := `{"db":0,"key":"aHNldF9rZXk=","type":"hash","encoding":"ziplist","expiry":0,"value":{"ZmllbGQ=":"dmFsdWU="}}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("got %s, want %s", buf.String(), want)
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	cases := []struct {
+		glob  string
+		key   string
+		match bool
+	}{
+		{"user:*", "user:1001", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hallo", true},
+		{"h[ae]llo", "hello", true},
+		{"h[^e]llo", "hello", false},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{"a.b", "axb", false},
+	}
+	for _, c := range cases {
+		re, err := GlobToRegexp(c.glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if re.MatchString(c.key) != c.match {
+			t.Fatalf("%s match %s: expected %v", c.glob, c.key, c.match)
+		}
+	}
+}
+
+func TestEscapeBinary(t *testing.T) {
+	if got := escapeBinary([]byte("a\xffb\\中")); got != `a\xffb\\中` {
+		t.Fatalf("unexpected escape %q", got)
+	}
+}
+
+func TestRESPExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exportDump(t, "dump.rdb", NewRESPExporter(&buf))
+
+	want := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n" +
+		"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$3\r\n123\r\n" +
+		"*3\r\n$9\r\nPEXPIREAT\r\n$3\r\nkey\r\n$13\r\n1574093693054\r\n" +
+		"*3\r\n$3\r\nSET\r\n$5\r\nhello\r\n$5\r\nworld\r\n" +
+		"*2\r\n$3\r\nDEL\r\n$8\r\nhset_key\r\n" +
+		"*4\r\n$4\r\nHSET\r\n$8\r\nhset_key\r\n$5\r\nfield\r\n$5\r\nvalue\r\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
+
+func TestRESPExporterBatch(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewRESPExporter(&buf)
+	e.BatchSize = 2
+	info := &rdb.KeyInfo{DB: 1, Type: "zset"}
+	e.StartZSet([]byte("z"), 3, info)
+	e.Zadd([]byte("z"), 1.5, []byte("a"))
+	e.Zadd([]byte("z"), 2, []byte("b"))
+	e.Zadd([]byte("z"), math.Inf(-1), []byte("c"))
+	e.EndZSet([]byte("z"), info)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "*2\r\n$6\r\nSELECT\r\n$1\r\n1\r\n" +
+		"*2\r\n$3\r\nDEL\r\n$1\r\nz\r\n" +
+		"*6\r\n$4\r\nZADD\r\n$1\r\nz\r\n$3\r\n1.5\r\n$1\r\na\r\n$1\r\n2\r\n$1\r\nb\r\n" +
+		"*4\r\n$4\r\nZADD\r\n$1\r\nz\r\n$4\r\n-inf\r\n$1\r\nc\r\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
+
+func TestRESPExporterNoDel(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewRESPExporter(&buf)
+	e.Del = false
+	info := &rdb.KeyInfo{DB: 0, Type: "set"}
+	e.StartSet([]byte("s"), 1, info)
+	e.Sadd([]byte("s"), []byte("a"))
+	e.EndSet([]byte("s"), info)
+	e.Module([]byte("m1"), "ReJSON-RL", nil, &rdb.KeyInfo{Type: "module"})
+	e.Module([]byte("m2"), "ReJSON-RL", nil, &rdb.KeyInfo{Type: "module"})
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n" +
+		"*3\r\n$4\r\nSADD\r\n$1\r\ns\r\n$1\r\na\r\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+	if skipped := e.SkippedModules(); len(skipped) != 1 || skipped["ReJSON-RL"] != 2 {
+		t.Fatalf("unexpected skipped modules %v", skipped)
+	}
+}
+
+func TestJSONLExporterPipeline(t *testing.T) {
+	var want bytes.Buffer
+	exportDump(t, "dump.rdb", NewJSONLExporter(&want))
+
+	f, err := os.Open(path.Join("..", "rdb", "dumps", "dump.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got bytes.Buffer
+	e := NewJSONLExporter(&got)
+	if err = e.Pipeline(2).Parse(bufio.NewReader(f)); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Fatalf("got %s, want %s", got.String(), want.String())
+	}
+}
+
+func TestJSONLExporterModule(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewJSONLExporter(&buf)
+	info := &rdb.KeyInfo{Type: "module", Encoding: rdb.ReJSONModule, Idle: -1, Freq: -1}
+	e.Module([]byte("doc"), rdb.ReJSONModule, json.RawMessage(`{"a":1}`), info)
+	info = &rdb.KeyInfo{Type: "module", Encoding: "MBbloom--", Idle: -1, Freq: -1}
+	e.Module([]byte("bf"), "MBbloom--", &rdb.ModuleValue{Module: "MBbloom--", EncVer: 4, Fields: []interface{}{uint64(1), int64(-2), 0.5, []byte("\xff")}}, info)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"db":0,"key":"doc","type":"module","encoding":"ReJSON-RL","expiry":0,"value":{"a":1}}` + "\n" +
+		`{"db":0,"key":"bf","type":"module","encoding":"MBbloom--","expiry":0,"value":{"encver":4,"fields":[1,-2,0.5,"\\xff"]}}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("got %s, want %s", buf.String(), want)
+	}
+}
+
+// streamDump 构造一个只有 stream s 的 RDB：节点 1000-0 中有 1000-0 f=v 和 1000-1 f=w 两条消息，
+// 消费组 g 读到 1000-0，有一个没有待确认消息的消费者 c。version 为 10 时使用 RDB_TYPE_STREAM_LISTPACKS_2
+func streamDump(version int) []byte {
+	// listpack 中只有 0~127 的整数和短字符串，每个 entry 的 backlen 只占一个字节
+	var lp []byte
+	for _, item := range []string{"2", "0", "1", "f", "0", "2", "0", "0", "v", "3", "2", "0", "1", "w", "3"} {
+		if n, err := strconv.Atoi(item); err == nil {
+			lp = append(lp, byte(n), 1)
+		} else {
+			lp = append(lp, 0x80|byte(len(item)))
+			lp = append(lp, item...)
+			lp = append(lp, byte(len(item)+1))
+		}
+	}
+	lp = append(lp, 0xff)
+	lp = append([]byte{byte(len(lp) + 6), 0, 0, 0, 6, 0}, lp...)
+
+	dtype := byte(15)
+	if version >= 10 {
+		dtype = 19
+	}
+	buf := []byte(fmt.Sprintf("REDIS%04d", version))
+	buf = append(buf, 0xfe, 0x00, dtype, 0x01, 's', 0x01)
+	buf = append(buf, 0x10, 0, 0, 0, 0, 0, 0, 0x03, 0xe8, 0, 0, 0, 0, 0, 0, 0, 0) // 节点的 master id 1000-0
+	buf = append(buf, byte(len(lp)))
+	buf = append(buf, lp...)
+	buf = append(buf, 0x02, 0x43, 0xe8, 0x01) // length 2, last id 1000-1
+	if version >= 10 {
+		buf = append(buf, 0x43, 0xe8, 0x00) // first id 1000-0
+		buf = append(buf, 0x05, 0x00)       // max deleted id 5-0
+		buf = append(buf, 0x03)             // entries added 3
+	}
+	buf = append(buf, 0x01, 0x01, 'g', 0x43, 0xe8, 0x00) // 消费组 g，last id 1000-0
+	if version >= 10 {
+		buf = append(buf, 0x01) // entries read 1
+	}
+	buf = append(buf, 0x00)                                          // 消费组的 PEL 为空
+	buf = append(buf, 0x01, 0x01, 'c', 0, 0, 0, 0, 0, 0, 0, 0, 0x00) // 消费者 c
+	return append(buf, 0xff, 0, 0, 0, 0, 0, 0, 0, 0)
+}
+
+// streamCapture 记录解析出来的 stream 消息和元数据
+type streamCapture struct {
+	rdb.NopHandler
+	entries []string
+	meta    *rdb.StreamMeta
+}
+
+func (c *streamCapture) StreamEntry(key []byte, entry *rdb.StreamEntry) {
+	c.entries = append(c.entries, fmt.Sprintf("%v%s", entry.ID, entry.Fields))
+}
+func (c *streamCapture) EndStream(key []byte, meta *rdb.StreamMeta, info *rdb.KeyInfo) { c.meta = meta }
+
+func TestRESPExporterStream(t *testing.T) {
+	for _, version := range []int{9, 10} {
+		data := streamDump(version)
+		parsed := &streamCapture{}
+		var buf bytes.Buffer
+		e := NewRESPExporter(&buf)
+		if err := rdb.NewRDB(bufio.NewReader(bytes.NewReader(data)), rdb.MultiHandler(parsed, e)).Parse(); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		// 按 Redis 执行这些命令的方式重建 stream，与 RDB 中的内容比较
+		replayed := &streamCapture{meta: &rdb.StreamMeta{}}
+		rd := resp.NewReader(bufio.NewReader(&buf))
+		for {
+			reply, err := rd.ReadReply()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var args []string
+			for _, arg := range reply.([]interface{}) {
+				args = append(args, string(arg.([]byte)))
+			}
+			option := func(name string) string {
+				for i := 0; i+1 < len(args); i++ {
+					if args[i] == name {
+						return args[i+1]
+					}
+				}
+				return ""
+			}
+			switch args[0] {
+			case "XADD":
+				replayed.entries = append(replayed.entries, fmt.Sprintf("%s%v", args[2], args[3:]))
+			case "XSETID":
+				replayed.meta.LastID = parseStreamID(t, args[2])
+				if v := option("ENTRIESADDED"); v != "" {
+					replayed.meta.EntriesAdded, _ = strconv.ParseUint(v, 10, 64)
+					replayed.meta.MaxDeletedID = parseStreamID(t, option("MAXDELETEDID"))
+				}
+			case "XGROUP":
+				g := rdb.StreamGroup{Name: []byte(args[3]), LastID: parseStreamID(t, args[4]), EntriesRead: -1}
+				if v := option("ENTRIESREAD"); v != "" {
+					g.EntriesRead, _ = strconv.ParseInt(v, 10, 64)
+				}
+				replayed.meta.Groups = append(replayed.meta.Groups, g)
+			}
+		}
+
+		if fmt.Sprint(replayed.entries) != fmt.Sprint(parsed.entries) || len(parsed.entries) != 2 {
+			t.Fatalf("version %d: replayed entries %v, want %v", version, replayed.entries, parsed.entries)
+		}
+		p, r := parsed.meta, replayed.meta
+		if r.LastID != p.LastID || r.EntriesAdded != p.EntriesAdded || r.MaxDeletedID != p.MaxDeletedID {
+			t.Fatalf("version %d: replayed meta %+v, want %+v", version, r, p)
+		}
+		if len(r.Groups) != 1 || string(r.Groups[0].Name) != "g" || r.Groups[0].LastID != p.Groups[0].LastID ||
+			r.Groups[0].EntriesRead != p.Groups[0].EntriesRead {
+			t.Fatalf("version %d: replayed groups %+v, want %+v", version, r.Groups, p.Groups)
+		}
+		if version >= 10 && (p.EntriesAdded != 3 || p.MaxDeletedID.Ms != 5 || p.Groups[0].EntriesRead != 1) {
+			t.Fatalf("unexpected parsed meta %+v", p)
+		}
+	}
+}
+
+func parseStreamID(t *testing.T, s string) rdb.StreamID {
+	var id rdb.StreamID
+	if _, err := fmt.Sscanf(s, "%d-%d", &id.Ms, &id.Seq); err != nil {
+		t.Fatalf("invalid stream id %q", s)
+	}
+	return id
+}
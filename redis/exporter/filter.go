@@ -0,0 +1,95 @@
+package exporter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ssp4599815/monitors/redis/rdb"
+)
+
+// Filter 按数据库、key 和类型过滤要导出的 key，未设置的条件不做过滤
+type Filter struct {
+	DBs   map[int]bool
+	Key   *regexp.Regexp
+	Types map[string]bool
+}
+
+// Match 判断一个 key 是否需要导出
+func (f *Filter) Match(key []byte, info *rdb.KeyInfo) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.DBs) > 0 && !f.DBs[info.DB] {
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[info.Type] {
+		return false
+	}
+	if f.Key != nil && !f.Key.Match(key) {
+		return false
+	}
+	return true
+}
+
+// ParseDBs 解析以逗号分隔的数据库编号，例如 "0,2"
+func ParseDBs(s string) (map[int]bool, error) {
+	dbs := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		db, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		dbs[db] = true
+	}
+	return dbs, nil
+}
+
+// ParseTypes 解析以逗号分隔的类型，例如 "hash,zset"
+func ParseTypes(s string) map[string]bool {
+	types := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			types[part] = true
+		}
+	}
+	return types
+}
+
+// GlobToRegexp 把 Redis KEYS 命令风格的 glob（* ? [abc] 以及 \ 转义）转换成正则
+func GlobToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString(`^`)
+	inClass := false
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+			b.WriteByte(c)
+		case c == '*':
+			b.WriteString(`(?s:.*)`)
+		case c == '?':
+			b.WriteString(`(?s:.)`)
+		case c == '[':
+			inClass = true
+			b.WriteByte(c)
+			if i+1 < len(glob) && glob[i+1] == '^' {
+				i++
+				b.WriteByte('^')
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(`$`)
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,349 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/ssp4599815/monitors/redis/rdb"
+)
+
+// JSONLExporter 是一个 rdb.Handler，把每个 key 导出为一行 JSON：
+//
+//	{"db":0,"key":"k","type":"hash","encoding":"ziplist","expiry":0,"value":{"f":"v"}}
+//
+// 只缓存当前正在解析的 key，因此可以流式地导出任意大小的 RDB。
+type JSONLExporter struct {
+	rdb.NopHandler
+
+	// Filter 不为 nil 时只导出匹配的 key
+	Filter *Filter
+	// Base64 为 true 时所有字符串都以 base64 编码，否则只把非 UTF-8 的字节转义为 \xNN
+	Base64 bool
+
+	w   *bufio.Writer
+	err error
+
+	cur    *rdb.KeyInfo
+	key    []byte
+	values [][]byte  // list/set 的元素，hash 的 field/value 交替存放，zset 的 member
+	scores []float64 // zset 的 score
+	stream []*rdb.StreamEntry
+}
+
+func NewJSONLExporter(w io.Writer) *JSONLExporter {
+	return &JSONLExporter{w: bufio.NewWriter(w)}
+}
+
+// Flush 把缓冲区中的数据写出，返回导出过程中遇到的第一个错误
+func (e *JSONLExporter) Flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+func (e *JSONLExporter) Set(key, value []byte, info *rdb.KeyInfo) {
+	if e.start(key, info) {
+		e.values = append(e.values, value)
+		e.end()
+	}
+}
+
+func (e *JSONLExporter) StartHash(key []byte, length int64, info *rdb.KeyInfo) { e.start(key, info) }
+func (e *JSONLExporter) Hset(key, field, value []byte) {
+	if e.cur != nil {
+		e.values = append(e.values, field, value)
+	}
+}
+func (e *JSONLExporter) EndHash(key []byte, info *rdb.KeyInfo) { e.end() }
+
+func (e *JSONLExporter) StartSet(key []byte, cardinality int64, info *rdb.KeyInfo) {
+	e.start(key, info)
+}
+func (e *JSONLExporter) Sadd(key, member []byte)              { e.add(member) }
+func (e *JSONLExporter) EndSet(key []byte, info *rdb.KeyInfo) { e.end() }
+
+func (e *JSONLExporter) StartList(key []byte, length int64, info *rdb.KeyInfo) { e.start(key, info) }
+func (e *JSONLExporter) Rpush(key, value []byte)                               { e.add(value) }
+func (e *JSONLExporter) EndList(key []byte, info *rdb.KeyInfo)                 { e.end() }
+
+func (e *JSONLExporter) StartZSet(key []byte, cardinality int64, info *rdb.KeyInfo) {
+	e.start(key, info)
+}
+func (e *JSONLExporter) Zadd(key []byte, score float64, member []byte) {
+	if e.cur != nil {
+		e.values = append(e.values, member)
+		e.scores = append(e.scores, score)
+	}
+}
+func (e *JSONLExporter) EndZSet(key []byte, info *rdb.KeyInfo) { e.end() }
+
+func (e *JSONLExporter) StartStream(key []byte, info *rdb.KeyInfo) { e.start(key, info) }
+func (e *JSONLExporter) StreamEntry(key []byte, entry *rdb.StreamEntry) {
+	if e.cur != nil {
+		e.stream = append(e.stream, entry)
+	}
+}
+func (e *JSONLExporter) EndStream(key []byte, meta *rdb.StreamMeta, info *rdb.KeyInfo) {
+	if e.cur == nil {
+		return
+	}
+	buf := e.header()
+	buf.WriteString(`,"value":{"entries":[`)
+	for i, entry := range e.stream {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`{"id":"` + entry.ID.String() + `","fields":`)
+		e.writeObject(buf, entry.Fields)
+		buf.WriteByte('}')
+	}
+	buf.WriteString(`],"length":` + strconv.FormatUint(meta.Length, 10))
+	buf.WriteString(`,"last_id":"` + meta.LastID.String() + `","groups":[`)
+	for i := range meta.Groups {
+		g := &meta.Groups[i]
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`{"name":`)
+		e.writeString(buf, g.Name)
+		buf.WriteString(`,"last_id":"` + g.LastID.String() + `","pending":` + strconv.Itoa(len(g.Pending)))
+		// 无法计算 lag 时（例如 RDB 9）输出 null
+		if lag, ok := meta.Lag(g); ok {
+			buf.WriteString(`,"lag":` + strconv.FormatUint(lag, 10))
+		} else {
+			buf.WriteString(`,"lag":null`)
+		}
+		buf.WriteString(`,"consumers":[`)
+		for j, c := range g.Consumers {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"name":`)
+			e.writeString(buf, c.Name)
+			buf.WriteString(`,"pending":` + strconv.Itoa(len(c.Pending)) + `}`)
+		}
+		buf.WriteString(`]}`)
+	}
+	buf.WriteString(`]}`)
+	e.finish(buf)
+}
+
+// Module 导出 module 类型的 key，RedisJSON 的文档直接作为 value，
+// 没有解码器的 module 输出 encver 和按顺序保存的字段
+func (e *JSONLExporter) Module(key []byte, module string, value interface{}, info *rdb.KeyInfo) {
+	if !e.start(key, info) {
+		return
+	}
+	buf := e.header()
+	buf.WriteString(`,"value":`)
+	switch v := value.(type) {
+	case json.RawMessage:
+		buf.Write(v)
+	case *rdb.ModuleValue:
+		buf.WriteString(`{"encver":` + strconv.Itoa(v.EncVer) + `,"fields":[`)
+		for i, field := range v.Fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			switch f := field.(type) {
+			case []byte:
+				e.writeString(buf, f)
+			case int64:
+				buf.WriteString(strconv.FormatInt(f, 10))
+			case uint64:
+				buf.WriteString(strconv.FormatUint(f, 10))
+			case float32:
+				writeScore(buf, float64(f))
+			case float64:
+				writeScore(buf, f)
+			default:
+				buf.WriteString("null")
+			}
+		}
+		buf.WriteString(`]}`)
+	default:
+		out, err := json.Marshal(v)
+		if err != nil {
+			out = []byte("null")
+		}
+		buf.Write(out)
+	}
+	e.finish(buf)
+}
+
+// start 开始一个新 key，key 不需要导出时返回 false
+func (e *JSONLExporter) start(key []byte, info *rdb.KeyInfo) bool {
+	e.cur, e.key = nil, nil
+	e.values, e.scores, e.stream = e.values[:0], e.scores[:0], e.stream[:0]
+	if !e.Filter.Match(key, info) {
+		return false
+	}
+	e.cur, e.key = info, key
+	return true
+}
+
+func (e *JSONLExporter) add(value []byte) {
+	if e.cur != nil {
+		e.values = append(e.values, value)
+	}
+}
+
+func (e *JSONLExporter) end() {
+	if e.cur == nil {
+		return
+	}
+	buf := e.header()
+	buf.WriteString(`,"value":`)
+	switch e.cur.Type {
+	case "string":
+		e.writeString(buf, e.values[0])
+	case "hash":
+		e.writeObject(buf, e.values)
+	case "zset":
+		buf.WriteByte('[')
+		for i, member := range e.values {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"member":`)
+			e.writeString(buf, member)
+			buf.WriteString(`,"score":`)
+			writeScore(buf, e.scores[i])
+			buf.WriteByte('}')
+		}
+		buf.WriteByte(']')
+	default:
+		buf.WriteByte('[')
+		for i, v := range e.values {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			e.writeString(buf, v)
+		}
+		buf.WriteByte(']')
+	}
+	e.finish(buf)
+}
+
+// header 输出 key 的元信息，不包含结尾的 }
+func (e *JSONLExporter) header() *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"db":` + strconv.Itoa(e.cur.DB) + `,"key":`)
+	e.writeString(buf, e.key)
+	buf.WriteString(`,"type":"` + e.cur.Type + `","encoding":"` + e.cur.Encoding + `"`)
+	buf.WriteString(`,"expiry":` + strconv.FormatInt(e.cur.Expiry, 10))
+	if e.cur.Idle >= 0 {
+		buf.WriteString(`,"idle":` + strconv.FormatInt(e.cur.Idle, 10))
+	}
+	if e.cur.Freq >= 0 {
+		buf.WriteString(`,"freq":` + strconv.Itoa(e.cur.Freq))
+	}
+	return buf
+}
+
+func (e *JSONLExporter) finish(buf *bytes.Buffer) {
+	buf.WriteString("}\n")
+	if e.err == nil {
+		_, e.err = e.w.Write(buf.Bytes())
+	}
+	e.cur, e.key = nil, nil
+}
+
+// writeObject 把 field/value 交替存放的列表输出为 JSON 对象
+func (e *JSONLExporter) writeObject(buf *bytes.Buffer, pairs [][]byte) {
+	buf.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		e.writeString(buf, pairs[i])
+		buf.WriteByte(':')
+		e.writeString(buf, pairs[i+1])
+	}
+	buf.WriteByte('}')
+}
+
+func (e *JSONLExporter) writeString(buf *bytes.Buffer, b []byte) {
+	var s string
+	if e.Base64 {
+		s = base64.StdEncoding.EncodeToString(b)
+	} else {
+		s = escapeBinary(b)
+	}
+	out, _ := json.Marshal(s)
+	buf.Write(out)
+}
+
+// escapeBinary 保留合法的 UTF-8 字符，把其余字节转义为 \xNN，反斜杠转义为 \\
+func escapeBinary(b []byte) string {
+	if utf8.Valid(b) && bytes.IndexByte(b, '\\') < 0 {
+		return string(b)
+	}
+	var out bytes.Buffer
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			out.WriteString(`\x`)
+			out.WriteString(strconv.FormatUint(uint64(b[0])|0x100, 16)[1:])
+		case r == '\\':
+			out.WriteString(`\\`)
+		default:
+			out.Write(b[:size])
+		}
+		b = b[size:]
+	}
+	return out.String()
+}
+
+// writeScore 输出 zset 的 score，JSON 不支持的 inf/nan 以字符串输出
+func writeScore(buf *bytes.Buffer, score float64) {
+	switch {
+	case math.IsInf(score, 1):
+		buf.WriteString(`"inf"`)
+	case math.IsInf(score, -1):
+		buf.WriteString(`"-inf"`)
+	case math.IsNaN(score):
+		buf.WriteString(`"nan"`)
+	default:
+		buf.WriteString(strconv.FormatFloat(score, 'g', -1, 64))
+	}
+}
+
+// Pipeline 返回并行导出用的 rdb.Pipeline，每个 worker 使用和 e 相同配置的导出器把 key
+// 编码为 JSON，再按 key 在文件中的顺序写到 e 的输出中，解析完成后仍需调用 e.Flush
+func (e *JSONLExporter) Pipeline(workers int) *rdb.Pipeline {
+	return &rdb.Pipeline{
+		Workers: workers,
+		NewDecoder: func() func(v *rdb.RawValue) (interface{}, error) {
+			var buf bytes.Buffer
+			worker := NewJSONLExporter(&buf)
+			worker.Filter, worker.Base64 = e.Filter, e.Base64
+			return func(v *rdb.RawValue) (interface{}, error) {
+				if !e.Filter.Match(v.Key, &v.Info) {
+					return nil, nil
+				}
+				buf.Reset()
+				if err := v.Decode(worker); err != nil {
+					return nil, err
+				}
+				if err := worker.Flush(); err != nil {
+					return nil, err
+				}
+				return append([]byte(nil), buf.Bytes()...), nil
+			}
+		},
+		Merge: func(v *rdb.RawValue, result interface{}) {
+			if line, ok := result.([]byte); ok && e.err == nil {
+				_, e.err = e.w.Write(line)
+			}
+		},
+	}
+}
@@ -0,0 +1,218 @@
+package exporter
+
+import (
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/ssp4599815/monitors/redis/rdb"
+	"github.com/ssp4599815/monitors/redis/resp"
+)
+
+// DefaultBatchSize 是集合类型的 key 每条命令最多携带的元素个数
+const DefaultBatchSize = 128
+
+// RESPExporter 是一个 rdb.Handler，把 RDB 转换为可以用 redis-cli --pipe 回放的 RESP 命令流。
+//
+// 每个 key 转换为 SET/RPUSH/SADD/ZADD/HSET/XADD 命令，有过期时间时追加 PEXPIREAT，
+// 数据库切换时输出 SELECT。大的集合按 BatchSize 拆成多条命令，元素不会在内存中堆积。
+// Del 为 true 时在集合类型的 key 之前输出 DEL，回放到已有这个 key 的 Redis 时替换而不是追加。
+// stream 的 last id 和消费组用 XSETID 和 XGROUP CREATE 恢复，RDB 10 起同时恢复 entries_added、
+// max_deleted_id 和消费组的 entries_read（需要回放到 Redis 7.0 及以上）；消费组的 PEL 和消费者无法用命令重建，不导出。
+// module 类型的 key 无法转换为命令，只统计个数，见 SkippedModules。
+type RESPExporter struct {
+	rdb.NopHandler
+
+	// Filter 不为 nil 时只导出匹配的 key
+	Filter *Filter
+	// BatchSize 为每条命令最多携带的元素个数
+	BatchSize int
+	// Del 为 true 时在 RPUSH/SADD/ZADD/HSET/XADD 之前先 DEL 这个 key，默认为 true
+	Del bool
+
+	w       *resp.Writer
+	err     error
+	db      int
+	version int // RDB 的版本
+
+	cur  *rdb.KeyInfo
+	key  []byte
+	cmd  string
+	args [][]byte // 当前命令中尚未写出的元素
+	n    int      // args 中的元素个数，hash 和 zset 每个元素占两个参数
+
+	skipped map[string]uint64 // module 名字 -> 没有导出的 key 个数
+}
+
+func NewRESPExporter(w io.Writer) *RESPExporter {
+	return &RESPExporter{w: resp.NewWriter(w), BatchSize: DefaultBatchSize, Del: true, db: -1}
+}
+
+func (e *RESPExporter) StartRDB(version int) {
+	e.version = version
+}
+
+// Flush 把缓冲区中的数据写出，返回导出过程中遇到的第一个错误
+func (e *RESPExporter) Flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+func (e *RESPExporter) Set(key, value []byte, info *rdb.KeyInfo) {
+	if e.start(key, info, "SET") {
+		e.add(value)
+		e.end()
+	}
+}
+
+func (e *RESPExporter) StartHash(key []byte, length int64, info *rdb.KeyInfo) {
+	e.start(key, info, "HSET")
+}
+func (e *RESPExporter) Hset(key, field, value []byte)         { e.add(field, value) }
+func (e *RESPExporter) EndHash(key []byte, info *rdb.KeyInfo) { e.end() }
+
+func (e *RESPExporter) StartSet(key []byte, cardinality int64, info *rdb.KeyInfo) {
+	e.start(key, info, "SADD")
+}
+func (e *RESPExporter) Sadd(key, member []byte)              { e.add(member) }
+func (e *RESPExporter) EndSet(key []byte, info *rdb.KeyInfo) { e.end() }
+
+func (e *RESPExporter) StartList(key []byte, length int64, info *rdb.KeyInfo) {
+	e.start(key, info, "RPUSH")
+}
+func (e *RESPExporter) Rpush(key, value []byte)               { e.add(value) }
+func (e *RESPExporter) EndList(key []byte, info *rdb.KeyInfo) { e.end() }
+
+func (e *RESPExporter) StartZSet(key []byte, cardinality int64, info *rdb.KeyInfo) {
+	e.start(key, info, "ZADD")
+}
+func (e *RESPExporter) Zadd(key []byte, score float64, member []byte) {
+	e.add([]byte(formatScore(score)), member)
+}
+func (e *RESPExporter) EndZSet(key []byte, info *rdb.KeyInfo) { e.end() }
+
+func (e *RESPExporter) StartStream(key []byte, info *rdb.KeyInfo) {
+	e.start(key, info, "XADD")
+}
+func (e *RESPExporter) StreamEntry(key []byte, entry *rdb.StreamEntry) {
+	if e.cur == nil {
+		return
+	}
+	args := append([][]byte{[]byte("XADD"), e.key, []byte(entry.ID.String())}, entry.Fields...)
+	e.write(args...)
+}
+func (e *RESPExporter) EndStream(key []byte, meta *rdb.StreamMeta, info *rdb.KeyInfo) {
+	if e.cur == nil {
+		return
+	}
+	// 空的 stream 没有 XADD，需要 MKSTREAM 才能设置 last id 和消费组
+	if meta.Length == 0 {
+		e.write([]byte("XGROUP"), []byte("CREATE"), e.key, []byte("__tmp__"), []byte("$"), []byte("MKSTREAM"))
+		e.write([]byte("XGROUP"), []byte("DESTROY"), e.key, []byte("__tmp__"))
+	}
+	setID := [][]byte{[]byte("XSETID"), e.key, []byte(meta.LastID.String())}
+	if e.version >= 10 {
+		setID = append(setID, []byte("ENTRIESADDED"), []byte(strconv.FormatUint(meta.EntriesAdded, 10)),
+			[]byte("MAXDELETEDID"), []byte(meta.MaxDeletedID.String()))
+	}
+	e.write(setID...)
+	for _, g := range meta.Groups {
+		create := [][]byte{[]byte("XGROUP"), []byte("CREATE"), e.key, g.Name, []byte(g.LastID.String())}
+		if g.EntriesRead >= 0 {
+			create = append(create, []byte("ENTRIESREAD"), []byte(strconv.FormatInt(g.EntriesRead, 10)))
+		}
+		e.write(create...)
+	}
+	e.expire()
+	e.cur, e.key = nil, nil
+}
+
+// Module 统计无法导出的 module 类型的 key
+func (e *RESPExporter) Module(key []byte, module string, value interface{}, info *rdb.KeyInfo) {
+	e.cur, e.key = nil, nil
+	if !e.Filter.Match(key, info) {
+		return
+	}
+	if e.skipped == nil {
+		e.skipped = make(map[string]uint64)
+	}
+	e.skipped[module]++
+}
+
+// SkippedModules 返回每个 module 没有导出的 key 的个数
+func (e *RESPExporter) SkippedModules() map[string]uint64 {
+	return e.skipped
+}
+
+// start 开始一个新 key，key 不需要导出时返回 false
+func (e *RESPExporter) start(key []byte, info *rdb.KeyInfo, cmd string) bool {
+	e.cur, e.key = nil, nil
+	e.args, e.n = e.args[:0], 0
+	if !e.Filter.Match(key, info) {
+		return false
+	}
+	if info.DB != e.db {
+		e.db = info.DB
+		e.write([]byte("SELECT"), []byte(strconv.Itoa(info.DB)))
+	}
+	e.cur, e.key, e.cmd = info, key, cmd
+	if e.Del && cmd != "SET" {
+		// SET 本身会覆盖已有的 key
+		e.write([]byte("DEL"), key)
+	}
+	return true
+}
+
+// add 添加一个元素，攒够 BatchSize 个元素时写出一条命令
+func (e *RESPExporter) add(parts ...[]byte) {
+	if e.cur == nil {
+		return
+	}
+	e.args = append(e.args, parts...)
+	e.n++
+	if e.BatchSize > 0 && e.n >= e.BatchSize {
+		e.flushArgs()
+	}
+}
+
+func (e *RESPExporter) flushArgs() {
+	if e.n == 0 {
+		return
+	}
+	e.write(append([][]byte{[]byte(e.cmd), e.key}, e.args...)...)
+	e.args, e.n = e.args[:0], 0
+}
+
+func (e *RESPExporter) end() {
+	if e.cur == nil {
+		return
+	}
+	e.flushArgs()
+	e.expire()
+	e.cur, e.key = nil, nil
+}
+
+func (e *RESPExporter) expire() {
+	if e.cur.Expiry != 0 {
+		e.write([]byte("PEXPIREAT"), e.key, []byte(strconv.FormatInt(e.cur.Expiry, 10)))
+	}
+}
+
+func (e *RESPExporter) write(args ...[]byte) {
+	if e.err == nil {
+		e.err = e.w.WriteCommand(args...)
+	}
+}
+
+// formatScore 按 Redis 接受的格式输出 zset 的 score
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "+inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
@@ -3,15 +3,23 @@ package hunter
 import (
 	"fmt"
 	"github.com/Shopify/sarama"
+	"github.com/ssp4599815/monitors/redis/slowlog"
+	"log"
+	"sync/atomic"
+	"time"
 )
 
+// ackTimeout 是 session 结束时等待已经发出的慢日志被确认的最长时间
+const ackTimeout = 30 * time.Second
+
 type Counsumer struct {
-	messageChan chan *sarama.ConsumerMessage
+	events  chan<- *slowlog.Event
+	pending int64 // 已经发出但还没有确认的慢日志条数
 }
 
-func NewCounsumer(msgChan chan *sarama.ConsumerMessage) *Counsumer {
+func NewCounsumer(events chan<- *slowlog.Event) *Counsumer {
 	c := &Counsumer{
-		messageChan: msgChan, // 上面在创建consumer的时候传进来的一个通道，用于将接收到的信息传入到这个通道中
+		events: events, // 上面在创建consumer的时候传进来的一个通道，用于将解析后的慢日志传入到这个通道中
 	}
 	return c
 }
@@ -23,8 +31,17 @@ func (c *Counsumer) Setup(sarama.ConsumerGroupSession) error {
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
+// session 在 Cleanup 之后提交最后一次 offset 并关闭，这里等待已经发出的慢日志被确认，否则它们的 offset 不会被提交
 func (c *Counsumer) Cleanup(sarama.ConsumerGroupSession) error {
 	fmt.Println("开始清理 consumetr 客户端")
+	deadline := time.Now().Add(ackTimeout)
+	for atomic.LoadInt64(&c.pending) > 0 {
+		if time.Now().After(deadline) {
+			log.Printf("%d slowlog messages were not acked in %s, they will be consumed again", atomic.LoadInt64(&c.pending), ackTimeout)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
 	return nil
 }
 
@@ -32,9 +49,24 @@ func (c *Counsumer) Cleanup(sarama.ConsumerGroupSession) error {
 func (c *Counsumer) ConsumeClaim(session sarama.ConsumerGroupSession, cliaim sarama.ConsumerGroupClaim) error {
 	fmt.Println("开始接受kafka 发来的信息。。。")
 	for message := range cliaim.Messages() {
-		c.messageChan <- message // 将消息放入到一个通道中
-		fmt.Println("当前 messageChan 队列的长度：", len(c.messageChan))
-		session.MarkMessage(message, "")
+		message := message
+		s, err := slowlog.ParseSlowlog(message.Value)
+		if err != nil {
+			// 无法解析的消息也按顺序确认，不阻塞后面的 offset
+			log.Printf("skip slowlog message at %s/%d offset %d: %v", message.Topic, message.Partition, message.Offset, err)
+		}
+		// 事件处理完之后才标记 offset，程序退出时没有处理完的消息会被重新消费
+		atomic.AddInt64(&c.pending, 1)
+		e := &slowlog.Event{Slowlog: s, Ack: func() {
+			session.MarkMessage(message, "")
+			atomic.AddInt64(&c.pending, -1)
+		}}
+		select {
+		case c.events <- e: // 将消息放入到一个通道中
+		case <-session.Context().Done():
+			atomic.AddInt64(&c.pending, -1)
+			return nil
+		}
 	}
 	return nil
 }
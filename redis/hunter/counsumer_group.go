@@ -5,27 +5,26 @@ import (
 	"fmt"
 	"github.com/Shopify/sarama"
 	cfg "github.com/ssp4599815/monitors/redis/config"
+	"github.com/ssp4599815/monitors/redis/slowlog"
 	"log"
-	"sync"
 	"time"
 )
 
 type ConsumerGroupHandler struct {
-	wg            sync.WaitGroup // 用于阻塞 consumer goroutiune
 	consumerGroup sarama.ConsumerGroup
 	saramaConfig  *sarama.Config
 	consumer      *Counsumer
 	kafkaConfig   cfg.KafkaConfig
-	MessageChan   chan *sarama.ConsumerMessage // 从 kafka 接受信息, 传给下层
+	events        chan<- *slowlog.Event // 从 kafka 接受信息, 传给下层
 }
 
 // 接受来自上层的 KafkaConfig 配置文件信息
-func NewConsumerGroupHandler(kafkaConfig cfg.KafkaConfig, MessageChan chan *sarama.ConsumerMessage) *ConsumerGroupHandler {
+func NewConsumerGroupHandler(kafkaConfig cfg.KafkaConfig, events chan<- *slowlog.Event) *ConsumerGroupHandler {
 
 	cgh := &ConsumerGroupHandler{
 		saramaConfig: sarama.NewConfig(),
 		kafkaConfig:  kafkaConfig,
-		MessageChan:  MessageChan,
+		events:       events,
 	}
 
 	fmt.Println("初始化 kafka consumer group 配置文件")
@@ -79,7 +78,7 @@ func (c *ConsumerGroupHandler) initConsumerGroup() {
 	var err error
 
 	// 创建一个新的 consumer 对象
-	c.consumer = NewCounsumer(c.MessageChan)
+	c.consumer = NewCounsumer(c.events)
 
 	// 创建一个 consumergroup 对象
 	fmt.Println("开始创建 ConsumerGroup 对象")
@@ -89,12 +88,15 @@ func (c *ConsumerGroupHandler) initConsumerGroup() {
 	}
 }
 
-func (c *ConsumerGroupHandler) Start() {
+// Start 消费 kafka 中的消息，直到 ctx 被取消，返回前关闭 consumer group
+func (c *ConsumerGroupHandler) Start(ctx context.Context) error {
 	fmt.Println("启动一个新的 Sarama consumer")
+	if c.consumerGroup == nil {
+		return fmt.Errorf("kafka consumer group of %v was not created", c.kafkaConfig.Brokers)
+	}
+	defer c.Stop()
 	go c.handlerError()
-	c.wg.Add(1)
-	go c.handlerMessage()
-	c.wg.Wait()
+	return c.handlerMessage(ctx)
 }
 
 // 开始处理错误
@@ -106,15 +108,16 @@ func (c *ConsumerGroupHandler) handlerError() {
 }
 
 // 开始处理监控到的数据
-func (c *ConsumerGroupHandler) handlerMessage() {
+func (c *ConsumerGroupHandler) handlerMessage(ctx context.Context) error {
 	fmt.Println("开始处理Sarama consumer Message")
-	defer c.wg.Done()
-	for {
-		err := c.consumerGroup.Consume(context.Background(), c.kafkaConfig.Topic, c.consumer)
+	// 每次 rebalance 之后 Consume 都会返回，需要重新加入消费组
+	for ctx.Err() == nil {
+		err := c.consumerGroup.Consume(ctx, c.kafkaConfig.Topic, c.consumer)
 		if err != nil {
-			log.Panicln("Error from consumer: ", err)
+			return fmt.Errorf("Error from consumer: %v", err)
 		}
 	}
+	return nil
 }
 
 func (c *ConsumerGroupHandler) Stop() {
@@ -0,0 +1,115 @@
+package hunter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/ssp4599815/monitors/redis/slowlog"
+)
+
+// fakeSession 记录被标记的 offset，released 之后的标记不会再被提交
+type fakeSession struct {
+	ctx context.Context
+
+	mu       sync.Mutex
+	marked   []int64
+	lost     []int64
+	released bool
+}
+
+func (s *fakeSession) Claims() map[string][]int32               { return nil }
+func (s *fakeSession) MemberID() string                         { return "test" }
+func (s *fakeSession) GenerationID() int32                      { return 1 }
+func (s *fakeSession) MarkOffset(string, int32, int64, string)  {}
+func (s *fakeSession) ResetOffset(string, int32, int64, string) {}
+func (s *fakeSession) Context() context.Context                 { return s.ctx }
+func (s *fakeSession) MarkMessage(m *sarama.ConsumerMessage, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		s.lost = append(s.lost, m.Offset)
+		return
+	}
+	s.marked = append(s.marked, m.Offset)
+}
+
+type fakeClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Topic() string                            { return "slowlog" }
+func (c *fakeClaim) Partition() int32                         { return 0 }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// sessionSource 按 sarama 的顺序运行一个 session：ctx 被取消后关闭消息通道，等待 ConsumeClaim 返回，
+// 调用 Cleanup，然后提交 offset 并释放 session
+type sessionSource struct {
+	session  *fakeSession
+	claim    *fakeClaim
+	consumer *Counsumer
+	started  chan struct{} // consumer 创建之后关闭
+}
+
+func (s *sessionSource) Run(ctx context.Context, events chan<- *slowlog.Event) error {
+	s.consumer = NewCounsumer(events)
+	s.session.ctx = ctx
+	close(s.started)
+	done := make(chan error, 1)
+	go func() { done <- s.consumer.ConsumeClaim(s.session, s.claim) }()
+	<-ctx.Done()
+	close(s.claim.messages) // session 结束时 sarama 关闭 claim 的消息通道
+	if err := <-done; err != nil {
+		return err
+	}
+	if err := s.consumer.Cleanup(s.session); err != nil {
+		return err
+	}
+	s.session.mu.Lock()
+	s.session.released = true
+	s.session.mu.Unlock()
+	return nil
+}
+
+func TestCounsumerShutdown(t *testing.T) {
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 10)}
+	for i := 0; i < 3; i++ {
+		value := fmt.Sprintf(`{"@timestamp":"2020-03-01T10:00:00Z","host":{"name":"redis-1"},`+
+			`"redis":{"slowlog":{"id":%d,"cmd":"GET","key":"k:%d","duration":{"us":100}}}}`, i, i)
+		claim.messages <- &sarama.ConsumerMessage{Topic: "slowlog", Offset: int64(i), Value: []byte(value)}
+	}
+	source := &sessionSource{session: &fakeSession{}, claim: claim, started: make(chan struct{})}
+
+	// 批次没有满，空闲时间也没有到，只能在退出时刷新
+	p := slowlog.NewProcesser(source)
+	p.IdleTimeoutDuration = time.Hour
+	flushed := 0
+	p.Flush = func(batch []*slowlog.Slowlog) { flushed += len(batch) }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- p.Run(ctx) }()
+
+	<-source.started
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&source.consumer.pending) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("messages were not consumed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	s := source.session
+	if flushed != 3 || fmt.Sprint(s.marked) != "[0 1 2]" || len(s.lost) != 0 {
+		t.Fatalf("%d flushed, marked %v before release, %v after release", flushed, s.marked, s.lost)
+	}
+}
@@ -1,26 +1,28 @@
 package hunter
 
 import (
-	"github.com/Shopify/sarama"
+	"context"
 	"github.com/ssp4599815/monitors/redis/config"
+	"github.com/ssp4599815/monitors/redis/slowlog"
 	"time"
 )
 
+// Hunter 是从 kafka 中消费 Filebeat 慢日志的 slowlog.Source
 type Hunter struct {
-	MessageChan   chan *sarama.ConsumerMessage // 从 kafka 接受信息, 传给下层
-	KafkaConfig   config.KafkaConfig          // 传给下层
-	nextFlushTime time.Time                    // 刷新缓冲区的间隔
+	KafkaConfig   config.KafkaConfig // 传给下层
+	nextFlushTime time.Time          // 刷新缓冲区的间隔
 }
 
-func NewHunter(kafkaConfig config.KafkaConfig, msgChan chan *sarama.ConsumerMessage) *Hunter {
+func NewHunter(kafkaConfig config.KafkaConfig) *Hunter {
 	h := &Hunter{
 		KafkaConfig: kafkaConfig,
-		MessageChan: msgChan, // 初始化一个 能接受1000条信息的通道
 	}
 	return h
 }
 
-func (h *Hunter) Run() {
-	handler := NewConsumerGroupHandler(h.KafkaConfig, h.MessageChan)
-	go handler.Start()  // 需要放到后台去运行
+// Run 消费 kafka 中的慢日志直到 ctx 被取消，事件被确认之后才标记对应消息的 offset。
+// ctx 被取消后先等待已经发出的事件被确认，再提交最后的 offset 并关闭消费组
+func (h *Hunter) Run(ctx context.Context, events chan<- *slowlog.Event) error {
+	handler := NewConsumerGroupHandler(h.KafkaConfig, events)
+	return handler.Start(ctx)
 }
@@ -3,7 +3,9 @@ package main
 import (
 	"github.com/ssp4599815/monitors/libmonitor/monitor"
 	. "github.com/ssp4599815/monitors/redis/monitor"
+	"github.com/ssp4599815/monitors/redis/rdbcmd"
 	"log"
+	"os"
 )
 
 var (
@@ -12,6 +14,13 @@ var (
 )
 
 func main() {
+	// 离线分析 RDB 文件：redis-monitor rdb <command> ...
+	if len(os.Args) > 1 && os.Args[1] == "rdb" {
+		if err := rdbcmd.Run(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	// 初始化 monitor 对象
 	rm := RedisMonitor{}
@@ -1,23 +1,24 @@
 package monitor
 
 import (
+	"context"
 	"fmt"
-	"github.com/Shopify/sarama"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/ssp4599815/monitors/libmonitor/alert"
 	"github.com/ssp4599815/monitors/libmonitor/cfgfile"
 	"github.com/ssp4599815/monitors/libmonitor/monitor"
 	cfg "github.com/ssp4599815/monitors/redis/config"
-	. "github.com/ssp4599815/monitors/redis/hunter"
 	. "github.com/ssp4599815/monitors/redis/slowlog"
+	"github.com/ssp4599815/monitors/redis/source"
 )
 
 // Monitor object. Contains all objects needed to run the monitor.
 type RedisMonitor struct {
-	RDSConfig    *cfg.Config
-	Hunter       *Hunter
-	Processer    *Processer
-	messagesChan chan *sarama.ConsumerMessage
-	alertChan    chan *alert.AlertEvent
+	RDSConfig *cfg.Config
+	Processer *Processer
+	alertChan chan *alert.AlertEvent
+	cancel    context.CancelFunc
 }
 
 func (rm *RedisMonitor) Config(m *monitor.Monitor) error {
@@ -43,18 +44,30 @@ func (rm *RedisMonitor) Run(m *monitor.Monitor) error {
 	//	log.Fatalf("recovered panic: %v", p)
 	//}()
 
-	msgChan := make(chan *sarama.ConsumerMessage, 1000) // 用来接收来自kafka的信息
-
-	// 从 kafka 中消费数据
-	fmt.Println("开始从 kafka 中消费数据")
-	rm.Hunter = NewHunter(rm.RDSConfig.Kafka, msgChan)
-	rm.Hunter.Run()
+	// 慢日志的来源：kafka、直接轮询 redis、文件或者标准输入
+	src, err := source.New(rm.RDSConfig)
+	if err != nil {
+		return err
+	}
 
 	// 分析数据
-	rm.Processer = NewProcesser(msgChan)
-	rm.Processer.Run()
+	ctx, cancel := context.WithCancel(context.Background())
+	rm.cancel = cancel
+	rm.alertChan = make(chan *alert.AlertEvent, 100)
+	go rm.sendAlerts()
+	rm.Processer = NewProcesser(src)
+	rm.Processer.Alerter = NewAlerter(rm.RDSConfig)
+	rm.Processer.AlertChan = rm.alertChan
+	err = rm.Processer.Run(ctx)
+	close(rm.alertChan)
+	return err
+}
 
-	return nil
+// sendAlerts 输出 Processer 触发的报警
+func (rm *RedisMonitor) sendAlerts() {
+	for e := range rm.alertChan {
+		log.Warn(e.String())
+	}
 }
 
 func (rm *RedisMonitor) Cleanup(m *monitor.Monitor) error {
@@ -63,4 +76,8 @@ func (rm *RedisMonitor) Cleanup(m *monitor.Monitor) error {
 
 func (rm *RedisMonitor) Stop() {
 	// Stopping kafka consumergroup
+	// 停止读取慢日志，Processer 处理并确认完已经收到的事件后退出
+	if rm.cancel != nil {
+		rm.cancel()
+	}
 }
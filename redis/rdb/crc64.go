@@ -0,0 +1,79 @@
+package rdb
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// Redis 使用的 CRC64 为 Jones 多项式（0xad93d23594c935a9）的反射版本，
+// 初始值为 0，结果不取反。"123456789" 的校验和为 0xe9c6d914c4b8d9ca
+const crc64JonesReflected = 0x95ac9329ac4bc9b5
+
+var crc64Table = makeCRC64Table()
+
+func makeCRC64Table() *[256]uint64 {
+	t := new([256]uint64)
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ crc64JonesReflected
+			} else {
+				crc >>= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// crc64Update 在 crc 的基础上继续计算 p 的校验和
+func crc64Update(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
+
+// ChecksumError 表示 RDB 文件末尾记录的 CRC64 和文件内容计算出来的不一致
+type ChecksumError struct {
+	Expected uint64 // 文件末尾记录的校验和
+	Actual   uint64 // 根据文件内容计算出来的校验和
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("rdb: checksum mismatch, expected %#016x, got %#016x", e.Expected, e.Actual)
+}
+
+// checksumReader 在读取数据的同时计算已读内容的 CRC64，并记录已读的字节数；
+// capturing 为 true 时还会把读到的内容追加到 captured 中
+type checksumReader struct {
+	rd     *bufio.Reader
+	crc    uint64
+	offset int64
+
+	capturing bool
+	captured  []byte
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.rd.Read(p)
+	c.crc = crc64Update(c.crc, p[:n])
+	c.offset += int64(n)
+	if c.capturing {
+		c.captured = append(c.captured, p[:n]...)
+	}
+	return n, err
+}
+
+func (c *checksumReader) ReadByte() (byte, error) {
+	b, err := c.rd.ReadByte()
+	if err == nil {
+		c.crc = crc64Table[byte(c.crc)^b] ^ c.crc>>8
+		c.offset++
+		if c.capturing {
+			c.captured = append(c.captured, b)
+		}
+	}
+	return b, err
+}
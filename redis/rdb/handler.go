@@ -0,0 +1,283 @@
+package rdb
+
+// 各种 value 在 RDB 中的编码方式，和 OBJECT ENCODING 的返回值保持一致
+const (
+	EncodingString     = "string"
+	EncodingLinkedList = "linkedlist"
+	EncodingHashtable  = "hashtable"
+	EncodingSkiplist   = "skiplist"
+	EncodingZipmap     = "zipmap"
+	EncodingZiplist    = "ziplist"
+	EncodingIntset     = "intset"
+	EncodingQuicklist  = "quicklist"
+	EncodingListpack   = "listpack"
+	EncodingStream     = "stream"
+	// EncodingModule 在读出 module id 之前使用，之后会被替换为 module 的名字
+	EncodingModule = "module"
+)
+
+// KeyInfo 是解析过程中当前 key 的元信息，随每个 key 的事件一起传给 Handler
+type KeyInfo struct {
+	DB       int
+	Expiry   int64  // 过期时间，毫秒时间戳，0 表示没有设置过期时间
+	Idle     int64  // LRU idle 秒数，-1 表示 RDB 中没有记录
+	Freq     int    // LFU 计数器，-1 表示 RDB 中没有记录
+	Type     string // string/list/set/zset/hash/stream/module
+	Encoding string // module 类型为 module 的名字，例如 ReJSON-RL
+
+	// SizeOfValue 为 ziplist/listpack/intset/zipmap 等紧凑编码的字节数之和，
+	// 其他编码为 0；在 End* 回调时才是最终值
+	SizeOfValue uint64
+	// Nodes 为 quicklist 的节点数或 stream 的 listpack 节点数
+	Nodes uint64
+	// Offset 为 key 的类型字节在文件中的偏移量
+	Offset int64
+}
+
+// Handler 接收 RDB 解析过程中产生的事件。
+//
+// 集合类型的 key 会依次触发 Start*、每个元素一次的 Hset/Sadd/Rpush/Zadd/StreamEntry
+// 和 End*。length 在 RDB 中无法提前得知时（例如 quicklist）为 -1。
+type Handler interface {
+	StartRDB(version int)
+	StartDatabase(db int)
+	Aux(key, value []byte)
+	ResizeDB(dbSize, expiresSize uint64)
+	Function(code []byte)
+
+	Set(key, value []byte, info *KeyInfo)
+
+	StartHash(key []byte, length int64, info *KeyInfo)
+	Hset(key, field, value []byte)
+	EndHash(key []byte, info *KeyInfo)
+
+	StartSet(key []byte, cardinality int64, info *KeyInfo)
+	Sadd(key, member []byte)
+	EndSet(key []byte, info *KeyInfo)
+
+	StartList(key []byte, length int64, info *KeyInfo)
+	Rpush(key, value []byte)
+	EndList(key []byte, info *KeyInfo)
+
+	StartZSet(key []byte, cardinality int64, info *KeyInfo)
+	Zadd(key []byte, score float64, member []byte)
+	EndZSet(key []byte, info *KeyInfo)
+
+	StartStream(key []byte, info *KeyInfo)
+	StreamEntry(key []byte, entry *StreamEntry)
+	EndStream(key []byte, meta *StreamMeta, info *KeyInfo)
+
+	Module(key []byte, module string, value interface{}, info *KeyInfo)
+
+	EndDatabase(db int)
+	EndRDB()
+}
+
+// NopHandler 忽略所有事件，可以嵌入到只关心部分事件的 Handler 中
+type NopHandler struct{}
+
+func (NopHandler) StartRDB(version int)                                               {}
+func (NopHandler) StartDatabase(db int)                                               {}
+func (NopHandler) Aux(key, value []byte)                                              {}
+func (NopHandler) ResizeDB(dbSize, expiresSize uint64)                                {}
+func (NopHandler) Function(code []byte)                                               {}
+func (NopHandler) Set(key, value []byte, info *KeyInfo)                               {}
+func (NopHandler) StartHash(key []byte, length int64, info *KeyInfo)                  {}
+func (NopHandler) Hset(key, field, value []byte)                                      {}
+func (NopHandler) EndHash(key []byte, info *KeyInfo)                                  {}
+func (NopHandler) StartSet(key []byte, cardinality int64, info *KeyInfo)              {}
+func (NopHandler) Sadd(key, member []byte)                                            {}
+func (NopHandler) EndSet(key []byte, info *KeyInfo)                                   {}
+func (NopHandler) StartList(key []byte, length int64, info *KeyInfo)                  {}
+func (NopHandler) Rpush(key, value []byte)                                            {}
+func (NopHandler) EndList(key []byte, info *KeyInfo)                                  {}
+func (NopHandler) StartZSet(key []byte, cardinality int64, info *KeyInfo)             {}
+func (NopHandler) Zadd(key []byte, score float64, member []byte)                      {}
+func (NopHandler) EndZSet(key []byte, info *KeyInfo)                                  {}
+func (NopHandler) StartStream(key []byte, info *KeyInfo)                              {}
+func (NopHandler) StreamEntry(key []byte, entry *StreamEntry)                         {}
+func (NopHandler) EndStream(key []byte, meta *StreamMeta, info *KeyInfo)              {}
+func (NopHandler) Module(key []byte, module string, value interface{}, info *KeyInfo) {}
+func (NopHandler) EndDatabase(db int)                                                 {}
+func (NopHandler) EndRDB()                                                            {}
+
+// encodingName 返回 RDB 类型对应的编码方式
+func encodingName(dtype byte) string {
+	switch dtype {
+	case RDBTypeString:
+		return EncodingString
+	case RDBTypeList:
+		return EncodingLinkedList
+	case RDBTypeSet, RDBTypeHash:
+		return EncodingHashtable
+	case RDBTypeZSet, RDBTypeZSet2:
+		return EncodingSkiplist
+	case RDBTypeHashZipmap:
+		return EncodingZipmap
+	case RDBTypeListZiplist, RDBTypeZSetZiplist, RDBTypeHashZiplist:
+		return EncodingZiplist
+	case RDBTypeSetIntset:
+		return EncodingIntset
+	case RDBTypeListQuicklist, RDBTypeListQuicklist2:
+		return EncodingQuicklist
+	case RDBTypeHashListpack, RDBTypeZSetListpack, RDBTypeSetListpack:
+		return EncodingListpack
+	case RDBTypeStreamListpacks, RDBTypeStreamListpacks2, RDBTypeStreamListpacks3:
+		return EncodingStream
+	case RDBTypeModule, RDBTypeModule2:
+		return EncodingModule
+	}
+	return "unknown"
+}
+
+// multiHandler 把每个事件依次转发给多个 Handler
+type multiHandler []Handler
+
+// MultiHandler 返回一个把事件依次转发给 handlers 的 Handler，用于一次解析同时做多种分析
+func MultiHandler(handlers ...Handler) Handler {
+	return multiHandler(handlers)
+}
+
+func (m multiHandler) StartRDB(version int) {
+	for _, h := range m {
+		h.StartRDB(version)
+	}
+}
+
+func (m multiHandler) StartDatabase(db int) {
+	for _, h := range m {
+		h.StartDatabase(db)
+	}
+}
+
+func (m multiHandler) Aux(key, value []byte) {
+	for _, h := range m {
+		h.Aux(key, value)
+	}
+}
+
+func (m multiHandler) ResizeDB(dbSize, expiresSize uint64) {
+	for _, h := range m {
+		h.ResizeDB(dbSize, expiresSize)
+	}
+}
+
+func (m multiHandler) Function(code []byte) {
+	for _, h := range m {
+		h.Function(code)
+	}
+}
+
+func (m multiHandler) Set(key, value []byte, info *KeyInfo) {
+	for _, h := range m {
+		h.Set(key, value, info)
+	}
+}
+
+func (m multiHandler) StartHash(key []byte, length int64, info *KeyInfo) {
+	for _, h := range m {
+		h.StartHash(key, length, info)
+	}
+}
+
+func (m multiHandler) Hset(key, field, value []byte) {
+	for _, h := range m {
+		h.Hset(key, field, value)
+	}
+}
+
+func (m multiHandler) EndHash(key []byte, info *KeyInfo) {
+	for _, h := range m {
+		h.EndHash(key, info)
+	}
+}
+
+func (m multiHandler) StartSet(key []byte, cardinality int64, info *KeyInfo) {
+	for _, h := range m {
+		h.StartSet(key, cardinality, info)
+	}
+}
+
+func (m multiHandler) Sadd(key, member []byte) {
+	for _, h := range m {
+		h.Sadd(key, member)
+	}
+}
+
+func (m multiHandler) EndSet(key []byte, info *KeyInfo) {
+	for _, h := range m {
+		h.EndSet(key, info)
+	}
+}
+
+func (m multiHandler) StartList(key []byte, length int64, info *KeyInfo) {
+	for _, h := range m {
+		h.StartList(key, length, info)
+	}
+}
+
+func (m multiHandler) Rpush(key, value []byte) {
+	for _, h := range m {
+		h.Rpush(key, value)
+	}
+}
+
+func (m multiHandler) EndList(key []byte, info *KeyInfo) {
+	for _, h := range m {
+		h.EndList(key, info)
+	}
+}
+
+func (m multiHandler) StartZSet(key []byte, cardinality int64, info *KeyInfo) {
+	for _, h := range m {
+		h.StartZSet(key, cardinality, info)
+	}
+}
+
+func (m multiHandler) Zadd(key []byte, score float64, member []byte) {
+	for _, h := range m {
+		h.Zadd(key, score, member)
+	}
+}
+
+func (m multiHandler) EndZSet(key []byte, info *KeyInfo) {
+	for _, h := range m {
+		h.EndZSet(key, info)
+	}
+}
+
+func (m multiHandler) StartStream(key []byte, info *KeyInfo) {
+	for _, h := range m {
+		h.StartStream(key, info)
+	}
+}
+
+func (m multiHandler) StreamEntry(key []byte, entry *StreamEntry) {
+	for _, h := range m {
+		h.StreamEntry(key, entry)
+	}
+}
+
+func (m multiHandler) EndStream(key []byte, meta *StreamMeta, info *KeyInfo) {
+	for _, h := range m {
+		h.EndStream(key, meta, info)
+	}
+}
+
+func (m multiHandler) Module(key []byte, module string, value interface{}, info *KeyInfo) {
+	for _, h := range m {
+		h.Module(key, module, value, info)
+	}
+}
+
+func (m multiHandler) EndDatabase(db int) {
+	for _, h := range m {
+		h.EndDatabase(db)
+	}
+}
+
+func (m multiHandler) EndRDB() {
+	for _, h := range m {
+		h.EndRDB()
+	}
+}
@@ -0,0 +1,222 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+/*
+listpack 的内存布局（Redis 7.0 起替代 ziplist）：
++-------------+--------------+--------+-----+--------+--------+
+| total-bytes | num-elements | entry1 | ... | entryN | lp-end |
++-------------+--------------+--------+-----+--------+--------+
+    4字节          2字节                                 0xff
+
+每个 entry 的布局： | encoding | data | backlen |
+backlen 记录 encoding+data 的长度，用于反向遍历，正向解码时直接跳过
+*/
+
+// listpack entry 的编码
+const (
+	lpEncoding7BitUint    = 0x00 // 0xxxxxxx
+	lpEncoding6BitStr     = 0x80 // 10xxxxxx
+	lpEncoding13BitInt    = 0xc0 // 110xxxxx yyyyyyyy
+	lpEncoding12BitStr    = 0xe0 // 1110xxxx yyyyyyyy
+	lpEncoding32BitStr    = 0xf0 // 11110000 + 4 字节长度
+	lpEncoding16BitInt    = 0xf1
+	lpEncoding24BitInt    = 0xf2
+	lpEncoding32BitInt    = 0xf3
+	lpEncoding64BitInt    = 0xf4
+	lpEncodingEOF         = 0xff
+	lpEncodingNumElemsMax = 65535 // num-elements 为该值时表示元素个数未知
+)
+
+// decodeListpack 解码一个 listpack，整数类型的 entry 会被转换成十进制字符串
+func decodeListpack(buf []byte) ([][]byte, error) {
+	s := &sliceReader{buf: buf}
+	header, err := s.next(6)
+	if err != nil {
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint16(header[4:]))
+	if length == lpEncodingNumElemsMax {
+		length = 0
+	}
+
+	entries := make([][]byte, 0, length)
+	for {
+		entry, end, err := decodeListpackEntry(s)
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func decodeListpackEntry(s *sliceReader) (entry []byte, end bool, err error) {
+	start := s.pos
+	b, err := s.readByte()
+	if err != nil {
+		return
+	}
+
+	var (
+		value   int64
+		isInt   = true
+		strLen  = -1
+		uval    uint64
+		negBits uint
+	)
+	switch {
+	case b == lpEncodingEOF:
+		end = true
+		return
+	case b&0x80 == lpEncoding7BitUint:
+		value = int64(b & 0x7f)
+	case b&0xc0 == lpEncoding6BitStr:
+		isInt = false
+		strLen = int(b & 0x3f)
+	case b&0xe0 == lpEncoding13BitInt:
+		var next byte
+		if next, err = s.readByte(); err != nil {
+			return
+		}
+		uval, negBits = uint64(b&0x1f)<<8|uint64(next), 13
+	case b&0xf0 == lpEncoding12BitStr:
+		var next byte
+		if next, err = s.readByte(); err != nil {
+			return
+		}
+		isInt = false
+		strLen = int(b&0x0f)<<8 | int(next)
+	case b == lpEncoding32BitStr:
+		var l []byte
+		if l, err = s.next(4); err != nil {
+			return
+		}
+		isInt = false
+		strLen = int(binary.LittleEndian.Uint32(l))
+	case b >= lpEncoding16BitInt && b <= lpEncoding64BitInt:
+		size := [...]int{2, 3, 4, 8}[b-lpEncoding16BitInt]
+		var raw []byte
+		if raw, err = s.next(size); err != nil {
+			return
+		}
+		for i := size - 1; i >= 0; i-- {
+			uval = uval<<8 | uint64(raw[i])
+		}
+		negBits = uint(size * 8)
+	default:
+		err = fmt.Errorf("unknown listpack entry encoding %#x", b)
+		return
+	}
+
+	if isInt {
+		if negBits > 0 {
+			// 按位宽做符号扩展
+			if negBits < 64 && uval >= 1<<(negBits-1) {
+				value = int64(uval) - int64(1)<<negBits
+			} else {
+				value = int64(uval)
+			}
+		}
+		entry = []byte(strconv.FormatInt(value, 10))
+	} else if entry, err = s.next(strLen); err != nil {
+		return
+	}
+
+	// 跳过 backlen
+	_, err = s.next(listpackBacklenSize(s.pos - start))
+	return
+}
+
+// listpackBacklenSize 返回保存长度 l 所需的 backlen 字节数
+func listpackBacklenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// encodeListpack 把 entries 编码为 listpack，可以表示为整数的 entry 按整数编码
+func encodeListpack(entries [][]byte) []byte {
+	buf := make([]byte, 6, 7)
+	for _, entry := range entries {
+		start := len(buf)
+		buf = appendListpackEntry(buf, entry)
+		buf = appendListpackBacklen(buf, len(buf)-start)
+	}
+	buf = append(buf, lpEncodingEOF)
+
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(buf)))
+	length := len(entries)
+	if length > lpEncodingNumElemsMax {
+		length = lpEncodingNumElemsMax
+	}
+	binary.LittleEndian.PutUint16(buf[4:], uint16(length))
+	return buf
+}
+
+func appendListpackEntry(buf, entry []byte) []byte {
+	if v, ok := parseStrictInt(entry); ok {
+		var size int
+		var enc byte
+		switch {
+		case v >= 0 && v <= 127:
+			return append(buf, byte(v))
+		case v >= -4096 && v <= 4095:
+			u := uint64(v) & 0x1fff
+			return append(buf, lpEncoding13BitInt|byte(u>>8), byte(u))
+		case v >= math.MinInt16 && v <= math.MaxInt16:
+			enc, size = lpEncoding16BitInt, 2
+		case v >= -1<<23 && v < 1<<23:
+			enc, size = lpEncoding24BitInt, 3
+		case v >= math.MinInt32 && v <= math.MaxInt32:
+			enc, size = lpEncoding32BitInt, 4
+		default:
+			enc, size = lpEncoding64BitInt, 8
+		}
+		buf = append(buf, enc)
+		for i := 0; i < size; i++ {
+			buf = append(buf, byte(uint64(v)>>(8*uint(i))))
+		}
+		return buf
+	}
+
+	switch l := len(entry); {
+	case l < 64:
+		buf = append(buf, lpEncoding6BitStr|byte(l))
+	case l < 4096:
+		buf = append(buf, lpEncoding12BitStr|byte(l>>8), byte(l))
+	default:
+		buf = append(buf, lpEncoding32BitStr, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(buf[len(buf)-4:], uint32(l))
+	}
+	return append(buf, entry...)
+}
+
+// appendListpackBacklen 追加 entry 的 backlen，从右往左读取，除最左边的字节外最高位都为 1
+func appendListpackBacklen(buf []byte, l int) []byte {
+	size := listpackBacklenSize(l)
+	for i := size - 1; i >= 0; i-- {
+		b := byte(l >> (7 * uint(i)) & 0x7f)
+		if i < size-1 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
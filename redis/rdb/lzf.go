@@ -0,0 +1,120 @@
+package rdb
+
+import "errors"
+
+var errLZFCorrupt = errors.New("lzf: corrupt compressed data")
+
+// lzfMaxRatio 是 LZF 能达到的最大压缩比：3 个字节的回溯引用最多展开为 264 个字节
+const lzfMaxRatio = 88
+
+// lzfDecompress 解压 LZF 格式的数据，outLen 为解压后的原始长度
+//
+// LZF 的数据由一系列控制字节组成：
+//
+//	ctrl < 32    后面跟着 ctrl+1 个字面量字节
+//	ctrl >= 32   回溯引用，高 3 位为长度（为 7 时再读一个字节累加），
+//	             低 5 位和下一个字节组成回溯的偏移量
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	if outLen < 0 || outLen > len(in)*lzfMaxRatio {
+		return nil, errLZFCorrupt
+	}
+	out := make([]byte, outLen)
+	ip, op := 0, 0
+
+	for ip < len(in) {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < 1<<5 { // 字面量
+			ctrl++
+			if op+ctrl > outLen || ip+ctrl > len(in) {
+				return nil, errLZFCorrupt
+			}
+			copy(out[op:], in[ip:ip+ctrl])
+			op += ctrl
+			ip += ctrl
+			continue
+		}
+
+		// 回溯引用
+		length := ctrl >> 5
+		ref := op - ((ctrl & 0x1f) << 8) - 1
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errLZFCorrupt
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errLZFCorrupt
+		}
+		ref -= int(in[ip])
+		ip++
+		length += 2
+
+		if ref < 0 || op+length > outLen {
+			return nil, errLZFCorrupt
+		}
+		// 引用区间可能和输出区间重叠，只能逐字节复制
+		for i := 0; i < length; i++ {
+			out[op] = out[ref]
+			op++
+			ref++
+		}
+	}
+
+	if op != outLen {
+		return nil, errLZFCorrupt
+	}
+	return out, nil
+}
+
+// lzfCompress 用贪心匹配压缩数据，返回 nil 表示压缩后没有变小
+func lzfCompress(in []byte) []byte {
+	const (
+		maxLiteral = 1 << 5
+		maxOffset  = 1 << 13
+		maxRef     = 255 + 7 + 2 // 回溯引用的最大长度
+	)
+	out := make([]byte, 0, len(in))
+	table := make(map[[3]byte]int)
+	literal := -1 // 当前字面量控制字节在 out 中的位置
+
+	ip := 0
+	for ip < len(in) {
+		if ip+2 < len(in) {
+			seq := [3]byte{in[ip], in[ip+1], in[ip+2]}
+			ref, ok := table[seq]
+			table[seq] = ip
+			if ok && ip-ref <= maxOffset {
+				length := 3
+				for ip+length < len(in) && length < maxRef && in[ref+length] == in[ip+length] {
+					length++
+				}
+				off := ip - ref - 1
+				if length-2 < 7 {
+					out = append(out, byte((length-2)<<5|off>>8))
+				} else {
+					out = append(out, byte(7<<5|off>>8), byte(length-2-7))
+				}
+				out = append(out, byte(off))
+				literal = -1
+				ip += length
+				continue
+			}
+		}
+		if literal < 0 || out[literal] == maxLiteral-1 {
+			literal = len(out)
+			out = append(out, 0)
+		} else {
+			out[literal]++
+		}
+		out = append(out, in[ip])
+		ip++
+		if len(out) >= len(in) {
+			return nil
+		}
+	}
+	return out
+}
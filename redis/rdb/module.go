@@ -0,0 +1,264 @@
+package rdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+module 类型的 value 在 RDB 中的布局：
+| module id | module 的 rdb_save 写入的内容 |
+
+module id 是一个 64 位整数，高 54 位为 9 个字符的 module 名字（每个字符 6 位），
+低 10 位为 encver。RDB_TYPE_MODULE_2 中 module 写入的每个字段前都有一个 RDBModuleOpcode*，
+最后以 RDBModuleOpcodeEOF 结束，因此不认识的 module 也可以跳过；
+RDB_TYPE_MODULE_PRE_GA 没有 opcode，只能用注册的解码器读取。
+*/
+
+// moduleIDCharset 是 module 名字可以使用的 64 个字符
+const moduleIDCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// DecodeModuleID 从 64 位的 module id 中解出 module 的名字和 encver
+func DecodeModuleID(id uint64) (name string, encver int) {
+	encver = int(id & 1023)
+	id >>= 10
+	b := make([]byte, 9)
+	for i := 8; i >= 0; i-- {
+		b[i] = moduleIDCharset[id&63]
+		id >>= 6
+	}
+	return string(b), encver
+}
+
+// EncodeModuleID 把 9 个字符的 module 名字和 encver 编码为 module id
+func EncodeModuleID(name string, encver int) (uint64, error) {
+	if len(name) != 9 {
+		return 0, fmt.Errorf("rdb: module name %s must have 9 characters", strconv.Quote(name))
+	}
+	if encver < 0 || encver > 1023 {
+		return 0, fmt.Errorf("rdb: module encver %d out of range", encver)
+	}
+	var id uint64
+	for i := 0; i < len(name); i++ {
+		j := strings.IndexByte(moduleIDCharset, name[i])
+		if j < 0 {
+			return 0, fmt.Errorf("rdb: invalid character %q in module name %s", name[i], strconv.Quote(name))
+		}
+		id = id<<6 | uint64(j)
+	}
+	return id<<10 | uint64(encver), nil
+}
+
+// ModuleValue 是没有注册解码器的 module value，Fields 按写入顺序保存 module 的各个字段，
+// 类型为 int64、uint64、float32、float64 或 []byte
+type ModuleValue struct {
+	Module string
+	EncVer int
+	Fields []interface{}
+}
+
+// ModuleSizer 可以由解码器返回的 value 实现，报告 value 在 Redis 中实际占用的内存；
+// 没有实现时以 value 在 RDB 中的字节数作为 KeyInfo.SizeOfValue
+type ModuleSizer interface {
+	MemoryUsage() uint64
+}
+
+// ModuleDecoder 解码一个 module 类型的 value，和 module 的 rdb_load 回调一样按写入顺序读取字段
+type ModuleDecoder func(r *ModuleReader, encver int) (interface{}, error)
+
+var (
+	modulesMu sync.RWMutex
+	modules   = make(map[string]ModuleDecoder)
+)
+
+// RegisterModule 为名字为 name 的 module 注册解码器，重复注册时覆盖之前的解码器
+func RegisterModule(name string, decoder ModuleDecoder) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	modules[name] = decoder
+}
+
+func moduleDecoder(name string) ModuleDecoder {
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+	return modules[name]
+}
+
+// ModuleReader 按 module API 的 RedisModule_Load* 的方式读取 module 写入的字段
+type ModuleReader struct {
+	r       *RDB
+	opcodes bool // RDB_TYPE_MODULE_2 的每个字段前都有 opcode
+	eof     bool // 已经读到了 RDBModuleOpcodeEOF
+}
+
+// expect 读取字段前的 opcode，类型不一致时跳过这个字段，保证读取位置仍然是对齐的
+func (m *ModuleReader) expect(opcode uint64) error {
+	if !m.opcodes {
+		return nil
+	}
+	if m.eof {
+		return fmt.Errorf("module value has no more fields")
+	}
+	got, err := m.r.readLength()
+	if err != nil {
+		return err
+	}
+	if got == opcode {
+		return nil
+	}
+	if got == RDBModuleOpcodeEOF {
+		m.eof = true
+		return fmt.Errorf("module value has no more fields")
+	}
+	if _, err = m.r.readModuleField(got); err != nil {
+		return err
+	}
+	return fmt.Errorf("module field has opcode %d, expected %d", got, opcode)
+}
+
+// LoadUnsigned 对应 RedisModule_LoadUnsigned
+func (m *ModuleReader) LoadUnsigned() (uint64, error) {
+	if err := m.expect(RDBModuleOpcodeUInt); err != nil {
+		return 0, err
+	}
+	return m.r.readLength()
+}
+
+// LoadSigned 对应 RedisModule_LoadSigned
+func (m *ModuleReader) LoadSigned() (int64, error) {
+	if err := m.expect(RDBModuleOpcodeSInt); err != nil {
+		return 0, err
+	}
+	v, err := m.r.readLength()
+	return int64(v), err
+}
+
+// LoadString 对应 RedisModule_LoadStringBuffer
+func (m *ModuleReader) LoadString() ([]byte, error) {
+	if err := m.expect(RDBModuleOpcodeString); err != nil {
+		return nil, err
+	}
+	return m.r.readString()
+}
+
+// LoadDouble 对应 RedisModule_LoadDouble
+func (m *ModuleReader) LoadDouble() (float64, error) {
+	if err := m.expect(RDBModuleOpcodeDouble); err != nil {
+		return 0, err
+	}
+	return m.r.readBinaryDouble()
+}
+
+// LoadFloat 对应 RedisModule_LoadFloat
+func (m *ModuleReader) LoadFloat() (float32, error) {
+	if err := m.expect(RDBModuleOpcodeFloat); err != nil {
+		return 0, err
+	}
+	return m.r.readBinaryFloat()
+}
+
+// readModuleFields 读取 RDB_TYPE_MODULE_2 中剩下的字段直到 RDBModuleOpcodeEOF
+func (r *RDB) readModuleFields() ([]interface{}, error) {
+	var fields []interface{}
+	for {
+		opcode, err := r.readLength()
+		if err != nil {
+			return nil, err
+		}
+		if opcode == RDBModuleOpcodeEOF {
+			return fields, nil
+		}
+		field, err := r.readModuleField(opcode)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+}
+
+// readModuleField 读取 opcode 之后的一个字段
+func (r *RDB) readModuleField(opcode uint64) (interface{}, error) {
+	switch opcode {
+	case RDBModuleOpcodeSInt:
+		v, err := r.readLength()
+		return int64(v), err
+	case RDBModuleOpcodeUInt:
+		return r.readLength()
+	case RDBModuleOpcodeFloat:
+		return r.readBinaryFloat()
+	case RDBModuleOpcodeDouble:
+		return r.readBinaryDouble()
+	case RDBModuleOpcodeString:
+		return r.readString()
+	}
+	return nil, fmt.Errorf("unknown module opcode %d", opcode)
+}
+
+// readModule 读取 RDBTypeModule 和 RDBTypeModule2 类型的 value。
+// 注册了解码器时交给解码器读取，否则 RDBTypeModule2 按 opcode 读出所有字段作为 *ModuleValue
+func (r *RDB) readModule(key []byte, dtype byte, info *KeyInfo) error {
+	start := r.rd.offset
+	id, err := r.readLength()
+	if err != nil {
+		return err
+	}
+	name, encver := DecodeModuleID(id)
+	info.Encoding = name
+
+	var value interface{}
+	decoder := moduleDecoder(name)
+	if r.raw && dtype == RDBTypeModule2 {
+		decoder = nil // 只读取原始字节时按 opcode 跳过即可，由 worker 解码
+	}
+	switch {
+	case decoder != nil:
+		raw := r.raw
+		r.raw = false // RDBTypeModule 只能由解码器读取，需要解码后的字段才能知道后面的内容
+		m := &ModuleReader{r: r, opcodes: dtype == RDBTypeModule2}
+		value, err = callModuleDecoder(decoder, m, encver)
+		r.raw = raw
+		if m.opcodes {
+			// 解码器没有读完或者解码出错时，按 opcode 跳过剩下的字段，保证读取位置是对齐的
+			if !m.eof {
+				if _, skipErr := r.readModuleFields(); skipErr != nil {
+					return skipErr
+				}
+			}
+			if err != nil {
+				return corrupt(fmt.Errorf("module %s of key %s: %v", name, strconv.Quote(string(key)), err))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	case dtype == RDBTypeModule2:
+		fields, err := r.readModuleFields()
+		if err != nil {
+			return err
+		}
+		value = &ModuleValue{Module: name, EncVer: encver, Fields: fields}
+	default:
+		// RDBTypeModule 没有 opcode，找不到 value 的结尾，即使在容错模式下也只能停止解析
+		return fmt.Errorf("no decoder registered for module %s (encver %d) of key %s", name, encver, strconv.Quote(string(key)))
+	}
+
+	if sizer, ok := value.(ModuleSizer); ok {
+		info.SizeOfValue = sizer.MemoryUsage()
+	} else {
+		info.SizeOfValue = uint64(r.rd.offset - start)
+	}
+	r.handler.Module(key, name, value, info)
+	return nil
+}
+
+// callModuleDecoder 调用解码器，把解码器遇到损坏数据时的 panic 转换为错误
+func callModuleDecoder(decoder ModuleDecoder, m *ModuleReader, encver int) (value interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			value, err = nil, fmt.Errorf("module decoder panic: %v", p)
+		}
+	}()
+	return decoder(m, encver)
+}
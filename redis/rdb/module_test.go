@@ -0,0 +1,118 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// moduleHandler 记录 module 事件
+type moduleHandler struct {
+	NopHandler
+	keys    []string
+	modules []string
+	values  []interface{}
+	infos   []KeyInfo
+}
+
+func (h *moduleHandler) Module(key []byte, module string, value interface{}, info *KeyInfo) {
+	h.keys = append(h.keys, string(key))
+	h.modules = append(h.modules, module)
+	h.values = append(h.values, value)
+	h.infos = append(h.infos, *info)
+}
+func (h *moduleHandler) Set(key, value []byte, info *KeyInfo) {
+	h.keys = append(h.keys, string(key))
+}
+
+func TestModuleID(t *testing.T) {
+	for _, name := range []string{ReJSONModule, "MBbloom--", "TimeSerie"} {
+		id, err := EncodeModuleID(name, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, encver := DecodeModuleID(id); got != name || encver != 3 {
+			t.Fatalf("DecodeModuleID(%#x) = %s %d", id, got, encver)
+		}
+	}
+	if _, err := EncodeModuleID("short", 0); err == nil {
+		t.Fatal("expected error for short module name")
+	}
+}
+
+func TestModule(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 9)
+	w.WriteHeader()
+	w.WriteModuleAux(&ModuleValue{Module: "graphdata", EncVer: 1, Fields: []interface{}{[]byte("aux")}}, 2)
+	w.SelectDB(0)
+	info := &KeyInfo{Idle: -1, Freq: -1}
+	bloom := &ModuleValue{Module: "MBbloom--", EncVer: 4, Fields: []interface{}{uint64(1), int64(-7), 0.01, float32(0.25), []byte(strings.Repeat("\x00\x01", 100))}}
+	w.WriteModule([]byte("bloom"), bloom, info)
+	w.WriteModule([]byte("doc"), &ModuleValue{Module: ReJSONModule, EncVer: 3, Fields: []interface{}{[]byte(`{"a":[1,"x"]}`)}}, info)
+	// ReJSON 1.0 的节点树：{"n":5,"ok":true}
+	w.WriteModule([]byte("legacy"), &ModuleValue{Module: ReJSONModule, EncVer: 0, Fields: []interface{}{
+		uint64(rejsonDict), uint64(2),
+		uint64(rejsonKeyVal), []byte("n"), uint64(rejsonInteger), int64(5),
+		uint64(rejsonKeyVal), []byte("ok"), uint64(rejsonBoolean), []byte("1"),
+	}}, info)
+	// 类型不符的文档，跳过之后仍然可以继续解析
+	w.WriteModule([]byte("bad"), &ModuleValue{Module: ReJSONModule, EncVer: 3, Fields: []interface{}{uint64(1), []byte("x")}}, info)
+	w.WriteString([]byte("after"), []byte("v"), info)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	h := &moduleHandler{}
+	r := NewRDB(bufio.NewReader(bytes.NewReader(data)), h)
+	r.Tolerant = true
+	if err := r.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(h.keys) != "[bloom doc legacy after]" {
+		t.Fatalf("unexpected keys %v", h.keys)
+	}
+	if errs := r.Errors(); len(errs) != 1 || string(errs[0].Key) != "bad" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	v, ok := h.values[0].(*ModuleValue)
+	if !ok || v.Module != "MBbloom--" || v.EncVer != 4 || fmt.Sprint(v.Fields[:4]) != "[1 -7 0.01 0.25]" || len(v.Fields[4].([]byte)) != 200 {
+		t.Fatalf("unexpected bloom value %+v", h.values[0])
+	}
+	if info := h.infos[0]; info.Type != "module" || info.Encoding != "MBbloom--" || info.SizeOfValue < 200 {
+		t.Fatalf("unexpected bloom info %+v", info)
+	}
+	if doc := h.values[1].(json.RawMessage); string(doc) != `{"a":[1,"x"]}` {
+		t.Fatalf("unexpected document %s", doc)
+	}
+	if doc := h.values[2].(json.RawMessage); string(doc) != `{"n":5,"ok":true}` {
+		t.Fatalf("unexpected legacy document %s", doc)
+	}
+
+	// 非容错模式下解码失败返回错误
+	if err := NewRDB(bufio.NewReader(bytes.NewReader(data)), nil).Parse(); err == nil || !strings.Contains(err.Error(), "bad") {
+		t.Fatalf("expected error for key bad, got %v", err)
+	}
+}
+
+func TestModulePreGA(t *testing.T) {
+	id, _ := EncodeModuleID("MBbloom--", 0)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 8)
+	w.WriteHeader()
+	w.writeByte(RDBTypeModule)
+	w.writeString([]byte("k"))
+	w.writeLength(id)
+	w.writeLength(42)
+	w.Close()
+
+	err := NewRDB(bufio.NewReader(bytes.NewReader(buf.Bytes())), nil).Parse()
+	if err == nil || !strings.Contains(err.Error(), "no decoder registered for module MBbloom--") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
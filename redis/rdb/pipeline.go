@@ -0,0 +1,226 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// errPipelineStopped 表示 Pipeline 因为解码出错停止了解析
+var errPipelineStopped = errors.New("rdb: pipeline stopped")
+
+// RawValue 是从 RDB 中读出但还没有解码的一个 key
+type RawValue struct {
+	Key     []byte
+	Type    byte // RDBType*
+	Version int
+	Info    KeyInfo
+	Data    []byte // value 的原始字节，不包含类型字节和 key
+}
+
+// Decode 解码 value 并把 key 的事件交给 handler，每次调用都使用 Info 的副本
+func (v *RawValue) Decode(handler Handler) error {
+	r := NewRDB(bufio.NewReaderSize(bytes.NewReader(v.Data), 64), handler)
+	r.version = v.Version
+	info := v.Info
+	err := r.readObject(v.Key, v.Type, &info)
+	if ve, ok := err.(*valueError); ok {
+		return ve.err
+	}
+	return err
+}
+
+// Pipeline 并行解析一个 RDB 文件。
+//
+// 解析只能顺序进行，Pipeline 在当前 goroutine 中只读出每个 key 的原始字节，
+// 由 Workers 个 goroutine 解压 LZF、解码紧凑编码并执行 NewDecoder 返回的解码函数，
+// 最后在一个 goroutine 中按 key 在文件中的顺序把结果交给 Merge，结果与 worker 个数无关。
+// 已读出但还没有合并的 key 最多 Queue 个，合并跟不上时解析会被阻塞，内存占用有上限。
+type Pipeline struct {
+	// Workers 为解码的 goroutine 个数，默认为 CPU 个数
+	Workers int
+	// Queue 为已读出但还没有合并的 key 的最大个数，默认为 Workers*64
+	Queue int
+	// Tolerant 为 true 时跳过无法解码的 key 并记录错误，见 RDB.Tolerant
+	Tolerant bool
+
+	// Handler 接收 key 以外的事件，与 Merge 在同一个 goroutine 中按文件中的顺序调用，可以为 nil
+	Handler Handler
+	// NewDecoder 为每个 worker 创建一个解码函数，解码函数把 value 转换成要合并的结果
+	NewDecoder func() func(v *RawValue) (interface{}, error)
+	// Merge 按 key 在文件中的顺序合并解码结果
+	Merge func(v *RawValue, result interface{})
+
+	errors []*ParseError
+}
+
+// pipelineJob 是解析 goroutine 交给 worker 和合并 goroutine 的一个任务，
+// value 为 nil 时表示 key 以外的事件
+type pipelineJob struct {
+	value  *RawValue
+	event  func(h Handler)
+	result interface{}
+	err    error
+	done   chan struct{}
+}
+
+// closedDone 是不需要 worker 处理的任务的 done
+var closedDone = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// Parse 解析 rd 中的 RDB 文件，非容错模式下遇到解码错误时停止解析并返回 *ParseError
+func (p *Pipeline) Parse(rd *bufio.Reader) error {
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	queue := p.Queue
+	if queue <= 0 {
+		queue = workers * 64
+	}
+	p.errors = nil
+
+	jobs := make(chan *pipelineJob, queue)
+	ordered := make(chan *pipelineJob, queue)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decode := p.NewDecoder()
+			for job := range jobs {
+				job.result, job.err = decode(job.value)
+				close(job.done)
+			}
+		}()
+	}
+	merged := make(chan *ParseError, 1)
+	go func() {
+		merged <- p.merge(ordered, stop)
+	}()
+
+	send := func(job *pipelineJob) error {
+		select {
+		case ordered <- job:
+		case <-stop:
+			return errPipelineStopped
+		}
+		if job.value != nil {
+			// worker 不依赖合并的进度，这里最多等待一个 worker 空闲
+			jobs <- job
+		}
+		return nil
+	}
+	r := NewRDB(rd, &pipelineHandler{send: send})
+	r.Tolerant = p.Tolerant
+	r.emit = func(v *RawValue) error {
+		return send(&pipelineJob{value: v, done: make(chan struct{})})
+	}
+	err := r.Parse()
+	close(jobs)
+	close(ordered)
+	wg.Wait()
+	pe := <-merged
+
+	p.errors = append(p.errors, r.Errors()...)
+	sort.SliceStable(p.errors, func(i, j int) bool { return p.errors[i].Offset < p.errors[j].Offset })
+	if pe != nil {
+		return pe
+	}
+	return err
+}
+
+// Errors 返回容错模式下记录的错误，按偏移量排序
+func (p *Pipeline) Errors() []*ParseError {
+	return p.errors
+}
+
+// merge 按顺序等待每个任务完成并合并结果，返回非容错模式下第一个解码错误
+func (p *Pipeline) merge(ordered <-chan *pipelineJob, stop chan struct{}) *ParseError {
+	handler := p.Handler
+	if handler == nil {
+		handler = NopHandler{}
+	}
+	var first *ParseError
+	for job := range ordered {
+		<-job.done
+		if first != nil {
+			continue // 已经停止，只需要取出剩下的任务
+		}
+		if job.value == nil {
+			job.event(handler)
+			continue
+		}
+		v := job.value
+		if job.err != nil {
+			pe := &ParseError{Offset: v.Info.Offset, DB: v.Info.DB, Key: v.Key, Opcode: v.Type, Err: job.err}
+			if !p.Tolerant {
+				first = pe
+				close(stop)
+				continue
+			}
+			p.errors = append(p.errors, pe)
+			continue
+		}
+		p.Merge(v, job.result)
+	}
+	return first
+}
+
+// readRaw 读出 value 的原始字节交给 emit
+func (r *RDB) readRaw(key []byte, dtype byte, info *KeyInfo) error {
+	handler := r.handler
+	r.handler = NopHandler{}
+	r.raw = true
+	r.rd.capturing = true
+	r.rd.captured = nil
+	err := r.readObject(key, dtype, info)
+	r.rd.capturing = false
+	r.raw = false
+	r.handler = handler
+	if err != nil {
+		return err
+	}
+	info.SizeOfValue, info.Nodes = 0, 0
+	return r.emit(&RawValue{Key: key, Type: dtype, Version: r.version, Info: *info, Data: r.rd.captured})
+}
+
+// pipelineHandler 把 key 以外的事件按顺序转交给合并 goroutine
+type pipelineHandler struct {
+	NopHandler
+	send func(job *pipelineJob) error
+}
+
+func (h *pipelineHandler) event(fn func(h Handler)) {
+	h.send(&pipelineJob{event: fn, done: closedDone})
+}
+
+func (h *pipelineHandler) StartRDB(version int) {
+	h.event(func(h Handler) { h.StartRDB(version) })
+}
+func (h *pipelineHandler) StartDatabase(db int) {
+	h.event(func(h Handler) { h.StartDatabase(db) })
+}
+func (h *pipelineHandler) Aux(key, value []byte) {
+	h.event(func(h Handler) { h.Aux(key, value) })
+}
+func (h *pipelineHandler) ResizeDB(dbSize, expiresSize uint64) {
+	h.event(func(h Handler) { h.ResizeDB(dbSize, expiresSize) })
+}
+func (h *pipelineHandler) Function(code []byte) {
+	h.event(func(h Handler) { h.Function(code) })
+}
+func (h *pipelineHandler) EndDatabase(db int) {
+	h.event(func(h Handler) { h.EndDatabase(db) })
+}
+func (h *pipelineHandler) EndRDB() {
+	h.event(func(h Handler) { h.EndRDB() })
+}
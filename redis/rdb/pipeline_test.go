@@ -0,0 +1,169 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// writeMixedDump 生成包含各种类型和编码的 RDB，每种类型 n 个 key
+func writeMixedDump(t testing.TB, n int) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 11)
+	w.Compress = true
+	w.WriteHeader()
+	w.WriteAux("redis-ver", "7.2.0")
+	w.SelectDB(0)
+	info := func(encoding string) *KeyInfo { return &KeyInfo{Encoding: encoding, Idle: -1, Freq: -1} }
+	for i := 0; i < n; i++ {
+		var items, scores = make([][]byte, 0, 64), make([]float64, 0, 64)
+		for j := 0; j < 64; j++ {
+			items = append(items, []byte(fmt.Sprintf("member:%d:%d:%s", i, j, strings.Repeat("x", j%8))))
+			scores = append(scores, float64(i*j)/3)
+		}
+		writes := []func() error{
+			func() error {
+				return w.WriteString([]byte(fmt.Sprintf("str:%d", i)), []byte(strings.Repeat(fmt.Sprint(i), 30)), info(""))
+			},
+			func() error { return w.WriteList([]byte(fmt.Sprintf("list:%d", i)), items, info(EncodingQuicklist)) },
+			func() error { return w.WriteSet([]byte(fmt.Sprintf("set:%d", i)), items, info(EncodingListpack)) },
+			func() error {
+				return w.WriteZSet([]byte(fmt.Sprintf("zset:%d", i)), items, scores, info(EncodingListpack))
+			},
+			func() error {
+				return w.WriteZSet([]byte(fmt.Sprintf("skiplist:%d", i)), items, scores, info(EncodingSkiplist))
+			},
+			func() error {
+				return w.WriteHash([]byte(fmt.Sprintf("hash:%d", i)), items, items, info(EncodingListpack))
+			},
+		}
+		for _, write := range writes {
+			if err := write(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// parallelValues 用 Pipeline 解析 data，返回和 valuesHandler 相同格式的结果
+func parallelValues(data []byte, p *Pipeline) (*valuesHandler, error) {
+	result := &valuesHandler{}
+	p.Handler = result
+	p.NewDecoder = func() func(v *RawValue) (interface{}, error) {
+		return func(v *RawValue) (interface{}, error) {
+			h := &valuesHandler{}
+			err := v.Decode(h)
+			return h, err
+		}
+	}
+	p.Merge = func(v *RawValue, r interface{}) {
+		h := r.(*valuesHandler)
+		result.keys = append(result.keys, h.keys...)
+		result.infos = append(result.infos, h.infos...)
+		result.values = append(result.values, h.values...)
+	}
+	err := p.Parse(bufio.NewReader(bytes.NewReader(data)))
+	return result, err
+}
+
+func TestPipeline(t *testing.T) {
+	data := writeMixedDump(t, 50)
+	want := &valuesHandler{}
+	if err := NewRDB(bufio.NewReader(bytes.NewReader(data)), want).Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 4} {
+		got, err := parallelValues(data, &Pipeline{Workers: workers, Queue: 3})
+		if err != nil {
+			t.Fatalf("workers=%d: %v", workers, err)
+		}
+		if got.aux["redis-ver"] != "7.2.0" || len(got.keys) != len(want.keys) {
+			t.Fatalf("workers=%d: got %d keys, want %d", workers, len(got.keys), len(want.keys))
+		}
+		for i := range want.keys {
+			if got.keys[i] != want.keys[i] || fmt.Sprint(got.values[i]) != fmt.Sprint(want.values[i]) {
+				t.Fatalf("workers=%d: key %d = %s, want %s", workers, i, got.keys[i], want.keys[i])
+			}
+			if got.infos[i] != want.infos[i] {
+				t.Fatalf("workers=%d: key %s info %+v, want %+v", workers, got.keys[i], got.infos[i], want.infos[i])
+			}
+		}
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 7)
+	w.WriteHeader()
+	w.SelectDB(0)
+	w.WriteString([]byte("a"), []byte("1"), &KeyInfo{Idle: -1, Freq: -1})
+	w.WriteZSet([]byte("z"), bytesList("m"), []float64{math.Inf(1)}, &KeyInfo{Encoding: EncodingZiplist, Idle: -1, Freq: -1})
+	w.WriteString([]byte("b"), []byte("2"), &KeyInfo{Idle: -1, Freq: -1})
+	w.Close()
+	data := buf.Bytes()
+	// 把 ziplist 的结束标记改掉，解码时出错，但原始字节仍然可以完整读出
+	data[bytes.Index(data, []byte{RDBTypeString, 0x01, 'b'})-1] = 0x05
+	// 重新计算校验和
+	body := data[:len(data)-8]
+	data = append(body, checksumBytes(body)...)
+
+	_, err := parallelValues(data, &Pipeline{Workers: 2})
+	if pe, ok := err.(*ParseError); !ok || string(pe.Key) != "z" {
+		t.Fatalf("expected *ParseError for key z, got %v", err)
+	}
+
+	p := &Pipeline{Workers: 2, Tolerant: true}
+	got, err := parallelValues(data, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got.keys) != "[a b]" || len(p.Errors()) != 1 || string(p.Errors()[0].Key) != "z" {
+		t.Fatalf("unexpected result %v %v", got.keys, p.Errors())
+	}
+}
+
+func checksumBytes(data []byte) []byte {
+	crc := crc64Update(0, data)
+	b := make([]byte, 8)
+	for i := range b {
+		b[i] = byte(crc >> (8 * uint(i)))
+	}
+	return b
+}
+
+func benchmarkParse(b *testing.B, parse func(data []byte) error) {
+	data := writeMixedDump(b, 2000)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := parse(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	benchmarkParse(b, func(data []byte) error {
+		return NewRDB(bufio.NewReader(bytes.NewReader(data)), nil).Parse()
+	})
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	benchmarkParse(b, func(data []byte) error {
+		p := &Pipeline{
+			NewDecoder: func() func(v *RawValue) (interface{}, error) {
+				return func(v *RawValue) (interface{}, error) { return nil, v.Decode(nil) }
+			},
+			Merge: func(v *RawValue, result interface{}) {},
+		}
+		return p.Parse(bufio.NewReader(bytes.NewReader(data)))
+	})
+}
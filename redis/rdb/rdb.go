@@ -8,6 +8,7 @@ import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"math"
 	"strconv"
 )
 
@@ -27,16 +28,55 @@ const (
 	RDB32BitLen = 0x80
 	RDB64BitLen = 0x81
 	RDBEncVal   = 3
+
+	// 当 readLength 遇到 RDBEncVal 时，低 6 位表示字符串的特殊编码方式
+	RDBEncInt8  = 0 // 8 bit signed integer
+	RDBEncInt16 = 1 // 16 bit signed integer
+	RDBEncInt32 = 2 // 32 bit signed integer
+	RDBEncLZF   = 3 // string compressed with FASTLZ
 	//  Special RDB opcodes
-	RDBOpcodeModuleAux    = 247
-	RDBOpcodeIdle         = 248 //LRU idle time.
-	RDBOpcodeFreq         = 249 //LFU frequency
-	RDBOpcodeAux          = 250 //辅助标识
-	RDBOpcodeResizeDB     = 251 //提示调整哈希表大小的操作码
-	RDBOpcodeExpireTimeMS = 252 //过期时间毫秒
-	RDBOpcodeExpireTime   = 253 //过期时间秒
-	RDBOpcodeSelectDB     = 254 //选择数据库的操作
-	RDBOpcodeEOF          = 255 //EOF码
+	RDBOpcodeSlotInfo      = 244 // 集群模式下 slot 的大小信息（RDB 12）
+	RDBOpcodeFunction2     = 245 // function library data
+	RDBOpcodeFunctionPreGA = 246 // old function library data for 7.0 rc1 and rc2
+	RDBOpcodeModuleAux     = 247
+	RDBOpcodeIdle          = 248 //LRU idle time.
+	RDBOpcodeFreq          = 249 //LFU frequency
+	RDBOpcodeAux           = 250 //辅助标识
+	RDBOpcodeResizeDB      = 251 //提示调整哈希表大小的操作码
+	RDBOpcodeExpireTimeMS  = 252 //过期时间毫秒
+	RDBOpcodeExpireTime    = 253 //过期时间秒
+	RDBOpcodeSelectDB      = 254 //选择数据库的操作
+	RDBOpcodeEOF           = 255 //EOF码
+
+	// Object types
+	RDBTypeString = 0
+	RDBTypeList   = 1
+	RDBTypeSet    = 2
+	RDBTypeZSet   = 3
+	RDBTypeHash   = 4
+	RDBTypeZSet2  = 5 // ZSET version 2 with doubles stored in binary.
+	RDBTypeModule = 6 // RDB_TYPE_MODULE_PRE_GA，字段前没有 opcode
+	// Module value with annotations for parsing without the generating module (RDB_TYPE_MODULE_2)
+	RDBTypeModule2 = 7
+	// Object types for encoded objects.
+	RDBTypeHashZipmap      = 9
+	RDBTypeListZiplist     = 10
+	RDBTypeSetIntset       = 11
+	RDBTypeZSetZiplist     = 12
+	RDBTypeHashZiplist     = 13
+	RDBTypeListQuicklist   = 14
+	RDBTypeStreamListpacks = 15
+	// RDB 10 之后新增的 listpack 编码
+	RDBTypeHashListpack     = 16
+	RDBTypeZSetListpack     = 17
+	RDBTypeListQuicklist2   = 18
+	RDBTypeStreamListpacks2 = 19
+	RDBTypeSetListpack      = 20
+	RDBTypeStreamListpacks3 = 21
+
+	// quicklist2 节点的容器类型
+	QuicklistNodeContainerPlain  = 1 // 单个大元素，直接保存为字符串
+	QuicklistNodeContainerPacked = 2 // 多个元素，保存为 listpack
 
 	RDBModuleOpcodeEOF    = 0 // End of module value.
 	RDBModuleOpcodeSInt   = 1
@@ -46,24 +86,129 @@ const (
 	RDBModuleOpcodeString = 5
 )
 
+// ErrTruncated 表示在读到 EOF 操作码之前文件就结束了
+var ErrTruncated = errors.New("rdb: unexpected end of file")
+
+// ParseError 是容错模式下记录的一个解析错误
+type ParseError struct {
+	Offset int64  // value 损坏时为 key 的类型字节的偏移量，无法继续解析时为已读取的字节数
+	DB     int    // 出错时所在的数据库，-1 表示还没有遇到 SELECTDB
+	Key    []byte // 出错的 key，读出 key 之前出错时为 nil
+	Opcode byte   // 出错时正在处理的操作码或 value 类型
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Key != nil {
+		return fmt.Sprintf("rdb: offset %d, db %d, key %s, type %d: %v", e.Offset, e.DB, strconv.Quote(string(e.Key)), e.Opcode, e.Err)
+	}
+	return fmt.Sprintf("rdb: offset %d, db %d, opcode %d: %v", e.Offset, e.DB, e.Opcode, e.Err)
+}
+
+// valueError 表示 value 的内容已经完整读出但无法解码，例如损坏的 LZF 数据或 ziplist，
+// 这时文件的读取位置仍然是对齐的，容错模式下可以跳过这个 value 继续解析
+type valueError struct {
+	err error
+}
+
+func (e *valueError) Error() string { return e.err.Error() }
+
+// corrupt 把完整读出后才发现损坏的 value 的错误标记为可以跳过
+func corrupt(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*valueError); ok {
+		return err
+	}
+	return &valueError{err: err}
+}
+
+// memberError 判断读取一个元素时的错误能否只跳过这个元素：元素已经完整读出，只是无法解压或解析
+func memberError(err error) bool {
+	_, ok := err.(*valueError)
+	return ok || err == errLZFCorrupt
+}
+
 type RDB struct {
-	rd *bufio.Reader
+	// Tolerant 为 true 时遇到损坏的 value 会记录错误并跳过，无法继续时停止解析，
+	// 但 Parse 不返回错误，通过 Errors 获取记录的错误；默认遇到错误立即返回
+	Tolerant bool
+
+	rd      *checksumReader
+	handler Handler
+	errors  []*ParseError
+
+	// emit 不为 nil 时只读出 value 的原始字节交给 emit，不解码也不调用 handler 的 key 事件，见 Pipeline
+	emit func(v *RawValue) error
+	raw  bool // 正在读取原始的 value，跳过 LZF 和紧凑编码的解码
 
 	version int
+	db      int // 当前的数据库，-1 表示还没有遇到 SELECTDB
 
 	expiry uint64 // 当前key的过期时间，毫秒计算
-	idle   uint64
-	freq   byte
+	idle   int64  // 当前key的 LRU idle 秒数，-1 表示没有
+	freq   int    // 当前key的 LFU 计数器，-1 表示没有
 }
 
-func NewRDB(rd *bufio.Reader) *RDB {
+// NewRDB 创建一个 RDB 解析器，解析过程中的事件交给 handler 处理，handler 为 nil 时忽略所有事件
+func NewRDB(rd *bufio.Reader, handler Handler) *RDB {
+	if handler == nil {
+		handler = NopHandler{}
+	}
 	r := &RDB{
-		rd: rd,
+		rd:      &checksumReader{rd: rd},
+		handler: handler,
+		db:      -1,
 	}
 	return r
 }
 
-func (r *RDB) Parse() (err error) {
+// Parse 解析整个 RDB 文件，文件不完整时返回 ErrTruncated，校验和不一致时返回 *ChecksumError。
+// 容错模式下只有文件头不合法时才返回错误
+func (r *RDB) Parse() error {
+	err := r.parse()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrTruncated
+	}
+	if err != nil && r.Tolerant && r.version > 0 {
+		// 无法继续解析，让 handler 按已经解析出来的内容结束
+		r.record(nil, 0, r.rd.offset, err)
+		r.handler.EndRDB()
+		return nil
+	}
+	return err
+}
+
+// Errors 返回容错模式下记录的错误，最后一个可能是导致解析停止的错误
+func (r *RDB) Errors() []*ParseError {
+	return r.errors
+}
+
+// Offset 返回已经读取的字节数
+func (r *RDB) Offset() int64 {
+	return r.rd.offset
+}
+
+func (r *RDB) record(key []byte, opcode byte, offset int64, err error) {
+	if ve, ok := err.(*valueError); ok {
+		err = ve.err
+	}
+	pe := &ParseError{Offset: offset, DB: r.db, Key: key, Opcode: opcode, Err: err}
+	log.Warnf("%v", pe)
+	r.errors = append(r.errors, pe)
+}
+
+// skip 判断容错模式下 err 是否可以跳过，可以时记录错误
+func (r *RDB) skip(key []byte, opcode byte, offset int64, err error) bool {
+	if _, ok := err.(*valueError); !ok || !r.Tolerant {
+		return false
+	}
+	r.record(key, opcode, offset, err)
+	return true
+}
+
+func (r *RDB) parse() (err error) {
 	// 获取  REDIS | RDB-VERSION
 	headbuf := make([]byte, 9)
 	_, err = io.ReadFull(r.rd, headbuf)
@@ -78,20 +223,17 @@ func (r *RDB) Parse() (err error) {
 	}
 
 	log.Infof("Start parse rdb file")
+	r.handler.StartRDB(r.version)
 
+	r.resetKeyState()
 	for {
-		// 初始化
-		r.expiry = 0
-		r.idle = 0
-		r.freq = 0
-
+		offset := r.rd.offset
 		/* Read type. */
 		// 首先读出类型
 		dtype, err := r.rd.ReadByte()
 		if err != nil {
 			return err
 		}
-		fmt.Println(string(dtype), dtype)
 		/* Handle special types. */
 		if dtype == RDBOpcodeExpireTime {
 			/* EXPIRETIME: load an expire associated with the next key to load */
@@ -100,8 +242,7 @@ func (r *RDB) Parse() (err error) {
 			if err != nil {
 				return err
 			}
-			r.expiry = uint64(expireSecond * 1000)
-			fmt.Println("过期时间为：", r.expiry)
+			r.expiry = uint64(expireSecond) * 1000 // 先转换类型再相乘，避免 uint32 溢出
 			continue
 
 		} else if dtype == RDBOpcodeExpireTimeMS { // 新版本 rdb 都使用 RDB_OPCODE_EXPIRETIME_MS
@@ -110,7 +251,6 @@ func (r *RDB) Parse() (err error) {
 			if err != nil {
 				return err
 			}
-			fmt.Println("过期时间为：", r.expiry)
 			continue
 		} else if dtype == RDBOpcodeFreq {
 			/* FREQ: LFU frequency. */
@@ -118,9 +258,7 @@ func (r *RDB) Parse() (err error) {
 			if err != nil {
 				return err
 			}
-
-			fmt.Println("LFU frequency:", freq)
-
+			r.freq = int(freq)
 			continue
 		} else if dtype == RDBOpcodeIdle {
 			/* IDLE: LRU idle time. */
@@ -128,31 +266,36 @@ func (r *RDB) Parse() (err error) {
 			if err != nil {
 				return err
 			}
-			fmt.Println("LRU idle time:", idle)
+			r.idle = int64(idle)
 			continue
 		} else if dtype == RDBOpcodeEOF {
 			/* EOF: End of file, exit the main loop. */
 			log.Infof("finish rdb reading of upstream")
-			//  8字节的 CRC64 表示的文件校验和
-			// TODO: Verify the checksum if RDB version is >= 5
+			//  8字节的 CRC64 表示的文件校验和，RDB 5 开始才有
 			if r.version >= 5 {
-				_, err := io.ReadFull(r.rd, headbuf[:8])
-				if err != nil {
-					return nil
+				err = r.verifyChecksum()
+				if _, ok := err.(*ChecksumError); ok && r.Tolerant {
+					r.record(nil, dtype, r.rd.offset, err)
+				} else if err != nil {
+					return err
 				}
-
-				fmt.Println("rdb_checksum", headbuf)
 			}
+			if r.db >= 0 {
+				r.handler.EndDatabase(r.db)
+			}
+			r.handler.EndRDB()
 			return nil
 		} else if dtype == RDBOpcodeSelectDB {
 			/* SELECTDB: Select the specified database. */
 			dbnum, err := r.readLength()
-			fmt.Println(dbnum)
-
 			if err != nil {
 				return err
 			}
-
+			if r.db >= 0 {
+				r.handler.EndDatabase(r.db)
+			}
+			r.db = int(dbnum)
+			r.handler.StartDatabase(r.db)
 			continue
 		} else if dtype == RDBOpcodeResizeDB {
 			/* RESIZEDB: Hint about the size of the keys in the currently selected data base */
@@ -164,8 +307,7 @@ func (r *RDB) Parse() (err error) {
 			if err != nil {
 				return err
 			}
-
-			fmt.Println("dbSize, expireSize", dbSize, expireSize)
+			r.handler.ResizeDB(dbSize, expireSize)
 			continue
 		} else if dtype == RDBOpcodeAux {
 			var (
@@ -180,63 +322,141 @@ func (r *RDB) Parse() (err error) {
 			if err != nil {
 				return err
 			}
-			fmt.Println("auxKey, auxVal", auxKey, auxVal)
+			r.handler.Aux(auxKey, auxVal)
 			continue
 		} else if dtype == RDBOpcodeModuleAux {
-			_, err := r.readLength()
+			/* MODULE_AUX: module id, when opcode and when, then the fields saved by aux_save */
+			id, err := r.readLength()
 			if err != nil {
 				return err
 			}
-			var opcode uint64
-			opcode, err = r.readLength()
-			for opcode != RDBModuleOpcodeEOF {
-				switch opcode {
-				case RDBModuleOpcodeSInt, RDBModuleOpcodeUInt:
-					_, err = r.readLength()
-					if err != nil {
-						return err
-					}
-				case RDBModuleOpcodeFloat:
-					_, err = r.readBinaryFloat()
-					if err != nil {
-						return err
-					}
-				case RDBModuleOpcodeDouble:
-					_, err = r.readBinaryDouble()
-					if err != nil {
-						return err
-					}
-				case RDBModuleOpcodeString:
-					_, err = r.readString()
-					if err != nil {
-						return err
-					}
-				default:
-					err = fmt.Errorf("unknown module opcode %d", opcode)
-					return err
-				}
-
-				opcode, err = r.readLength()
-				if err != nil {
+			if _, err = r.readModuleFields(); err != nil {
+				return err
+			}
+			name, encver := DecodeModuleID(id)
+			log.Debugf("skip aux data of module %s (encver %d)", name, encver)
+			continue
+		} else if dtype == RDBOpcodeFunction2 {
+			/* FUNCTION2: function library, payload is the library source code */
+			code, err := r.readString()
+			if err != nil {
+				return err
+			}
+			r.handler.Function(code)
+			continue
+		} else if dtype == RDBOpcodeFunctionPreGA {
+			/* FUNCTION_PRE_GA: name, engine, optional description and code */
+			err = r.readFunctionPreGA()
+			if err != nil {
+				return err
+			}
+			continue
+		} else if dtype == RDBOpcodeSlotInfo {
+			/* SLOT_INFO: slot id, slot size and expires slot size */
+			for i := 0; i < 3; i++ {
+				if _, err = r.readLength(); err != nil {
 					return err
 				}
 			}
-
 			continue
 		}
 		/* Read key */
 		key, err := r.readString()
+		if err == errLZFCorrupt && r.Tolerant {
+			// key 损坏时仍然要读出 value 才能继续，value 不交给 handler
+			r.record(nil, dtype, offset, err)
+			handler := r.handler
+			r.handler = NopHandler{}
+			err = r.readObject(nil, dtype, r.keyInfo(dtype, offset))
+			r.handler = handler
+			if err != nil && !r.skip(nil, dtype, offset, err) {
+				return err
+			}
+			r.resetKeyState()
+			continue
+		}
 		if err != nil {
 			return err
 		}
-		fmt.Println(key)
 		/* Read value */
-		err = r.readObject(dtype)
-		if err != nil {
+		if r.emit != nil {
+			err = r.readRaw(key, dtype, r.keyInfo(dtype, offset))
+		} else {
+			err = r.readObject(key, dtype, r.keyInfo(dtype, offset))
+		}
+		if err != nil && !r.skip(key, dtype, offset, err) {
 			return err
 		}
+		// 过期时间、LRU/LFU 信息只对紧跟其后的一个 key 有效
+		r.resetKeyState()
+	}
+}
+
+func (r *RDB) resetKeyState() {
+	r.expiry = 0
+	r.idle = -1
+	r.freq = -1
+}
+
+// verifyChecksum 读取文件末尾的校验和并与已读内容的 CRC64 比较，校验和为 0 表示写入时关闭了校验
+func (r *RDB) verifyChecksum() error {
+	actual := r.rd.crc
+	var expected uint64
+	err := binary.Read(r.rd, binary.LittleEndian, &expected)
+	if err != nil {
+		return err
+	}
+	if expected == 0 {
+		log.Infof("rdb checksum is disabled, skip verification")
+		return nil
+	}
+	if expected != actual {
+		return &ChecksumError{Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// keyInfo 根据当前读到的过期时间、LRU/LFU 信息生成 key 的元信息
+func (r *RDB) keyInfo(dtype byte, offset int64) *KeyInfo {
+	return &KeyInfo{
+		Offset:   offset,
+		DB:       r.db,
+		Expiry:   int64(r.expiry),
+		Idle:     r.idle,
+		Freq:     r.freq,
+		Type:     typeName(dtype),
+		Encoding: encodingName(dtype),
 	}
 }
+
+// readFunctionPreGA 读取 Redis 7.0 rc 版本写入的 function 库
+func (r *RDB) readFunctionPreGA() error {
+	name, err := r.readString()
+	if err != nil {
+		return err
+	}
+	engine, err := r.readString()
+	if err != nil {
+		return err
+	}
+	hasDesc, err := r.readLength()
+	if err != nil {
+		return err
+	}
+	if hasDesc != 0 {
+		if _, err = r.readString(); err != nil {
+			return err
+		}
+	}
+	code, err := r.readString()
+	if err != nil {
+		return err
+	}
+	log.Debugf("function library %s with engine %s", name, engine)
+	r.handler.Function(code)
+	return nil
+}
+
 func (r *RDB) verifyHeader(hd []byte) error {
 	if !bytes.HasPrefix(hd, []byte("REDIS")) {
 		return fmt.Errorf("bad ERSP HEADER %s", strconv.Quote(string(hd)))
@@ -252,6 +472,15 @@ func (r *RDB) verifyHeader(hd []byte) error {
 }
 
 func (r *RDB) readLength() (length uint64, err error) {
+	length, encoded, err := r.readLengthWithEncoding()
+	if err == nil && encoded {
+		err = errors.New("length is encoding value, may not error")
+	}
+	return
+}
+
+// readLengthWithEncoding 读取一个长度字段，encoded 为 true 时 length 表示的是字符串的特殊编码类型
+func (r *RDB) readLengthWithEncoding() (length uint64, encoded bool, err error) {
 	var (
 		data byte
 	)
@@ -263,7 +492,7 @@ func (r *RDB) readLength() (length uint64, err error) {
 	flag := (data & 0xc0) >> 6
 	if flag == RDBEncVal {
 		length = uint64(data & 0x3f)
-		err = errors.New("length is encoding value, may not error")
+		encoded = true
 		return
 	}
 
@@ -285,23 +514,429 @@ func (r *RDB) readLength() (length uint64, err error) {
 		length = uint64(l)
 	} else if data == RDB64BitLen {
 		err = binary.Read(r.rd, binary.BigEndian, &length)
+	} else {
+		err = fmt.Errorf("unknown length encoding %#x", data)
 	}
 
 	return
 }
 
+// readString 读取一个字符串对象，支持普通字符串、整数编码和 LZF 压缩三种格式
 func (r *RDB) readString() (data []byte, err error) {
-	return []byte(""), nil
+	length, encoded, err := r.readLengthWithEncoding()
+	if err != nil {
+		return nil, err
+	}
+
+	if encoded {
+		switch length {
+		case RDBEncInt8:
+			var i int8
+			err = binary.Read(r.rd, binary.LittleEndian, &i)
+			return []byte(strconv.FormatInt(int64(i), 10)), err
+		case RDBEncInt16:
+			var i int16
+			err = binary.Read(r.rd, binary.LittleEndian, &i)
+			return []byte(strconv.FormatInt(int64(i), 10)), err
+		case RDBEncInt32:
+			var i int32
+			err = binary.Read(r.rd, binary.LittleEndian, &i)
+			return []byte(strconv.FormatInt(int64(i), 10)), err
+		case RDBEncLZF:
+			return r.readLZFString()
+		default:
+			return nil, fmt.Errorf("unknown string encoding type %d", length)
+		}
+	}
+
+	return r.readBytes(length)
+}
+
+// maxPrealloc 是按长度字段一次分配的最大字节数，更长的数据边读边分配，
+// 损坏的长度字段只会读到文件末尾，不会一次申请巨大的内存
+const maxPrealloc = 1 << 20
+
+// readBytes 读取 n 个字节
+func (r *RDB) readBytes(n uint64) ([]byte, error) {
+	if n <= maxPrealloc {
+		data := make([]byte, n)
+		_, err := io.ReadFull(r.rd, data)
+		return data, err
+	}
+	if n > math.MaxInt64 {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	var buf bytes.Buffer
+	buf.Grow(maxPrealloc)
+	if _, err := io.CopyN(&buf, r.rd, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
-func (r *RDB) readBinaryFloat() (interface{}, error) {
-	return nil, nil
+// readLZFString 读取 LZF 压缩的字符串： | 压缩后长度 | 原始长度 | 压缩数据 |
+func (r *RDB) readLZFString() ([]byte, error) {
+	clen, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	ulen, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := r.readBytes(clen)
+	if err != nil || r.raw {
+		return nil, err
+	}
+	if ulen > uint64(len(compressed))*lzfMaxRatio {
+		return nil, errLZFCorrupt
+	}
+	return lzfDecompress(compressed, int(ulen))
 }
 
-func (r *RDB) readBinaryDouble() (interface{}, error) {
-	return nil, nil
+// readBinaryFloat 读取 4 字节小端序的 IEEE 754 float，用于 module 的 RDBModuleOpcodeFloat
+func (r *RDB) readBinaryFloat() (float32, error) {
+	var f float32
+	err := binary.Read(r.rd, binary.LittleEndian, &f)
+	return f, err
+}
+
+// readBinaryDouble 读取 8 字节小端序的 IEEE 754 double，用于 RDBTypeZSet2
+func (r *RDB) readBinaryDouble() (float64, error) {
+	var f float64
+	err := binary.Read(r.rd, binary.LittleEndian, &f)
+	return f, err
+}
+
+// readDouble 读取旧版本以字符串形式保存的 double，第一个字节为长度，
+// 253/254/255 分别表示 nan/+inf/-inf
+func (r *RDB) readDouble() (float64, error) {
+	length, err := r.rd.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(r.rd, buf)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(string(buf), 64)
+	return f, corrupt(err)
 }
 
-func (r *RDB) readObject(b byte) error {
+// readObject 根据类型读取 key 对应的 value。
+// 无法解压或解析的元素被跳过，读完整个 value 后返回 *valueError；
+// 长度字段或者编码类型不正确时无法知道 value 的结尾，直接返回错误
+func (r *RDB) readObject(key []byte, dtype byte, info *KeyInfo) error {
+	var bad error // 第一个被跳过的元素的错误
+	skipMember := func(err error) {
+		if bad == nil {
+			bad = err
+		}
+	}
+	switch dtype {
+	case RDBTypeString:
+		value, err := r.readString()
+		if err == errLZFCorrupt {
+			return corrupt(err)
+		}
+		if err != nil {
+			return err
+		}
+		r.handler.Set(key, value, info)
+	case RDBTypeList, RDBTypeSet:
+		length, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		if dtype == RDBTypeList {
+			r.handler.StartList(key, int64(length), info)
+		} else {
+			r.handler.StartSet(key, int64(length), info)
+		}
+		for i := uint64(0); i < length; i++ {
+			member, err := r.readString()
+			if memberError(err) {
+				skipMember(err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if dtype == RDBTypeList {
+				r.handler.Rpush(key, member)
+			} else {
+				r.handler.Sadd(key, member)
+			}
+		}
+		if dtype == RDBTypeList {
+			r.handler.EndList(key, info)
+		} else {
+			r.handler.EndSet(key, info)
+		}
+	case RDBTypeZSet, RDBTypeZSet2:
+		length, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		r.handler.StartZSet(key, int64(length), info)
+		for i := uint64(0); i < length; i++ {
+			member, err := r.readString()
+			if err != nil && !memberError(err) {
+				return err
+			}
+			var (
+				score    float64
+				scoreErr error
+			)
+			if dtype == RDBTypeZSet2 {
+				score, scoreErr = r.readBinaryDouble()
+			} else {
+				score, scoreErr = r.readDouble()
+			}
+			if scoreErr != nil && !memberError(scoreErr) {
+				return scoreErr
+			}
+			if err == nil {
+				err = scoreErr
+			}
+			if err != nil {
+				skipMember(err)
+				continue
+			}
+			r.handler.Zadd(key, score, member)
+		}
+		r.handler.EndZSet(key, info)
+	case RDBTypeHash:
+		length, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		r.handler.StartHash(key, int64(length), info)
+		for i := uint64(0); i < length; i++ {
+			field, err := r.readString()
+			if err != nil && !memberError(err) {
+				return err
+			}
+			value, valueErr := r.readString()
+			if valueErr != nil && !memberError(valueErr) {
+				return valueErr
+			}
+			if err == nil {
+				err = valueErr
+			}
+			if err != nil {
+				skipMember(err)
+				continue
+			}
+			r.handler.Hset(key, field, value)
+		}
+		r.handler.EndHash(key, info)
+	case RDBTypeListQuicklist, RDBTypeListQuicklist2:
+		length, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		info.Nodes = length
+		r.handler.StartList(key, -1, info)
+		// quicklist 的每个节点都是一个 ziplist，quicklist2 的节点可能是 listpack 或单个元素
+		for i := uint64(0); i < length; i++ {
+			container := uint64(QuicklistNodeContainerPacked)
+			if dtype == RDBTypeListQuicklist2 {
+				container, err = r.readLength()
+				if err != nil {
+					return err
+				}
+			}
+			switch container {
+			case QuicklistNodeContainerPlain:
+				entry, err := r.readString()
+				if memberError(err) {
+					skipMember(err)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				info.SizeOfValue += uint64(len(entry))
+				r.handler.Rpush(key, entry)
+			case QuicklistNodeContainerPacked:
+				offset := r.rd.offset
+				entries, err := r.readPacked(dtype, info)
+				if r.skip(key, dtype, offset, err) {
+					continue // 跳过损坏的节点，继续读取下一个节点
+				}
+				if err != nil {
+					return err
+				}
+				for _, entry := range entries {
+					r.handler.Rpush(key, entry)
+				}
+			default:
+				// 不认识的 container 无法知道节点的长度，即使在容错模式下也只能停止解析
+				return fmt.Errorf("unknown quicklist node container %d for key %s", container, strconv.Quote(string(key)))
+			}
+		}
+		r.handler.EndList(key, info)
+	case RDBTypeHashZipmap:
+		blob, err := r.readString()
+		if err == errLZFCorrupt {
+			return corrupt(err)
+		}
+		if err != nil {
+			return err
+		}
+		info.SizeOfValue = uint64(len(blob))
+		if r.raw {
+			return nil
+		}
+		entries, err := decodeZipmap(blob)
+		if err != nil {
+			return corrupt(err)
+		}
+		r.handler.StartHash(key, int64(len(entries)/2), info)
+		for i := 0; i < len(entries); i += 2 {
+			r.handler.Hset(key, entries[i], entries[i+1])
+		}
+		r.handler.EndHash(key, info)
+	case RDBTypeSetIntset:
+		blob, err := r.readString()
+		if err == errLZFCorrupt {
+			return corrupt(err)
+		}
+		if err != nil {
+			return err
+		}
+		info.SizeOfValue = uint64(len(blob))
+		if r.raw {
+			return nil
+		}
+		members, err := decodeIntset(blob)
+		if err != nil {
+			return corrupt(err)
+		}
+		r.handler.StartSet(key, int64(len(members)), info)
+		for _, member := range members {
+			r.handler.Sadd(key, member)
+		}
+		r.handler.EndSet(key, info)
+	case RDBTypeStreamListpacks, RDBTypeStreamListpacks2, RDBTypeStreamListpacks3:
+		return r.readStream(key, dtype, info)
+	case RDBTypeModule, RDBTypeModule2:
+		return r.readModule(key, dtype, info)
+	case RDBTypeListZiplist, RDBTypeZSetZiplist, RDBTypeHashZiplist,
+		RDBTypeHashListpack, RDBTypeZSetListpack, RDBTypeSetListpack:
+		return r.readPackedObject(key, dtype, info)
+	default:
+		return fmt.Errorf("unknown object type %d for key %s", dtype, strconv.Quote(string(key)))
+	}
+	return corrupt(bad)
+}
+
+// readPacked 读取一个 ziplist 或 listpack 并解码出其中的所有元素，
+// 数据已经完整读出但无法解码时返回 *valueError
+func (r *RDB) readPacked(dtype byte, info *KeyInfo) ([][]byte, error) {
+	blob, err := r.readString()
+	if err == errLZFCorrupt {
+		return nil, corrupt(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.SizeOfValue += uint64(len(blob))
+	if r.raw {
+		return nil, nil
+	}
+
+	var entries [][]byte
+	switch dtype {
+	case RDBTypeHashListpack, RDBTypeZSetListpack, RDBTypeSetListpack, RDBTypeListQuicklist2:
+		entries, err = decodeListpack(blob)
+	default:
+		entries, err = decodeZiplist(blob)
+	}
+	return entries, corrupt(err)
+}
+
+// readPackedObject 读取一个以 ziplist 或 listpack 编码的 list/set/zset/hash
+func (r *RDB) readPackedObject(key []byte, dtype byte, info *KeyInfo) error {
+	entries, err := r.readPacked(dtype, info)
+	if err != nil {
+		return err
+	}
+
+	switch dtype {
+	case RDBTypeListZiplist:
+		r.handler.StartList(key, int64(len(entries)), info)
+		for _, entry := range entries {
+			r.handler.Rpush(key, entry)
+		}
+		r.handler.EndList(key, info)
+	case RDBTypeSetListpack:
+		r.handler.StartSet(key, int64(len(entries)), info)
+		for _, entry := range entries {
+			r.handler.Sadd(key, entry)
+		}
+		r.handler.EndSet(key, info)
+	case RDBTypeZSetZiplist, RDBTypeZSetListpack:
+		// member 和 score 交替存放
+		if len(entries)%2 != 0 {
+			return corrupt(fmt.Errorf("packed zset of key %s has odd number of entries", strconv.Quote(string(key))))
+		}
+		// 先解析所有 score，避免出错时 handler 收到不完整的 zset
+		scores := make([]float64, len(entries)/2)
+		for i := range scores {
+			if scores[i], err = strconv.ParseFloat(string(entries[2*i+1]), 64); err != nil {
+				return corrupt(err)
+			}
+		}
+		r.handler.StartZSet(key, int64(len(scores)), info)
+		for i, score := range scores {
+			r.handler.Zadd(key, score, entries[2*i])
+		}
+		r.handler.EndZSet(key, info)
+	case RDBTypeHashZiplist, RDBTypeHashListpack:
+		// field 和 value 交替存放
+		if len(entries)%2 != 0 {
+			return corrupt(fmt.Errorf("packed hash of key %s has odd number of entries", strconv.Quote(string(key))))
+		}
+		r.handler.StartHash(key, int64(len(entries)/2), info)
+		for i := 0; i < len(entries); i += 2 {
+			r.handler.Hset(key, entries[i], entries[i+1])
+		}
+		r.handler.EndHash(key, info)
+	}
 	return nil
 }
+
+// typeName 返回 RDB 类型对应的 Redis 数据类型名
+func typeName(dtype byte) string {
+	switch dtype {
+	case RDBTypeString:
+		return "string"
+	case RDBTypeList, RDBTypeListZiplist, RDBTypeListQuicklist, RDBTypeListQuicklist2:
+		return "list"
+	case RDBTypeSet, RDBTypeSetIntset, RDBTypeSetListpack:
+		return "set"
+	case RDBTypeZSet, RDBTypeZSet2, RDBTypeZSetZiplist, RDBTypeZSetListpack:
+		return "zset"
+	case RDBTypeHash, RDBTypeHashZipmap, RDBTypeHashZiplist, RDBTypeHashListpack:
+		return "hash"
+	case RDBTypeStreamListpacks, RDBTypeStreamListpacks2, RDBTypeStreamListpacks3:
+		return "stream"
+	case RDBTypeModule, RDBTypeModule2:
+		return "module"
+	}
+	return "unknown"
+}
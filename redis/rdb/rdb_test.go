@@ -2,20 +2,624 @@ package rdb
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
 	"path"
 	"testing"
 )
 
+// recordHandler 记录解析出来的每个 key 和元素，用于校验
+type recordHandler struct {
+	NopHandler
+	keys    []string
+	infos   []KeyInfo
+	values  []string
+	aux     map[string]string
+	streams []*StreamMeta
+	dbs     []int
+}
+
+func (h *recordHandler) key(key []byte, info *KeyInfo) {
+	h.keys = append(h.keys, string(key))
+	h.infos = append(h.infos, *info)
+}
+
+func (h *recordHandler) StartDatabase(db int) { h.dbs = append(h.dbs, db) }
+func (h *recordHandler) Aux(key, value []byte) {
+	if h.aux == nil {
+		h.aux = make(map[string]string)
+	}
+	h.aux[string(key)] = string(value)
+}
+func (h *recordHandler) Set(key, value []byte, info *KeyInfo) {
+	h.key(key, info)
+	h.values = append(h.values, string(value))
+}
+func (h *recordHandler) StartHash(key []byte, length int64, info *KeyInfo) { h.key(key, info) }
+func (h *recordHandler) Hset(key, field, value []byte) {
+	h.values = append(h.values, string(field)+"="+string(value))
+}
+func (h *recordHandler) StartList(key []byte, length int64, info *KeyInfo) { h.key(key, info) }
+func (h *recordHandler) Rpush(key, value []byte)                           { h.values = append(h.values, string(value)) }
+func (h *recordHandler) StartSet(key []byte, length int64, info *KeyInfo)  { h.key(key, info) }
+func (h *recordHandler) Sadd(key, member []byte)                           { h.values = append(h.values, string(member)) }
+func (h *recordHandler) StartZSet(key []byte, length int64, info *KeyInfo) { h.key(key, info) }
+func (h *recordHandler) Zadd(key []byte, score float64, member []byte) {
+	h.values = append(h.values, fmt.Sprintf("%s:%v", member, score))
+}
+func (h *recordHandler) StartStream(key []byte, info *KeyInfo) { h.key(key, info) }
+func (h *recordHandler) StreamEntry(key []byte, entry *StreamEntry) {
+	h.values = append(h.values, fmt.Sprintf("%v%s", entry.ID, entry.Fields))
+}
+func (h *recordHandler) EndStream(key []byte, meta *StreamMeta, info *KeyInfo) {
+	h.streams = append(h.streams, meta)
+}
+
 func TestRDB(t *testing.T) {
 	baseDir, _ := os.Getwd()
-	rdbFile := path.Join(baseDir, "dumps", "dump-lfu.rdb")
-	fmt.Println(rdbFile)
-	handler, err := os.Open(rdbFile)
+	cases := []struct {
+		name string
+		keys string
+	}{
+		{"dump.rdb", "[key hello hset_key]"},
+		{"dump-lfu.rdb", "[key key1]"},
+		{"dump-lru.rdb", "[key1 key]"},
+	}
+	for _, c := range cases {
+		rdbFile := path.Join(baseDir, "dumps", c.name)
+		handler, err := os.Open(rdbFile)
+		if err != nil {
+			t.Fatalf("read rdb file err, %v", err)
+		}
+		h := &recordHandler{}
+		r := NewRDB(bufio.NewReader(handler), h)
+		err = r.Parse()
+		handler.Close()
+		if err != nil {
+			t.Fatalf("parse %s: %v", c.name, err)
+		}
+		if fmt.Sprint(h.keys) != c.keys {
+			t.Fatalf("%s: keys = %v, want %s", c.name, h.keys, c.keys)
+		}
+		if h.aux["redis-bits"] != "64" {
+			t.Fatalf("%s: unexpected aux fields %v", c.name, h.aux)
+		}
+	}
+}
+
+func TestRDBKeyInfo(t *testing.T) {
+	handler, err := os.Open(path.Join("dumps", "dump.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	h := &recordHandler{}
+	if err = NewRDB(bufio.NewReader(handler), h).Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(h.values) != "[123 world field=value]" {
+		t.Fatalf("unexpected values %v", h.values)
+	}
+	if h.infos[0].Expiry != 1574093693054 || h.infos[1].Expiry != 0 {
+		t.Fatalf("unexpected expiry %d %d", h.infos[0].Expiry, h.infos[1].Expiry)
+	}
+	if h.infos[2].Type != "hash" || h.infos[2].Encoding != EncodingZiplist || h.infos[2].SizeOfValue != 25 {
+		t.Fatalf("unexpected info for hset_key %+v", h.infos[2])
+	}
+}
+
+func TestReadString(t *testing.T) {
+	cases := []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{"plain", []byte{0x05, 'h', 'e', 'l', 'l', 'o'}, "hello"},
+		{"int8", []byte{0xc0, 0x7b}, "123"},
+		{"int8 negative", []byte{0xc0, 0xff}, "-1"},
+		{"int16", []byte{0xc1, 0x39, 0x30}, "12345"},
+		{"int32", []byte{0xc2, 0x9a, 0x76, 0x1c, 0x5b}, "1528592026"},
+		// "abcabcabcabc"：3 个字面量 + 一个长度为 9 的回溯引用
+		{"lzf", []byte{0xc3, 0x07, 0x0c, 0x02, 'a', 'b', 'c', 0xe0, 0x00, 0x02}, "abcabcabcabc"},
+	}
+	for _, c := range cases {
+		r := NewRDB(bufio.NewReader(bytes.NewReader(c.in)), nil)
+		got, err := r.readString()
+		if err != nil {
+			t.Fatalf("%s: readString error: %v", c.name, err)
+		}
+		if string(got) != c.want {
+			t.Fatalf("%s: readString = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReadDoubles(t *testing.T) {
+	nan := math.NaN()
+	reader := func(b []byte) *RDB { return NewRDB(bufio.NewReader(bytes.NewReader(b)), nil) }
+	same := func(a, b float64) bool { return a == b || math.IsNaN(a) && math.IsNaN(b) }
+
+	for _, want := range []float64{0, -1.5, 3.141592653589793, math.MaxFloat64, math.Inf(1), math.Inf(-1), nan} {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(want))
+		if got, err := reader(b).readBinaryDouble(); err != nil || !same(got, want) {
+			t.Fatalf("readBinaryDouble(%v) = %v, %v", want, got, err)
+		}
+
+		b = make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(want)))
+		if got, err := reader(b).readBinaryFloat(); err != nil || !same(float64(got), float64(float32(want))) {
+			t.Fatalf("readBinaryFloat(%v) = %v, %v", want, got, err)
+		}
+	}
+
+	cases := []struct {
+		in   []byte
+		want float64
+	}{
+		{[]byte{253}, nan},
+		{[]byte{254}, math.Inf(1)},
+		{[]byte{255}, math.Inf(-1)},
+		{append([]byte{4}, "-2.5"...), -2.5},
+		{append([]byte{22}, "1.0000000000000002e-05"...), 1.0000000000000002e-05},
+	}
+	for _, c := range cases {
+		if got, err := reader(c.in).readDouble(); err != nil || !same(got, c.want) {
+			t.Fatalf("readDouble(%q) = %v, %v, want %v", c.in, got, err, c.want)
+		}
+	}
+	if _, err := reader([]byte{4, '1'}).readDouble(); err == nil {
+		t.Fatal("expected error reading truncated double")
+	}
+}
+
+func TestLZFDecompressCorrupt(t *testing.T) {
+	// 回溯引用超出了已输出的数据
+	_, err := lzfDecompress([]byte{0xe0, 0x00, 0x05}, 9)
+	if err == nil {
+		t.Fatal("expected error for corrupt lzf data")
+	}
+}
+
+func TestDecodeZiplist(t *testing.T) {
+	// 从 dump.rdb 中 hset_key 的 ziplist 截取
+	zl := []byte{
+		0x19, 0x00, 0x00, 0x00, 0x11, 0x00, 0x00, 0x00, 0x02, 0x00,
+		0x00, 0x05, 'f', 'i', 'e', 'l', 'd',
+		0x07, 0x05, 'v', 'a', 'l', 'u', 'e',
+		0xff,
+	}
+	entries, err := decodeZiplist(zl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || string(entries[0]) != "field" || string(entries[1]) != "value" {
+		t.Fatalf("unexpected entries %q", entries)
+	}
+
+	// 整数编码：int8(-2)、int24(-1)、立即数 12
+	zl = []byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x00,
+		0x00, 0xfe, 0xfe,
+		0x03, 0xf0, 0xff, 0xff, 0xff,
+		0x05, 0xfd,
+		0xff,
+	}
+	entries, err = decodeZiplist(zl)
 	if err != nil {
-		fmt.Println("read rdb file err ,", err)
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%s", entries) != "[-2 -1 12]" {
+		t.Fatalf("unexpected entries %s", entries)
+	}
+}
+
+func TestDecodeIntset(t *testing.T) {
+	is := []byte{0x02, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0xff, 0xff, 0x10, 0x00}
+	members, err := decodeIntset(is)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%s", members) != "[-1 16]" {
+		t.Fatalf("unexpected members %s", members)
+	}
+}
+
+func TestDecodeZipmap(t *testing.T) {
+	zm := []byte{0x01, 0x03, 'f', 'o', 'o', 0x03, 0x01, 'b', 'a', 'r', 'x', 0xff}
+	entries, err := decodeZipmap(zm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%s", entries) != "[foo bar]" {
+		t.Fatalf("unexpected entries %s", entries)
+	}
+}
+
+func TestDecodeListpack(t *testing.T) {
+	lp := []byte{
+		0x00, 0x00, 0x00, 0x00, 0x05, 0x00,
+		0x81, 'a', 0x02, // 6 位长度字符串
+		0x01, 0x01, // 7 位无符号整数
+		0x81, 'b', 0x02,
+		0xde, 0xd4, 0x02, // 13 位有符号整数 -300
+		0xf1, 0xe8, 0x03, 0x03, // int16 1000
+		0xff,
+	}
+	entries, err := decodeListpack(lp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%s", entries) != "[a 1 b -300 1000]" {
+		t.Fatalf("unexpected entries %s", entries)
+	}
+}
+
+func TestParseRDB11(t *testing.T) {
+	lp := []byte{0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x81, 'x', 0x02, 0x07, 0x01, 0xff}
+	var buf bytes.Buffer
+	buf.WriteString("REDIS0011")
+	buf.Write([]byte{RDBOpcodeFunction2, 0x04, 'c', 'o', 'd', 'e'})
+	buf.Write([]byte{RDBOpcodeSelectDB, 0x00})
+	// quicklist2：一个 packed 节点和一个 plain 节点
+	buf.Write([]byte{RDBTypeListQuicklist2, 0x01, 'l', 0x02, QuicklistNodeContainerPacked, byte(len(lp))})
+	buf.Write(lp)
+	buf.Write([]byte{QuicklistNodeContainerPlain, 0x03, 'b', 'i', 'g'})
+	buf.Write([]byte{RDBTypeSetListpack, 0x01, 's', byte(len(lp))})
+	buf.Write(lp)
+	buf.Write([]byte{RDBOpcodeEOF, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	h := &recordHandler{}
+	r := NewRDB(bufio.NewReader(&buf), h)
+	if err := r.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(h.keys) != "[l s]" || fmt.Sprint(h.values) != "[x 7 big x 7]" {
+		t.Fatalf("unexpected keys %v values %v", h.keys, h.values)
+	}
+	if h.infos[0].Encoding != EncodingQuicklist || h.infos[1].Encoding != EncodingListpack {
+		t.Fatalf("unexpected encodings %+v", h.infos)
+	}
+}
+
+// testListpack 用 6 位长度字符串编码构造一个 listpack
+func testListpack(items ...string) []byte {
+	lp := []byte{0, 0, 0, 0, byte(len(items)), 0}
+	for _, item := range items {
+		lp = append(lp, 0x80|byte(len(item)))
+		lp = append(lp, item...)
+		lp = append(lp, byte(len(item)+1))
+	}
+	return append(lp, 0xff)
+}
+
+func TestDecodeStream(t *testing.T) {
+	// master id 1000-0，两条 entry：1000-0 (same fields)、1005-1 (不同 fields)，外加一条已删除的 entry
+	lp := testListpack(
+		"2", "1", "1", "name", "0", // master entry
+		"2", "0", "0", "alice", "3",
+		"3", "3", "0", "bob", "3",
+		"0", "5", "1", "2", "age", "3", "city", "sz", "7",
+	)
+	entries, err := decodeListpack(lp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := decodeStreamListpack(StreamID{Ms: 1000}, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream) != 2 {
+		t.Fatalf("expected 2 live entries, got %d", len(stream))
+	}
+	if stream[0].ID.String() != "1000-0" || fmt.Sprintf("%s", stream[0].Fields) != "[name alice]" {
+		t.Fatalf("unexpected first entry %v %s", stream[0].ID, stream[0].Fields)
+	}
+	if stream[1].ID.String() != "1005-1" || fmt.Sprintf("%s", stream[1].Fields) != "[age 3 city sz]" {
+		t.Fatalf("unexpected second entry %v %s", stream[1].ID, stream[1].Fields)
+	}
+
+	// 完整的 RDBTypeStreamListpacks2 value，带一个消费组
+	var buf bytes.Buffer
+	buf.WriteString("REDIS0010")
+	buf.Write([]byte{RDBOpcodeSelectDB, 0x00, RDBTypeStreamListpacks2, 0x01, 's'})
+	buf.Write([]byte{0x01, 0x10, 0, 0, 0, 0, 0, 0, 0x03, 0xe8, 0, 0, 0, 0, 0, 0, 0, 0})
+	buf.Write([]byte{0x40 | byte(len(lp)>>8), byte(len(lp))})
+	buf.Write(lp)
+	buf.Write([]byte{0x02, 0x43, 0xed, 0x01})                  // length 2, last id 1005-1
+	buf.Write([]byte{0x43, 0xe8, 0x00})                        // first id 1000-0
+	buf.Write([]byte{0x43, 0xe9, 0x00})                        // max deleted id 1001-0
+	buf.Write([]byte{0x03})                                    // entries added
+	buf.Write([]byte{0x01, 0x01, 'g', 0x43, 0xe8, 0x00, 0x01}) // group g, last id 1000-0, entries read 1
+	buf.Write([]byte{0x01, 0, 0, 0, 0, 0, 0, 0x03, 0xe8, 0, 0, 0, 0, 0, 0, 0, 0})
+	buf.Write([]byte{0x10, 0x27, 0, 0, 0, 0, 0, 0, 0x01}) // delivery time 10000, count 1
+	buf.Write([]byte{0x01, 0x01, 'c', 0x10, 0x27, 0, 0, 0, 0, 0, 0, 0x01})
+	buf.Write([]byte{0, 0, 0, 0, 0, 0, 0x03, 0xe8, 0, 0, 0, 0, 0, 0, 0, 0})
+	buf.Write([]byte{RDBOpcodeEOF, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	h := &recordHandler{}
+	r := NewRDB(bufio.NewReader(&buf), h)
+	if err := r.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(h.values) != "[1000-0[name alice] 1005-1[age 3 city sz]]" {
+		t.Fatalf("unexpected stream entries %v", h.values)
+	}
+	meta := h.streams[0]
+	if meta.Length != 2 || meta.LastID.String() != "1005-1" || len(meta.Groups) != 1 {
+		t.Fatalf("unexpected stream meta %+v", meta)
+	}
+	g := meta.Groups[0]
+	if string(g.Name) != "g" || len(g.Pending) != 1 || g.Pending[0].DeliveryTime != 10000 ||
+		len(g.Consumers) != 1 || string(g.Consumers[0].Name) != "c" {
+		t.Fatalf("unexpected stream group %+v", g)
+	}
+}
+
+// testStream 构造一个没有消费组的 RDBTypeStreamListpacks2 value，每个 listpack 是一个 master id 为 1000-0 的节点
+func testStream(key string, lps ...[]byte) []byte {
+	buf := []byte{RDBTypeStreamListpacks2, byte(len(key))}
+	buf = append(buf, key...)
+	buf = append(buf, byte(len(lps)))
+	for _, lp := range lps {
+		buf = append(buf, 0x10, 0, 0, 0, 0, 0, 0, 0x03, 0xe8, 0, 0, 0, 0, 0, 0, 0, 0)
+		buf = append(buf, 0x40|byte(len(lp)>>8), byte(len(lp)))
+		buf = append(buf, lp...)
+	}
+	buf = append(buf, 0x01, 0x43, 0xe8, 0x00) // length 1, last id 1000-0
+	buf = append(buf, 0x43, 0xe8, 0x00)       // first id 1000-0
+	buf = append(buf, 0x00, 0x00)             // max deleted id 0-0
+	return append(buf, 0x01, 0x00)            // entries added 1, 没有消费组
+}
+
+func TestTolerantStream(t *testing.T) {
+	good := testListpack("1", "0", "1", "f", "0", "2", "0", "0", "v", "3")
+	for name, bad := range map[string][]byte{
+		"huge count":      testListpack("999999999999", "0", "1", "f", "0"),
+		"negative fields": testListpack("1", "0", "-5", "f", "0"),
+		"huge fields":     testListpack("1", "0", "1", "f", "0", "0", "0", "0", "999999999", "3"),
+		"truncated":       testListpack("1", "0", "1", "f", "0", "2", "0"),
+		"not a listpack":  []byte("garbage"),
+	} {
+		var buf bytes.Buffer
+		buf.WriteString("REDIS0010")
+		buf.Write([]byte{RDBOpcodeSelectDB, 0x00})
+		buf.Write(testStream("s", bad, good))
+		buf.Write([]byte{RDBTypeString, 0x01, 'b', 0x01, '2'})
+		buf.Write([]byte{RDBOpcodeEOF, 0, 0, 0, 0, 0, 0, 0, 0})
+		data := buf.Bytes()
+
+		if err := NewRDB(bufio.NewReader(bytes.NewReader(data)), nil).Parse(); err == nil {
+			t.Fatalf("%s: expected strict mode to fail", name)
+		}
+		// 损坏的节点被跳过，同一个 stream 的其它节点和之后的 key 仍然可以解析
+		h := &recordHandler{}
+		r := NewRDB(bufio.NewReader(bytes.NewReader(data)), h)
+		r.Tolerant = true
+		if err := r.Parse(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fmt.Sprint(h.keys) != "[s b]" || fmt.Sprint(h.values) != "[1000-0[f v] 2]" || len(r.Errors()) != 1 {
+			t.Fatalf("%s: unexpected keys %v values %v errors %v", name, h.keys, h.values, r.Errors())
+		}
+		if e := r.Errors()[0]; string(e.Key) != "s" || e.Opcode != RDBTypeStreamListpacks2 {
+			t.Fatalf("%s: unexpected error %+v", name, e)
+		}
+	}
+}
+
+func TestStreamLag(t *testing.T) {
+	id := func(ms uint64) StreamID { return StreamID{Ms: ms} }
+	cases := []struct {
+		name string
+		meta StreamMeta
+		g    StreamGroup
+		lag  uint64
+		ok   bool
+	}{
+		// RDB 9 没有 entries_added 和 entries_read，无法计算
+		{"v9", StreamMeta{LastID: id(5)}, StreamGroup{LastID: id(3), EntriesRead: -1}, 0, false},
+		{"v9 consumed", StreamMeta{LastID: id(5)}, StreamGroup{LastID: id(5), EntriesRead: -1}, 0, true},
+		{"v10", StreamMeta{LastID: id(5), EntriesAdded: 5}, StreamGroup{LastID: id(3), EntriesRead: 3}, 2, true},
+		{"v10 consumed", StreamMeta{LastID: id(5), EntriesAdded: 5}, StreamGroup{LastID: id(5), EntriesRead: 5}, 0, true},
+		{"v10 empty", StreamMeta{}, StreamGroup{EntriesRead: 0}, 0, true},
+		// 消费组读取的位置之后有删除的消息
+		{"v10 deleted", StreamMeta{LastID: id(5), MaxDeletedID: id(4), EntriesAdded: 5}, StreamGroup{LastID: id(3), EntriesRead: 3}, 0, false},
+	}
+	for _, c := range cases {
+		if lag, ok := c.meta.Lag(&c.g); lag != c.lag || ok != c.ok {
+			t.Errorf("%s: got %d %v, want %d %v", c.name, lag, ok, c.lag, c.ok)
+		}
+	}
+}
+
+func TestCRC64(t *testing.T) {
+	if crc := crc64Update(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("crc64 = %#x", crc)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	data, err := ioutil.ReadFile(path.Join("dumps", "dump.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 修改 "world" 中的一个字节
+	corrupt := append([]byte(nil), data...)
+	corrupt[bytes.Index(corrupt, []byte("world"))] = 'W'
+	err = NewRDB(bufio.NewReader(bytes.NewReader(corrupt)), nil).Parse()
+	if _, ok := err.(*ChecksumError); !ok {
+		t.Fatalf("expected *ChecksumError, got %v", err)
+	}
+
+	// 截断的文件
+	err = NewRDB(bufio.NewReader(bytes.NewReader(data[:len(data)-20])), nil).Parse()
+	if err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+
+	// 校验和为 0 表示关闭了校验
+	disabled := append([]byte(nil), corrupt[:len(corrupt)-8]...)
+	disabled = append(disabled, 0, 0, 0, 0, 0, 0, 0, 0)
+	if err = NewRDB(bufio.NewReader(bytes.NewReader(disabled)), nil).Parse(); err != nil {
+		t.Fatalf("expected checksum to be skipped, got %v", err)
+	}
+}
+
+func TestExpireTimeSeconds(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("REDIS0004")
+	buf.Write([]byte{RDBOpcodeSelectDB, 0x00})
+	// 2000000000 秒，乘以 1000 之后超出 uint32
+	buf.Write([]byte{RDBOpcodeExpireTime, 0x00, 0x94, 0x35, 0x77})
+	buf.Write([]byte{RDBTypeString, 0x01, 'k', 0x01, 'v'})
+	buf.Write([]byte{RDBTypeString, 0x01, 'n', 0x01, 'v'})
+	buf.WriteByte(RDBOpcodeEOF)
+
+	h := &recordHandler{}
+	if err := NewRDB(bufio.NewReader(&buf), h).Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if h.infos[0].Expiry != 2000000000000 || h.infos[1].Expiry != 0 {
+		t.Fatalf("unexpected expiry %d %d", h.infos[0].Expiry, h.infos[1].Expiry)
+	}
+}
+
+func TestTolerant(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 7)
+	w.WriteHeader()
+	w.SelectDB(2)
+	w.WriteString([]byte("a"), []byte("1"), &KeyInfo{Idle: -1, Freq: -1})
+	w.WriteHash([]byte("h"), bytesList("f"), bytesList("v"), &KeyInfo{Encoding: EncodingZiplist, Idle: -1, Freq: -1})
+	w.WriteString([]byte("b"), []byte("2"), &KeyInfo{Idle: -1, Freq: -1})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// 把 ziplist 的结束标记改掉，value 的长度不变，之后的 key 仍然可以解析
+	corrupt := append([]byte(nil), data...)
+	hashOffset := int64(bytes.Index(corrupt, []byte{RDBTypeHashZiplist, 0x01, 'h'}))
+	end := bytes.Index(corrupt, []byte{RDBTypeString, 0x01, 'b'}) - 1
+	corrupt[end] = 0x05
+	if err := NewRDB(bufio.NewReader(bytes.NewReader(corrupt)), nil).Parse(); err == nil {
+		t.Fatal("expected strict mode to fail")
+	}
+
+	h := &valuesHandler{}
+	r := NewRDB(bufio.NewReader(bytes.NewReader(corrupt)), h)
+	r.Tolerant = true
+	if err := r.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(h.keys) != "[a b]" {
+		t.Fatalf("unexpected keys %v", h.keys)
+	}
+	errs := r.Errors()
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if e := errs[0]; e.Offset != hashOffset || e.DB != 2 || string(e.Key) != "h" || e.Opcode != RDBTypeHashZiplist {
+		t.Fatalf("unexpected error %+v", e)
+	}
+	if _, ok := errs[1].Err.(*ChecksumError); !ok {
+		t.Fatalf("expected checksum error, got %v", errs[1])
+	}
+
+	// 截断的文件返回已经解析出来的 key
+	h = &valuesHandler{}
+	r = NewRDB(bufio.NewReader(bytes.NewReader(data[:end+2])), h)
+	r.Tolerant = true
+	if err := r.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	errs = r.Errors()
+	if fmt.Sprint(h.keys) != "[a h]" || len(errs) != 1 || errs[0].Err != ErrTruncated || errs[0].Offset != int64(end+2) {
+		t.Fatalf("unexpected result %v %v", h.keys, errs)
+	}
+}
+
+// corruptCorpus 返回用于损坏测试的 RDB 文件：dumps 下的文件和覆盖各种编码、stream、module 的文件
+func corruptCorpus(t *testing.T) [][]byte {
+	var corpus [][]byte
+	for _, name := range []string{"dump.rdb", "dump-lru.rdb", "dump-lfu.rdb"} {
+		data, err := ioutil.ReadFile(path.Join("dumps", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		corpus = append(corpus, data)
+	}
+	corpus = append(corpus, writeMixedDump(t, 3))
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 7)
+	w.Compress = true
+	w.WriteHeader()
+	w.SelectDB(0)
+	info := func(encoding string) *KeyInfo { return &KeyInfo{Encoding: encoding, Idle: -1, Freq: -1} }
+	items := bytesList("1", "2", "300", "-70000", "member-member-member-member-member")
+	scores := []float64{1, 2.5, -3, math.Inf(1), 0}
+	w.WriteList([]byte("list"), items, info(""))
+	w.WriteList([]byte("ziplist"), items, info(EncodingZiplist))
+	w.WriteSet([]byte("set"), items, info(""))
+	w.WriteSet([]byte("intset"), bytesList("1", "-2", "70000"), info(EncodingIntset))
+	w.WriteZSet([]byte("zset"), items, scores, info(""))
+	w.WriteZSet([]byte("zziplist"), items, scores, info(EncodingZiplist))
+	w.WriteHash([]byte("hash"), items, items, info(""))
+	w.WriteHash([]byte("zipmap"), items, items, info(EncodingZipmap))
+	w.WriteModule([]byte("doc"), &ModuleValue{Module: ReJSONModule, EncVer: 0, Fields: []interface{}{
+		uint64(rejsonArray), uint64(2), uint64(rejsonString), []byte("x"), uint64(rejsonNumber), 1.5,
+	}}, info(""))
+	w.WriteModule([]byte("bloom"), &ModuleValue{Module: "MBbloom--", EncVer: 4, Fields: []interface{}{uint64(1), int64(-7), float32(0.25), []byte("bits")}}, info(""))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	corpus = append(corpus, buf.Bytes())
+
+	buf.Reset()
+	buf.WriteString("REDIS0010")
+	buf.Write([]byte{RDBOpcodeSelectDB, 0x00})
+	buf.Write(testStream("s", testListpack("1", "0", "1", "f", "0", "2", "0", "0", "v", "3")))
+	buf.Write([]byte{RDBOpcodeEOF, 0, 0, 0, 0, 0, 0, 0, 0})
+	return append(corpus, buf.Bytes())
+}
+
+// TestCorrupt 随机修改和截断 RDB 文件，容错模式和 Pipeline 都不能 panic
+func TestCorrupt(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i, data := range corruptCorpus(t) {
+		for n := 0; n < 300; n++ {
+			mutated := append([]byte(nil), data...)
+			switch n % 4 {
+			case 0:
+				mutated = mutated[:rnd.Intn(len(mutated))]
+			case 1:
+				mutated[rnd.Intn(len(mutated))] ^= byte(1 + rnd.Intn(255))
+			default:
+				for j := 0; j < 1+rnd.Intn(8); j++ {
+					mutated[rnd.Intn(len(mutated))] = byte(rnd.Intn(256))
+				}
+			}
+			func() {
+				defer func() {
+					if p := recover(); p != nil {
+						t.Fatalf("corpus %d mutation %d: panic %v", i, n, p)
+					}
+				}()
+				r := NewRDB(bufio.NewReader(bytes.NewReader(mutated)), &recordHandler{})
+				r.Tolerant = true
+				if err := r.Parse(); err != nil && r.version > 0 {
+					t.Fatalf("corpus %d mutation %d: %v", i, n, err)
+				}
+				parallelValues(mutated, &Pipeline{Workers: 2, Tolerant: true})
+			}()
+		}
 	}
-	r := NewRDB(bufio.NewReader(handler))
-	_ = r.Parse()
 }
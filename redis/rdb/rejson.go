@@ -0,0 +1,115 @@
+package rdb
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RedisJSON 在 RDB 中的 module 名字
+const ReJSONModule = "ReJSON-RL"
+
+// ReJSON 1.0 (encver 0) 以节点树的形式保存文档，每个节点以类型开头
+const (
+	rejsonNull    = 1
+	rejsonString  = 2
+	rejsonNumber  = 4
+	rejsonInteger = 8
+	rejsonBoolean = 16
+	rejsonDict    = 32
+	rejsonArray   = 64
+	rejsonKeyVal  = 128
+)
+
+// rejsonMaxPrealloc 是解码数组时预分配的最大元素个数
+const rejsonMaxPrealloc = 1024
+
+func init() {
+	RegisterModule(ReJSONModule, decodeReJSON)
+}
+
+// decodeReJSON 解码 RedisJSON 的文档，返回 json.RawMessage。
+// RedisJSON 2.x (encver 2、3) 把整个文档序列化为一个 JSON 字符串保存
+func decodeReJSON(r *ModuleReader, encver int) (interface{}, error) {
+	switch encver {
+	case 0:
+		doc, err := loadReJSONNode(r)
+		if err != nil {
+			return nil, err
+		}
+		out, err := json.Marshal(doc)
+		return json.RawMessage(out), err
+	case 2, 3:
+		doc, err := r.LoadString()
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(doc) {
+			return nil, fmt.Errorf("invalid json document")
+		}
+		return json.RawMessage(doc), nil
+	}
+	return nil, fmt.Errorf("unsupported ReJSON encver %d", encver)
+}
+
+func loadReJSONNode(r *ModuleReader) (interface{}, error) {
+	typ, err := r.LoadUnsigned()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case rejsonNull:
+		return nil, nil
+	case rejsonBoolean:
+		b, err := r.LoadString()
+		return string(b) == "1", err
+	case rejsonInteger:
+		return r.LoadSigned()
+	case rejsonNumber:
+		return r.LoadDouble()
+	case rejsonString:
+		s, err := r.LoadString()
+		return string(s), err
+	case rejsonDict:
+		n, err := r.LoadUnsigned()
+		if err != nil {
+			return nil, err
+		}
+		dict := make(map[string]interface{})
+		for i := uint64(0); i < n; i++ {
+			if typ, err = r.LoadUnsigned(); err != nil {
+				return nil, err
+			}
+			if typ != rejsonKeyVal {
+				return nil, fmt.Errorf("expected ReJSON keyval node, got type %d", typ)
+			}
+			name, err := r.LoadString()
+			if err != nil {
+				return nil, err
+			}
+			if dict[string(name)], err = loadReJSONNode(r); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	case rejsonArray:
+		n, err := r.LoadUnsigned()
+		if err != nil {
+			return nil, err
+		}
+		// n 来自 RDB，可能已经损坏，预分配的容量不超过 rejsonMaxPrealloc
+		capacity := n
+		if capacity > rejsonMaxPrealloc {
+			capacity = rejsonMaxPrealloc
+		}
+		array := make([]interface{}, 0, capacity)
+		for i := uint64(0); i < n; i++ {
+			node, err := loadReJSONNode(r)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, node)
+		}
+		return array, nil
+	}
+	return nil, fmt.Errorf("unknown ReJSON node type %d", typ)
+}
@@ -0,0 +1,409 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+/*
+stream 在 RDB 中的布局：
+| listpacks_count | (master_id, listpack) * N | length | last_id |
+| first_id | max_deleted_id | entries_added |          <- STREAM_LISTPACKS_2 起
+| cgroups_count | cgroup * M |
+
+每个 listpack 节点的第一部分是 master entry：
+| count | deleted | master_fields_count | field * N | 0 |
+之后是各个 entry：
+| flags | ms-diff | seq-diff | (num-fields field value ...) 或 (value ...) | lp-count |
+*/
+
+// stream entry 的 flags
+const (
+	streamItemFlagDeleted    = 1 // entry 已被删除
+	streamItemFlagSameFields = 2 // entry 与 master entry 的 fields 相同
+)
+
+// StreamID 是 stream entry 的 id，格式为 <ms>-<seq>
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// StreamEntry 是 stream 中的一条消息，Fields 中 field 和 value 交替存放
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// StreamPendingEntry 是消费组 PEL 中已投递但未 ACK 的消息
+type StreamPendingEntry struct {
+	ID            StreamID
+	DeliveryTime  int64 // 毫秒时间戳
+	DeliveryCount uint64
+}
+
+// StreamConsumer 是消费组中的一个消费者
+type StreamConsumer struct {
+	Name       []byte
+	SeenTime   int64 // 毫秒时间戳
+	ActiveTime int64 // 毫秒时间戳，STREAM_LISTPACKS_3 起才有，否则为 -1
+	Pending    []StreamID
+}
+
+// StreamGroup 是 stream 的一个消费组
+type StreamGroup struct {
+	Name        []byte
+	LastID      StreamID
+	EntriesRead int64 // STREAM_LISTPACKS_2 起才有，否则为 -1
+	Pending     []StreamPendingEntry
+	Consumers   []StreamConsumer
+}
+
+// StreamMeta 是 stream 的元数据
+type StreamMeta struct {
+	Length       uint64
+	LastID       StreamID
+	FirstID      StreamID
+	MaxDeletedID StreamID
+	EntriesAdded uint64
+	Groups       []StreamGroup
+}
+
+// Lag 返回消费组还未读取的消息数，和 XINFO GROUPS 的 lag 一致；
+// 无法准确计算时（RDB 9 没有 entries_read，或者消费组读取的位置之后有删除的消息）返回 false
+func (m *StreamMeta) Lag(g *StreamGroup) (uint64, bool) {
+	if !streamIDLess(g.LastID, m.LastID) {
+		return 0, true // 已经读到了最后一条消息
+	}
+	if g.EntriesRead < 0 {
+		return 0, false
+	}
+	if m.MaxDeletedID.Ms != 0 || m.MaxDeletedID.Seq != 0 {
+		if !streamIDLess(m.MaxDeletedID, g.LastID) {
+			return 0, false
+		}
+	}
+	if uint64(g.EntriesRead) > m.EntriesAdded {
+		return 0, true
+	}
+	return m.EntriesAdded - uint64(g.EntriesRead), true
+}
+
+func streamIDLess(a, b StreamID) bool {
+	return a.Ms < b.Ms || (a.Ms == b.Ms && a.Seq < b.Seq)
+}
+
+// readStream 读取 RDBTypeStreamListpacks* 类型的 value。
+// 节点完整读出后才发现损坏时返回 *valueError，容错模式下跳过这个节点继续读取；
+// 读取长度和 id 出错时无法知道 value 的结尾，直接返回错误
+func (r *RDB) readStream(key []byte, dtype byte, info *KeyInfo) error {
+	nodes, err := r.readLength()
+	if err != nil {
+		return err
+	}
+	info.Nodes = nodes
+	r.handler.StartStream(key, info)
+	for i := uint64(0); i < nodes; i++ {
+		offset := r.rd.offset
+		nodeKey, keyErr := r.readString()
+		if keyErr != nil && keyErr != errLZFCorrupt {
+			return keyErr
+		}
+		blob, err := r.readString()
+		if err != nil && err != errLZFCorrupt {
+			return err
+		}
+		info.SizeOfValue += uint64(len(blob))
+		if r.raw {
+			continue
+		}
+		if err == nil {
+			err = keyErr
+		}
+		var entries []StreamEntry
+		if err == nil {
+			entries, err = decodeStreamNode(nodeKey, blob)
+		}
+		if err != nil {
+			err = corrupt(fmt.Errorf("stream %s: %v", strconv.Quote(string(key)), err))
+			if r.skip(key, dtype, offset, err) {
+				continue
+			}
+			return err
+		}
+		for i := range entries {
+			r.handler.StreamEntry(key, &entries[i])
+		}
+	}
+
+	meta, err := r.readStreamMeta(dtype)
+	if err != nil {
+		return err
+	}
+	r.handler.EndStream(key, meta, info)
+	return nil
+}
+
+// readStreamMeta 读取 stream 的长度、各种 id 和消费组信息
+func (r *RDB) readStreamMeta(dtype byte) (*StreamMeta, error) {
+	meta := &StreamMeta{}
+	var err error
+	if meta.Length, err = r.readLength(); err != nil {
+		return nil, err
+	}
+	if meta.LastID, err = r.readStreamIDLength(); err != nil {
+		return nil, err
+	}
+	if dtype >= RDBTypeStreamListpacks2 {
+		if meta.FirstID, err = r.readStreamIDLength(); err != nil {
+			return nil, err
+		}
+		if meta.MaxDeletedID, err = r.readStreamIDLength(); err != nil {
+			return nil, err
+		}
+		if meta.EntriesAdded, err = r.readLength(); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		g, err := r.readStreamGroup(dtype)
+		if err != nil {
+			return nil, err
+		}
+		meta.Groups = append(meta.Groups, *g)
+	}
+	return meta, nil
+}
+
+func (r *RDB) readStreamGroup(dtype byte) (*StreamGroup, error) {
+	g := &StreamGroup{EntriesRead: -1}
+	var err error
+	if g.Name, err = r.readString(); err != nil {
+		return nil, err
+	}
+	if g.LastID, err = r.readStreamIDLength(); err != nil {
+		return nil, err
+	}
+	if dtype >= RDBTypeStreamListpacks2 {
+		offset, err := r.readLength()
+		if err != nil {
+			return nil, err
+		}
+		g.EntriesRead = int64(offset)
+	}
+
+	// 消费组的 PEL
+	pending, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < pending; i++ {
+		var pe StreamPendingEntry
+		if pe.ID, err = r.readRawStreamID(); err != nil {
+			return nil, err
+		}
+		if pe.DeliveryTime, err = r.readMillisecondTime(); err != nil {
+			return nil, err
+		}
+		if pe.DeliveryCount, err = r.readLength(); err != nil {
+			return nil, err
+		}
+		g.Pending = append(g.Pending, pe)
+	}
+
+	// 消费者及其 PEL，这里的 PEL 只保存 id，详细信息在消费组的 PEL 中
+	consumers, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < consumers; i++ {
+		c := StreamConsumer{ActiveTime: -1}
+		if c.Name, err = r.readString(); err != nil {
+			return nil, err
+		}
+		if c.SeenTime, err = r.readMillisecondTime(); err != nil {
+			return nil, err
+		}
+		if dtype >= RDBTypeStreamListpacks3 {
+			if c.ActiveTime, err = r.readMillisecondTime(); err != nil {
+				return nil, err
+			}
+		}
+		n, err := r.readLength()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < n; j++ {
+			id, err := r.readRawStreamID()
+			if err != nil {
+				return nil, err
+			}
+			c.Pending = append(c.Pending, id)
+		}
+		g.Consumers = append(g.Consumers, c)
+	}
+	return g, nil
+}
+
+// readStreamIDLength 读取以两个 length 保存的 stream id
+func (r *RDB) readStreamIDLength() (id StreamID, err error) {
+	if id.Ms, err = r.readLength(); err != nil {
+		return
+	}
+	id.Seq, err = r.readLength()
+	return
+}
+
+// readRawStreamID 读取以 16 字节大端序保存的 stream id
+func (r *RDB) readRawStreamID() (StreamID, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(r.rd, buf); err != nil {
+		return StreamID{}, err
+	}
+	return decodeStreamID(buf), nil
+}
+
+// readMillisecondTime 读取 8 字节小端序的毫秒时间戳
+func (r *RDB) readMillisecondTime() (int64, error) {
+	var t int64
+	err := binary.Read(r.rd, binary.LittleEndian, &t)
+	return t, err
+}
+
+func decodeStreamID(buf []byte) StreamID {
+	return StreamID{
+		Ms:  binary.BigEndian.Uint64(buf[:8]),
+		Seq: binary.BigEndian.Uint64(buf[8:16]),
+	}
+}
+
+// decodeStreamNode 解码 stream 的一个节点，nodeKey 为节点的 master id
+func decodeStreamNode(nodeKey, blob []byte) ([]StreamEntry, error) {
+	if len(nodeKey) != 16 {
+		return nil, fmt.Errorf("node key has invalid length %d", len(nodeKey))
+	}
+	lp, err := decodeListpack(blob)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStreamListpack(decodeStreamID(nodeKey), lp)
+}
+
+// decodeStreamListpack 从 stream 的一个 listpack 节点中解出所有未删除的 entry。
+// 各个计数都来自 listpack 本身，使用前先检查是否超过了剩下的元素个数
+func decodeStreamListpack(master StreamID, lp [][]byte) ([]StreamEntry, error) {
+	pos := 0
+	next := func() ([]byte, error) {
+		if pos >= len(lp) {
+			return nil, errShortBuffer
+		}
+		pos++
+		return lp[pos-1], nil
+	}
+	nextInt := func() (int64, error) {
+		b, err := next()
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(string(b), 10, 64)
+	}
+	// nextCount 读取一个计数，每个计数单位至少还需要 per 个元素
+	nextCount := func(name string, per int64) (int64, error) {
+		n, err := nextInt()
+		if err != nil {
+			return 0, err
+		}
+		if n < 0 || n > int64(len(lp)-pos)/per {
+			return 0, fmt.Errorf("invalid %s %d with %d listpack entries left", name, n, len(lp)-pos)
+		}
+		return n, nil
+	}
+
+	// master entry
+	// 每条 entry 至少有 flags、ms-diff、seq-diff 和 lp-count 4 个元素
+	count, err := nextCount("entry count", 4)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := nextCount("deleted count", 4)
+	if err != nil {
+		return nil, err
+	}
+	numFields, err := nextCount("master field count", 1)
+	if err != nil {
+		return nil, err
+	}
+	masterFields := make([][]byte, numFields)
+	for i := range masterFields {
+		if masterFields[i], err = next(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = next(); err != nil { // master entry 的结束标记 0
+		return nil, err
+	}
+
+	if count+deleted > int64(len(lp)-pos)/4 {
+		return nil, fmt.Errorf("%d entries do not fit in %d listpack entries", count+deleted, len(lp)-pos)
+	}
+	entries := make([]StreamEntry, 0, count)
+	for i := int64(0); i < count+deleted; i++ {
+		flags, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		entry := StreamEntry{ID: StreamID{
+			Ms:  master.Ms + uint64(msDiff),
+			Seq: master.Seq + uint64(seqDiff),
+		}}
+
+		if flags&streamItemFlagSameFields != 0 {
+			for _, field := range masterFields {
+				value, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entry.Fields = append(entry.Fields, field, value)
+			}
+		} else {
+			n, err := nextCount("field count", 2)
+			if err != nil {
+				return nil, err
+			}
+			for j := int64(0); j < n*2; j++ {
+				b, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entry.Fields = append(entry.Fields, b)
+			}
+		}
+		if _, err = next(); err != nil { // lp-count
+			return nil, err
+		}
+
+		if flags&streamItemFlagDeleted == 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,460 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// 各类型编码需要的最低 RDB 版本
+var minVersion = map[byte]int{
+	RDBTypeZSet2:          8,
+	RDBTypeListQuicklist:  7,
+	RDBTypeHashListpack:   10,
+	RDBTypeZSetListpack:   10,
+	RDBTypeListQuicklist2: 10,
+	RDBTypeSetListpack:    11,
+	RDBTypeModule2:        8,
+}
+
+// DefaultQuicklistNodeSize 是写入 quicklist 时每个节点的元素个数
+const DefaultQuicklistNodeSize = 128
+
+// Writer 按照指定的 RDB 版本生成 RDB 文件，主要用于构造测试数据。
+//
+// 用法：NewWriter 之后依次调用 WriteHeader、WriteAux、SelectDB、Write* 写入 key，最后调用 Close
+// 写入 EOF 和 CRC64 校验和。每个 key 的编码由 info.Encoding 指定（Encoding* 常量，为空时使用
+// 该类型的普通编码），info.Expiry 不为 0 时写入毫秒过期时间，info.Idle、info.Freq 大于等于 0 时
+// 写入 LRU/LFU 信息；info 为 nil 时全部使用默认值。
+type Writer struct {
+	// Compress 为 true 时长度超过 20 字节的字符串尝试 LZF 压缩
+	Compress bool
+	// QuicklistNodeSize 为 quicklist 每个节点的元素个数
+	QuicklistNodeSize int
+
+	w       *bufio.Writer
+	version int
+	crc     uint64
+	err     error
+}
+
+func NewWriter(w io.Writer, version int) *Writer {
+	return &Writer{
+		QuicklistNodeSize: DefaultQuicklistNodeSize,
+		w:                 bufio.NewWriter(w),
+		version:           version,
+	}
+}
+
+// WriteHeader 写入 "REDIS" 和四位的版本号
+func (w *Writer) WriteHeader() error {
+	w.write([]byte(fmt.Sprintf("REDIS%04d", w.version)))
+	return w.err
+}
+
+// WriteAux 写入一个辅助字段，例如 redis-ver、ctime
+func (w *Writer) WriteAux(key, value string) error {
+	w.writeByte(RDBOpcodeAux)
+	w.writeString([]byte(key))
+	w.writeString([]byte(value))
+	return w.err
+}
+
+// SelectDB 切换之后写入的 key 所在的数据库
+func (w *Writer) SelectDB(db int) error {
+	w.writeByte(RDBOpcodeSelectDB)
+	w.writeLength(uint64(db))
+	return w.err
+}
+
+// ResizeDB 写入当前数据库 key 的个数和设置了过期时间的 key 的个数
+func (w *Writer) ResizeDB(dbSize, expiresSize uint64) error {
+	w.writeByte(RDBOpcodeResizeDB)
+	w.writeLength(dbSize)
+	w.writeLength(expiresSize)
+	return w.err
+}
+
+// WriteString 写入一个 string，能表示为 32 位整数的值按整数编码
+func (w *Writer) WriteString(key, value []byte, info *KeyInfo) error {
+	if err := w.writeKey(key, RDBTypeString, info); err != nil {
+		return err
+	}
+	w.writeString(value)
+	return w.err
+}
+
+// WriteList 写入一个 list，支持 linkedlist、ziplist 和 quicklist 编码
+func (w *Writer) WriteList(key []byte, values [][]byte, info *KeyInfo) error {
+	switch encoding(info) {
+	case "", EncodingLinkedList:
+		if err := w.writeKey(key, RDBTypeList, info); err != nil {
+			return err
+		}
+		w.writeStrings(values)
+	case EncodingZiplist:
+		if err := w.writeKey(key, RDBTypeListZiplist, info); err != nil {
+			return err
+		}
+		w.writeString(encodeZiplist(values))
+	case EncodingQuicklist, EncodingListpack:
+		// RDB 10 起 quicklist 的节点为 listpack，Redis 7.2 中小的 list 编码为 listpack，
+		// 但写入 RDB 时同样保存为只有一个节点的 quicklist
+		if w.version < minVersion[RDBTypeListQuicklist2] {
+			if err := w.writeKey(key, RDBTypeListQuicklist, info); err != nil {
+				return err
+			}
+			nodes := w.split(values)
+			w.writeLength(uint64(len(nodes)))
+			for _, node := range nodes {
+				w.writeString(encodeZiplist(node))
+			}
+			break
+		}
+		if err := w.writeKey(key, RDBTypeListQuicklist2, info); err != nil {
+			return err
+		}
+		nodes := w.split(values)
+		w.writeLength(uint64(len(nodes)))
+		for _, node := range nodes {
+			w.writeLength(QuicklistNodeContainerPacked)
+			w.writeString(encodeListpack(node))
+		}
+	default:
+		return unsupportedEncoding("list", info)
+	}
+	return w.err
+}
+
+// WriteSet 写入一个 set，支持 hashtable、intset 和 listpack 编码
+func (w *Writer) WriteSet(key []byte, members [][]byte, info *KeyInfo) error {
+	switch encoding(info) {
+	case "", EncodingHashtable:
+		if err := w.writeKey(key, RDBTypeSet, info); err != nil {
+			return err
+		}
+		w.writeStrings(members)
+	case EncodingIntset:
+		blob, err := encodeIntset(members)
+		if err != nil {
+			return err
+		}
+		if err = w.writeKey(key, RDBTypeSetIntset, info); err != nil {
+			return err
+		}
+		w.writeString(blob)
+	case EncodingListpack:
+		if err := w.writeKey(key, RDBTypeSetListpack, info); err != nil {
+			return err
+		}
+		w.writeString(encodeListpack(members))
+	default:
+		return unsupportedEncoding("set", info)
+	}
+	return w.err
+}
+
+// WriteZSet 写入一个 zset，支持 skiplist、ziplist 和 listpack 编码。
+// skiplist 编码在 RDB 8 之前以字符串保存 score，之后以二进制保存
+func (w *Writer) WriteZSet(key []byte, members [][]byte, scores []float64, info *KeyInfo) error {
+	if len(members) != len(scores) {
+		return fmt.Errorf("zset %s has %d members but %d scores", strconv.Quote(string(key)), len(members), len(scores))
+	}
+	switch encoding(info) {
+	case "", EncodingSkiplist:
+		dtype := byte(RDBTypeZSet2)
+		if w.version < minVersion[RDBTypeZSet2] {
+			dtype = RDBTypeZSet
+		}
+		if err := w.writeKey(key, dtype, info); err != nil {
+			return err
+		}
+		w.writeLength(uint64(len(members)))
+		for i, member := range members {
+			w.writeString(member)
+			if dtype == RDBTypeZSet2 {
+				w.writeBinaryDouble(scores[i])
+			} else {
+				w.writeDouble(scores[i])
+			}
+		}
+	case EncodingZiplist, EncodingListpack:
+		entries := make([][]byte, 0, 2*len(members))
+		for i, member := range members {
+			entries = append(entries, member, []byte(strconv.FormatFloat(scores[i], 'g', -1, 64)))
+		}
+		if encoding(info) == EncodingZiplist {
+			if err := w.writeKey(key, RDBTypeZSetZiplist, info); err != nil {
+				return err
+			}
+			w.writeString(encodeZiplist(entries))
+		} else {
+			if err := w.writeKey(key, RDBTypeZSetListpack, info); err != nil {
+				return err
+			}
+			w.writeString(encodeListpack(entries))
+		}
+	default:
+		return unsupportedEncoding("zset", info)
+	}
+	return w.err
+}
+
+// WriteHash 写入一个 hash，支持 hashtable、zipmap、ziplist 和 listpack 编码
+func (w *Writer) WriteHash(key []byte, fields, values [][]byte, info *KeyInfo) error {
+	if len(fields) != len(values) {
+		return fmt.Errorf("hash %s has %d fields but %d values", strconv.Quote(string(key)), len(fields), len(values))
+	}
+	pairs := make([][]byte, 0, 2*len(fields))
+	for i, field := range fields {
+		pairs = append(pairs, field, values[i])
+	}
+	switch encoding(info) {
+	case "", EncodingHashtable:
+		if err := w.writeKey(key, RDBTypeHash, info); err != nil {
+			return err
+		}
+		w.writeLength(uint64(len(fields)))
+		for _, p := range pairs {
+			w.writeString(p)
+		}
+	case EncodingZipmap:
+		if err := w.writeKey(key, RDBTypeHashZipmap, info); err != nil {
+			return err
+		}
+		w.writeString(encodeZipmap(pairs))
+	case EncodingZiplist:
+		if err := w.writeKey(key, RDBTypeHashZiplist, info); err != nil {
+			return err
+		}
+		w.writeString(encodeZiplist(pairs))
+	case EncodingListpack:
+		if err := w.writeKey(key, RDBTypeHashListpack, info); err != nil {
+			return err
+		}
+		w.writeString(encodeListpack(pairs))
+	default:
+		return unsupportedEncoding("hash", info)
+	}
+	return w.err
+}
+
+// WriteModule 写入一个 RDB_TYPE_MODULE_2 的 module value，字段按 Go 类型写入：
+// uint64、int64、float32、float64、[]byte 分别对应 module API 的 Unsigned、Signed、Float、Double、StringBuffer
+func (w *Writer) WriteModule(key []byte, value *ModuleValue, info *KeyInfo) error {
+	id, err := EncodeModuleID(value.Module, value.EncVer)
+	if err != nil {
+		return err
+	}
+	if err = w.writeKey(key, RDBTypeModule2, info); err != nil {
+		return err
+	}
+	w.writeLength(id)
+	return w.writeModuleFields(value.Fields)
+}
+
+// WriteModuleAux 写入 module 的辅助数据，when 对应 REDISMODULE_AUX_BEFORE_RDB/AFTER_RDB
+func (w *Writer) WriteModuleAux(value *ModuleValue, when uint64) error {
+	id, err := EncodeModuleID(value.Module, value.EncVer)
+	if err != nil {
+		return err
+	}
+	w.writeByte(RDBOpcodeModuleAux)
+	w.writeLength(id)
+	return w.writeModuleFields(append([]interface{}{when}, value.Fields...))
+}
+
+func (w *Writer) writeModuleFields(fields []interface{}) error {
+	for _, field := range fields {
+		switch f := field.(type) {
+		case uint64:
+			w.writeLength(RDBModuleOpcodeUInt)
+			w.writeLength(f)
+		case int64:
+			w.writeLength(RDBModuleOpcodeSInt)
+			w.writeLength(uint64(f))
+		case float32:
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], math.Float32bits(f))
+			w.writeLength(RDBModuleOpcodeFloat)
+			w.write(b[:])
+		case float64:
+			w.writeLength(RDBModuleOpcodeDouble)
+			w.writeBinaryDouble(f)
+		case []byte:
+			w.writeLength(RDBModuleOpcodeString)
+			w.writeString(f)
+		default:
+			return fmt.Errorf("rdb: unsupported module field type %T", field)
+		}
+	}
+	w.writeLength(RDBModuleOpcodeEOF)
+	return w.err
+}
+
+// Close 写入 EOF 和校验和（RDB 5 及以后），并把缓冲区写出，不会关闭底层的 io.Writer
+func (w *Writer) Close() error {
+	w.writeByte(RDBOpcodeEOF)
+	if w.version >= 5 {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], w.crc)
+		w.write(b[:])
+	}
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// writeKey 写入过期时间、LRU/LFU 信息、类型和 key
+func (w *Writer) writeKey(key []byte, dtype byte, info *KeyInfo) error {
+	if v, ok := minVersion[dtype]; ok && w.version < v {
+		return fmt.Errorf("rdb: %s encoding of %s requires RDB version %d, writing %d",
+			encodingName(dtype), typeName(dtype), v, w.version)
+	}
+	if info != nil {
+		if info.Expiry != 0 {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], uint64(info.Expiry))
+			w.writeByte(RDBOpcodeExpireTimeMS)
+			w.write(b[:])
+		}
+		// RDB 9 之前没有 LRU/LFU 信息
+		if info.Idle >= 0 && w.version >= 9 {
+			w.writeByte(RDBOpcodeIdle)
+			w.writeLength(uint64(info.Idle))
+		}
+		if info.Freq >= 0 && w.version >= 9 {
+			w.writeByte(RDBOpcodeFreq)
+			w.writeByte(byte(info.Freq))
+		}
+	}
+	w.writeByte(dtype)
+	w.writeString(key)
+	return w.err
+}
+
+// split 把 quicklist 的元素按 QuicklistNodeSize 分成多个节点
+func (w *Writer) split(values [][]byte) [][][]byte {
+	size := w.QuicklistNodeSize
+	if size <= 0 {
+		size = DefaultQuicklistNodeSize
+	}
+	var nodes [][][]byte
+	for len(values) > size {
+		nodes = append(nodes, values[:size])
+		values = values[size:]
+	}
+	if len(values) > 0 {
+		nodes = append(nodes, values)
+	}
+	return nodes
+}
+
+func (w *Writer) writeStrings(values [][]byte) {
+	w.writeLength(uint64(len(values)))
+	for _, v := range values {
+		w.writeString(v)
+	}
+}
+
+func (w *Writer) writeLength(length uint64) {
+	switch {
+	case length < 1<<6:
+		w.writeByte(byte(length))
+	case length < 1<<14:
+		w.write([]byte{RDB14BitLen<<6 | byte(length>>8), byte(length)})
+	case length <= math.MaxUint32:
+		var b [5]byte
+		b[0] = RDB32BitLen
+		binary.BigEndian.PutUint32(b[1:], uint32(length))
+		w.write(b[:])
+	default:
+		var b [9]byte
+		b[0] = RDB64BitLen
+		binary.BigEndian.PutUint64(b[1:], length)
+		w.write(b[:])
+	}
+}
+
+// writeString 写入一个字符串，按照 Redis 的规则选择整数编码或 LZF 压缩
+func (w *Writer) writeString(s []byte) {
+	if len(s) <= 11 {
+		if v, ok := parseStrictInt(s); ok {
+			var b [4]byte
+			switch {
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				w.write([]byte{RDBEncVal<<6 | RDBEncInt8, byte(v)})
+				return
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				binary.LittleEndian.PutUint16(b[:], uint16(v))
+				w.write(append([]byte{RDBEncVal<<6 | RDBEncInt16}, b[:2]...))
+				return
+			case v >= math.MinInt32 && v <= math.MaxInt32:
+				binary.LittleEndian.PutUint32(b[:], uint32(v))
+				w.write(append([]byte{RDBEncVal<<6 | RDBEncInt32}, b[:]...))
+				return
+			}
+		}
+	}
+	if w.Compress && len(s) > 20 {
+		if compressed := lzfCompress(s); compressed != nil {
+			w.writeByte(RDBEncVal<<6 | RDBEncLZF)
+			w.writeLength(uint64(len(compressed)))
+			w.writeLength(uint64(len(s)))
+			w.write(compressed)
+			return
+		}
+	}
+	w.writeLength(uint64(len(s)))
+	w.write(s)
+}
+
+// writeBinaryDouble 写入 8 字节小端序的 double，对应 readBinaryDouble
+func (w *Writer) writeBinaryDouble(f float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	w.write(b[:])
+}
+
+// writeDouble 以旧版本的字符串格式写入 double，对应 readDouble
+func (w *Writer) writeDouble(f float64) {
+	switch {
+	case math.IsNaN(f):
+		w.writeByte(253)
+	case math.IsInf(f, 1):
+		w.writeByte(254)
+	case math.IsInf(f, -1):
+		w.writeByte(255)
+	default:
+		s := strconv.FormatFloat(f, 'g', 17, 64)
+		w.writeByte(byte(len(s)))
+		w.write([]byte(s))
+	}
+}
+
+func (w *Writer) writeByte(b byte) {
+	w.write([]byte{b})
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc = crc64Update(w.crc, p)
+	_, w.err = w.w.Write(p)
+}
+
+func encoding(info *KeyInfo) string {
+	if info == nil {
+		return ""
+	}
+	return info.Encoding
+}
+
+func unsupportedEncoding(typ string, info *KeyInfo) error {
+	return fmt.Errorf("rdb: unsupported %s encoding %q", typ, info.Encoding)
+}
@@ -0,0 +1,177 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func bytesList(items ...string) [][]byte {
+	out := make([][]byte, len(items))
+	for i, item := range items {
+		out[i] = []byte(item)
+	}
+	return out
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	long := strings.Repeat("abcdefgh", 40)
+	many := make([]string, 300)
+	for i := range many {
+		many[i] = fmt.Sprint(i * 997)
+	}
+	ints := bytesList("-2147483649", "-5", "3", "70000")
+
+	type key struct {
+		name  string
+		write func(w *Writer, info *KeyInfo) error
+		want  string
+	}
+	keys := []key{
+		{"str", func(w *Writer, info *KeyInfo) error { return w.WriteString([]byte("str"), []byte(long), info) }, long},
+		{"int", func(w *Writer, info *KeyInfo) error { return w.WriteString([]byte("int"), []byte("-70000"), info) }, "-70000"},
+		{"list", func(w *Writer, info *KeyInfo) error {
+			return w.WriteList([]byte("list"), bytesList(many...), info)
+		}, strings.Join(many, " ")},
+		{"set", func(w *Writer, info *KeyInfo) error { return w.WriteSet([]byte("set"), ints, info) }, "-2147483649 -5 3 70000"},
+		{"zset", func(w *Writer, info *KeyInfo) error {
+			return w.WriteZSet([]byte("zset"), bytesList("a", "b", "c"), []float64{1.5, -3, math.Inf(1)}, info)
+		}, "a:1.5 b:-3 c:+Inf"},
+		{"hash", func(w *Writer, info *KeyInfo) error {
+			return w.WriteHash([]byte("hash"), bytesList("f1", long), bytesList("12", "v"), info)
+		}, "f1=12 " + long + "=v"},
+	}
+
+	cases := []struct {
+		version   int
+		encodings map[string]string
+	}{
+		{6, map[string]string{"list": EncodingLinkedList, "set": EncodingHashtable, "zset": EncodingSkiplist, "hash": EncodingZipmap}},
+		{7, map[string]string{"list": EncodingZiplist, "set": EncodingIntset, "zset": EncodingZiplist, "hash": EncodingHashtable}},
+		{9, map[string]string{"list": EncodingQuicklist, "set": EncodingIntset, "zset": EncodingSkiplist, "hash": EncodingZiplist}},
+		{11, map[string]string{"list": EncodingQuicklist, "set": EncodingListpack, "zset": EncodingListpack, "hash": EncodingListpack}},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, c.version)
+		w.Compress = true
+		w.QuicklistNodeSize = 100
+		w.WriteHeader()
+		w.WriteAux("redis-ver", "7.2.0")
+		w.SelectDB(3)
+		w.ResizeDB(uint64(len(keys)), 1)
+		for i, k := range keys {
+			info := &KeyInfo{Encoding: c.encodings[k.name], Idle: -1, Freq: -1}
+			if i == 0 {
+				info.Expiry, info.Idle = 1700000000123, 42
+			}
+			if err := k.write(w, info); err != nil {
+				t.Fatalf("v%d: write %s: %v", c.version, k.name, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		h := &valuesHandler{}
+		if err := NewRDB(bufio.NewReader(&buf), h).Parse(); err != nil {
+			t.Fatalf("v%d: parse: %v", c.version, err)
+		}
+		if h.aux["redis-ver"] != "7.2.0" {
+			t.Fatalf("v%d: unexpected aux %v", c.version, h.aux)
+		}
+		for i, k := range keys {
+			if h.keys[i] != k.name || strings.Join(h.values[i], " ") != k.want {
+				t.Fatalf("v%d: key %s = %s %v", c.version, k.name, h.keys[i], h.values[i])
+			}
+			info := h.infos[i]
+			if enc := c.encodings[k.name]; enc != "" && info.Encoding != enc {
+				t.Fatalf("v%d: key %s encoding %s, want %s", c.version, k.name, info.Encoding, enc)
+			}
+			if info.DB != 3 {
+				t.Fatalf("v%d: key %s in db %d", c.version, k.name, info.DB)
+			}
+		}
+		first := h.infos[0]
+		wantIdle := int64(42)
+		if c.version < 9 {
+			wantIdle = -1
+		}
+		if first.Expiry != 1700000000123 || first.Idle != wantIdle || h.infos[1].Expiry != 0 {
+			t.Fatalf("v%d: unexpected key info %+v", c.version, first)
+		}
+	}
+}
+
+// valuesHandler 按 key 分组记录元素
+type valuesHandler struct {
+	NopHandler
+	keys   []string
+	infos  []KeyInfo
+	values [][]string
+	aux    map[string]string
+}
+
+func (h *valuesHandler) key(key []byte, info *KeyInfo) {
+	h.keys = append(h.keys, string(key))
+	h.infos = append(h.infos, *info)
+	h.values = append(h.values, nil)
+}
+func (h *valuesHandler) add(v string) {
+	h.values[len(h.values)-1] = append(h.values[len(h.values)-1], v)
+}
+func (h *valuesHandler) Aux(key, value []byte) {
+	if h.aux == nil {
+		h.aux = make(map[string]string)
+	}
+	h.aux[string(key)] = string(value)
+}
+func (h *valuesHandler) Set(key, value []byte, info *KeyInfo) {
+	h.key(key, info)
+	h.add(string(value))
+}
+func (h *valuesHandler) StartHash(key []byte, length int64, info *KeyInfo) { h.key(key, info) }
+func (h *valuesHandler) Hset(key, field, value []byte)                     { h.add(string(field) + "=" + string(value)) }
+func (h *valuesHandler) StartList(key []byte, length int64, info *KeyInfo) { h.key(key, info) }
+func (h *valuesHandler) Rpush(key, value []byte)                           { h.add(string(value)) }
+func (h *valuesHandler) StartSet(key []byte, length int64, info *KeyInfo)  { h.key(key, info) }
+func (h *valuesHandler) Sadd(key, member []byte)                           { h.add(string(member)) }
+func (h *valuesHandler) StartZSet(key []byte, length int64, info *KeyInfo) { h.key(key, info) }
+func (h *valuesHandler) Zadd(key []byte, score float64, member []byte) {
+	h.add(fmt.Sprintf("%s:%v", member, score))
+}
+
+func TestWriterVersionCheck(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{}, 9)
+	err := w.WriteHash([]byte("h"), bytesList("f"), bytesList("v"), &KeyInfo{Encoding: EncodingListpack})
+	if err == nil {
+		t.Fatal("expected error writing listpack into RDB 9")
+	}
+	if err = w.WriteSet([]byte("s"), bytesList("a"), &KeyInfo{Encoding: EncodingIntset}); err == nil {
+		t.Fatal("expected error writing non integer intset")
+	}
+}
+
+func TestLZFCompress(t *testing.T) {
+	inputs := []string{
+		strings.Repeat("a", 1000),
+		strings.Repeat("hello world ", 50),
+		"abcabcabcabcabcabcabcabcXYZabcabc" + strings.Repeat("0123456789", 30),
+	}
+	for _, in := range inputs {
+		compressed := lzfCompress([]byte(in))
+		if compressed == nil || len(compressed) >= len(in) {
+			t.Fatalf("expected %q to be compressed", in[:20])
+		}
+		out, err := lzfDecompress(compressed, len(in))
+		if err != nil || string(out) != in {
+			t.Fatalf("round trip of %q failed: %v", in[:20], err)
+		}
+	}
+	if lzfCompress([]byte("abcdefghijklmnopqrstuvwxyz")) != nil {
+		t.Fatal("expected incompressible data to return nil")
+	}
+}
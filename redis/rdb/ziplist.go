@@ -0,0 +1,407 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+/*
+ziplist 的内存布局：
++---------+--------+-------+--------+-----+--------+-------+
+| zlbytes | zltail | zllen | entry1 | ... | entryN | zlend |
++---------+--------+-------+--------+-----+--------+-------+
+   4字节     4字节    2字节                             0xff
+
+每个 entry 的布局： | prevlen | encoding | content |
+*/
+
+var errShortBuffer = errors.New("unexpected end of encoded buffer")
+
+// ziplist entry 的编码
+const (
+	zipStr06B = 0x00 // 00pppppp
+	zipStr14B = 0x40 // 01pppppp qqqqqqqq
+	zipStr32B = 0x80 // 10000000 + 4 字节长度
+	zipInt16B = 0xc0 // 11000000
+	zipInt32B = 0xd0 // 11010000
+	zipInt64B = 0xe0 // 11100000
+	zipInt24B = 0xf0 // 11110000
+	zipInt8B  = 0xfe // 11111110
+	zipEnd    = 0xff
+)
+
+// sliceReader 用于从已经读入内存的编码数据中按顺序读取
+type sliceReader struct {
+	buf []byte
+	pos int
+}
+
+func (s *sliceReader) next(n int) ([]byte, error) {
+	if n < 0 || s.pos+n > len(s.buf) {
+		return nil, errShortBuffer
+	}
+	b := s.buf[s.pos : s.pos+n]
+	s.pos += n
+	return b, nil
+}
+
+func (s *sliceReader) readByte() (byte, error) {
+	b, err := s.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// decodeZiplist 解码一个 ziplist，整数类型的 entry 会被转换成十进制字符串
+func decodeZiplist(buf []byte) ([][]byte, error) {
+	s := &sliceReader{buf: buf}
+	header, err := s.next(10)
+	if err != nil {
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint16(header[8:]))
+
+	entries := make([][]byte, 0, length)
+	for {
+		prevlen, err := s.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if prevlen == zipEnd {
+			break
+		}
+		if prevlen == 0xfe {
+			// 前一个 entry 的长度 >= 254 时，用 5 个字节保存
+			if _, err = s.next(4); err != nil {
+				return nil, err
+			}
+		}
+		entry, err := decodeZiplistEntry(s)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func decodeZiplistEntry(s *sliceReader) ([]byte, error) {
+	header, err := s.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch header & 0xc0 {
+	case zipStr06B:
+		return s.next(int(header & 0x3f))
+	case zipStr14B:
+		b, err := s.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return s.next(int(header&0x3f)<<8 | int(b))
+	case zipStr32B:
+		b, err := s.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return s.next(int(binary.BigEndian.Uint32(b)))
+	}
+
+	var value int64
+	switch header {
+	case zipInt8B:
+		b, err := s.next(1)
+		if err != nil {
+			return nil, err
+		}
+		value = int64(int8(b[0]))
+	case zipInt16B:
+		b, err := s.next(2)
+		if err != nil {
+			return nil, err
+		}
+		value = int64(int16(binary.LittleEndian.Uint16(b)))
+	case zipInt24B:
+		b, err := s.next(3)
+		if err != nil {
+			return nil, err
+		}
+		// 左移 8 位再算术右移，完成 24 位有符号数的符号扩展
+		value = int64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8)
+	case zipInt32B:
+		b, err := s.next(4)
+		if err != nil {
+			return nil, err
+		}
+		value = int64(int32(binary.LittleEndian.Uint32(b)))
+	case zipInt64B:
+		b, err := s.next(8)
+		if err != nil {
+			return nil, err
+		}
+		value = int64(binary.LittleEndian.Uint64(b))
+	default:
+		// 1111xxxx，xxxx 在 0001 到 1101 之间，值为 xxxx - 1
+		imm := header & 0x0f
+		if imm < 1 || imm > 13 {
+			return nil, fmt.Errorf("unknown ziplist entry encoding %#x", header)
+		}
+		value = int64(imm) - 1
+	}
+	return []byte(strconv.FormatInt(value, 10)), nil
+}
+
+/*
+zipmap 的内存布局（Redis 2.6 之前的小 hash 编码）：
+<zmlen><len>"foo"<len><free>"bar"<len>"hello"<len><free>"world"<zmend>
+*/
+
+// decodeZipmap 解码 zipmap，返回 field、value 交替存放的列表
+func decodeZipmap(buf []byte) ([][]byte, error) {
+	s := &sliceReader{buf: buf}
+	if _, err := s.readByte(); err != nil { // zmlen 在元素超过 254 个时不可信，不使用
+		return nil, err
+	}
+
+	var entries [][]byte
+	for {
+		length, end, err := zipmapLength(s)
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			break
+		}
+		field, err := s.next(length)
+		if err != nil {
+			return nil, err
+		}
+
+		length, end, err = zipmapLength(s)
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			return nil, fmt.Errorf("zipmap field %s has no value", strconv.Quote(string(field)))
+		}
+		free, err := s.readByte()
+		if err != nil {
+			return nil, err
+		}
+		value, err := s.next(length)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = s.next(int(free)); err != nil {
+			return nil, err
+		}
+		entries = append(entries, field, value)
+	}
+	return entries, nil
+}
+
+func zipmapLength(s *sliceReader) (length int, end bool, err error) {
+	b, err := s.readByte()
+	if err != nil {
+		return
+	}
+	switch {
+	case b == zipEnd:
+		end = true
+	case b == 254:
+		var l []byte
+		l, err = s.next(4)
+		if err != nil {
+			return
+		}
+		length = int(binary.LittleEndian.Uint32(l))
+	default:
+		length = int(b)
+	}
+	return
+}
+
+/*
+intset 的内存布局：
+| encoding | length | contents |
+   4字节     4字节    length * encoding 字节
+*/
+
+// decodeIntset 解码 intset，整数被转换成十进制字符串
+func decodeIntset(buf []byte) ([][]byte, error) {
+	s := &sliceReader{buf: buf}
+	header, err := s.next(8)
+	if err != nil {
+		return nil, err
+	}
+	encoding := int(binary.LittleEndian.Uint32(header))
+	length := int(binary.LittleEndian.Uint32(header[4:]))
+	if encoding != 2 && encoding != 4 && encoding != 8 {
+		return nil, fmt.Errorf("unknown intset encoding %d", encoding)
+	}
+	if length > (len(buf)-8)/encoding {
+		return nil, fmt.Errorf("intset length %d does not fit in %d bytes", length, len(buf)-8)
+	}
+
+	members := make([][]byte, 0, length)
+	for i := 0; i < length; i++ {
+		b, err := s.next(encoding)
+		if err != nil {
+			return nil, err
+		}
+		var value int64
+		switch encoding {
+		case 2:
+			value = int64(int16(binary.LittleEndian.Uint16(b)))
+		case 4:
+			value = int64(int32(binary.LittleEndian.Uint32(b)))
+		case 8:
+			value = int64(binary.LittleEndian.Uint64(b))
+		}
+		members = append(members, []byte(strconv.FormatInt(value, 10)))
+	}
+	return members, nil
+}
+
+// parseStrictInt 判断 b 是否为一个规范的十进制整数（没有前导 0 和 + 号），
+// Redis 只会把这样的字符串按整数编码
+func parseStrictInt(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != string(b) {
+		return 0, false
+	}
+	return v, true
+}
+
+// encodeZiplist 把 entries 编码为 ziplist，可以表示为整数的 entry 按整数编码
+func encodeZiplist(entries [][]byte) []byte {
+	buf := make([]byte, 10, 11)
+	prevlen, tail := 0, 10
+	for _, entry := range entries {
+		start := len(buf)
+		tail = start
+		if prevlen < 254 {
+			buf = append(buf, byte(prevlen))
+		} else {
+			buf = append(buf, 0xfe, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(buf[len(buf)-4:], uint32(prevlen))
+		}
+		buf = appendZiplistEntry(buf, entry)
+		prevlen = len(buf) - start
+	}
+	buf = append(buf, zipEnd)
+
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(buf)))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(tail))
+	length := len(entries)
+	if length > 0xffff {
+		length = 0xffff // 超过 65535 时需要遍历才能得到元素个数
+	}
+	binary.LittleEndian.PutUint16(buf[8:], uint16(length))
+	return buf
+}
+
+func appendZiplistEntry(buf, entry []byte) []byte {
+	if v, ok := parseStrictInt(entry); ok {
+		var b [8]byte
+		switch {
+		case v >= 0 && v <= 12:
+			return append(buf, 0xf1+byte(v))
+		case v >= math.MinInt8 && v <= math.MaxInt8:
+			return append(buf, zipInt8B, byte(v))
+		case v >= math.MinInt16 && v <= math.MaxInt16:
+			binary.LittleEndian.PutUint16(b[:], uint16(v))
+			return append(append(buf, zipInt16B), b[:2]...)
+		case v >= -1<<23 && v < 1<<23:
+			binary.LittleEndian.PutUint32(b[:], uint32(v))
+			return append(append(buf, zipInt24B), b[:3]...)
+		case v >= math.MinInt32 && v <= math.MaxInt32:
+			binary.LittleEndian.PutUint32(b[:], uint32(v))
+			return append(append(buf, zipInt32B), b[:4]...)
+		}
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		return append(append(buf, zipInt64B), b[:]...)
+	}
+
+	switch l := len(entry); {
+	case l <= 0x3f:
+		buf = append(buf, zipStr06B|byte(l))
+	case l <= 0x3fff:
+		buf = append(buf, zipStr14B|byte(l>>8), byte(l))
+	default:
+		buf = append(buf, zipStr32B, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(l))
+	}
+	return append(buf, entry...)
+}
+
+// encodeZipmap 把 field、value 交替存放的列表编码为 zipmap
+func encodeZipmap(pairs [][]byte) []byte {
+	n := len(pairs) / 2
+	if n > 253 {
+		n = 254
+	}
+	buf := []byte{byte(n)}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		buf = appendZipmapLength(buf, len(pairs[i]))
+		buf = append(buf, pairs[i]...)
+		buf = appendZipmapLength(buf, len(pairs[i+1]))
+		buf = append(buf, 0) // free
+		buf = append(buf, pairs[i+1]...)
+	}
+	return append(buf, zipEnd)
+}
+
+func appendZipmapLength(buf []byte, l int) []byte {
+	if l < 254 {
+		return append(buf, byte(l))
+	}
+	buf = append(buf, 254, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(buf[len(buf)-4:], uint32(l))
+	return buf
+}
+
+// encodeIntset 把整数成员编码为 intset，成员不是整数时返回错误
+func encodeIntset(members [][]byte) ([]byte, error) {
+	values := make([]int64, len(members))
+	encoding := 2
+	for i, m := range members {
+		v, ok := parseStrictInt(m)
+		if !ok {
+			return nil, fmt.Errorf("intset member %s is not an integer", strconv.Quote(string(m)))
+		}
+		values[i] = v
+		switch {
+		case v < math.MinInt32 || v > math.MaxInt32:
+			encoding = 8
+		case (v < math.MinInt16 || v > math.MaxInt16) && encoding < 4:
+			encoding = 4
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	buf := make([]byte, 8+len(values)*encoding)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(encoding))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(values)))
+	for i, v := range values {
+		b := buf[8+i*encoding:]
+		switch encoding {
+		case 2:
+			binary.LittleEndian.PutUint16(b, uint16(v))
+		case 4:
+			binary.LittleEndian.PutUint32(b, uint32(v))
+		case 8:
+			binary.LittleEndian.PutUint64(b, uint64(v))
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,496 @@
+package rdbcmd
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ssp4599815/monitors/libmonitor/cfgfile"
+	"github.com/ssp4599815/monitors/redis/analyzer"
+	cfg "github.com/ssp4599815/monitors/redis/config"
+	"github.com/ssp4599815/monitors/redis/exporter"
+	"github.com/ssp4599815/monitors/redis/rdb"
+	"github.com/ssp4599815/monitors/redis/replica"
+)
+
+const usage = `usage: redis-monitor rdb [-tolerant] [-workers N] <command> [flags] <dump.rdb>
+
+<dump.rdb> can also be redis://[:password@]host:port, the snapshot is then
+pulled from the live redis through the replication protocol.
+
+With -tolerant, corrupt values in local dumps are skipped and reported with
+their offsets, and a truncated dump still produces a partial report.
+With -workers N, bigkeys, prefix, ttl, hotkeys and json decode values of local
+dumps in N goroutines; the output is the same as with a single one.
+
+commands:
+  bigkeys    print the top N keys by memory and by element count
+  prefix     aggregate keys by prefix: key count, memory, TTL coverage and type mix
+  ttl        TTL distribution per database and prefix, and mass-expiry windows
+  hotkeys    hottest keys by LFU counter and coldest keys by LRU idle time
+  diff       compare two dumps: added, removed, changed keys and growth by prefix
+  fetch      save a snapshot of a live redis to a local file
+  json       export keys and decoded values as JSON Lines
+  resp       convert keys into RESP commands for redis-cli --pipe
+`
+
+// options 是所有子命令共用的全局参数
+type options struct {
+	// tolerant 为 true 时以容错模式解析本地的 RDB 文件
+	tolerant bool
+	// workers 大于 1 时用 rdb.Pipeline 并行解码本地的 RDB 文件
+	workers int
+}
+
+// Run 执行 rdb 相关的子命令，args 不包含 "rdb" 本身
+func Run(args []string, stdout io.Writer) error {
+	o := &options{}
+	global := flag.NewFlagSet("rdb", flag.ContinueOnError)
+	global.BoolVar(&o.tolerant, "tolerant", false, "skip corrupt values and report them with their offsets")
+	global.IntVar(&o.workers, "workers", 1, "number of goroutines decoding values")
+	if err := global.Parse(args); err != nil {
+		return err
+	}
+	args = global.Args()
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "bigkeys":
+		return o.runBigKeys(args[1:], stdout)
+	case "prefix":
+		return o.runPrefix(args[1:], stdout)
+	case "ttl":
+		return o.runTTL(args[1:], stdout)
+	case "hotkeys":
+		return o.runHotKeys(args[1:], stdout)
+	case "diff":
+		return o.runDiff(args[1:], stdout)
+	case "fetch":
+		return runFetch(args[1:], stdout)
+	case "json":
+		return o.runJSON(args[1:], stdout)
+	case "resp":
+		return o.runRESP(args[1:], stdout)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	}
+	return fmt.Errorf("unknown rdb command %q\n%s", args[0], usage)
+}
+
+func (o *options) runBigKeys(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("bigkeys", flag.ContinueOnError)
+	n := fs.Int("n", 10, "number of keys to report")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: redis-monitor rdb bigkeys [-n N] <dump.rdb>")
+	}
+
+	report := analyzer.NewBigKeys(*n)
+	if err := o.parseRecords(fs.Arg(0), nil, report.Add); err != nil {
+		return err
+	}
+	return report.Report(stdout)
+}
+
+// stringsFlag 是可以重复指定的字符串参数
+type stringsFlag []string
+
+func (s *stringsFlag) String() string     { return strings.Join(*s, ",") }
+func (s *stringsFlag) Set(v string) error { *s = append(*s, v); return nil }
+
+func (o *options) runPrefix(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("prefix", flag.ContinueOnError)
+	sep := fs.String("sep", ":", "key separator")
+	depth := fs.Int("depth", 2, "number of separated segments used as prefix")
+	n := fs.Int("n", 0, "number of prefixes to report, 0 for all")
+	configPath := fs.String("config", "", "config file, used with -line to load key_patterns")
+	line := fs.String("line", "", "business line whose key_patterns are used for grouping")
+	var regexes stringsFlag
+	fs.Var(&regexes, "regex", "group keys by regex, the first capture group is the prefix (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: redis-monitor rdb prefix [-sep :] [-depth 2] [-regex re]... [-config file -line name] <dump.rdb>")
+	}
+
+	if *line != "" {
+		patterns, err := linePatterns(*configPath, *line)
+		if err != nil {
+			return err
+		}
+		regexes = append(regexes, patterns...)
+	}
+	patterns, err := compilePatterns(regexes)
+	if err != nil {
+		return err
+	}
+
+	agg := analyzer.NewPrefixAggregator(*sep, *depth, patterns)
+	if err := o.parseRecords(fs.Arg(0), nil, agg.Add); err != nil {
+		return err
+	}
+	return agg.Report(stdout, *n)
+}
+
+func (o *options) runTTL(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("ttl", flag.ContinueOnError)
+	sep := fs.String("sep", ":", "key separator")
+	depth := fs.Int("depth", 2, "number of separated segments used as prefix, 0 to disable the per prefix report")
+	window := fs.Duration("window", time.Minute, "window used to find mass-expiry cliffs")
+	cliffs := fs.Int("cliffs", 10, "number of expiry windows to report")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: redis-monitor rdb ttl [-sep :] [-depth 2] [-window 1m] <dump.rdb>")
+	}
+	if *window < time.Millisecond {
+		return fmt.Errorf("-window must be at least 1ms, got %s", *window)
+	}
+
+	var prefix func(string) string
+	if *depth > 0 {
+		prefix = analyzer.NewPrefixAggregator(*sep, *depth, nil).Prefix
+	}
+	exp := analyzer.NewExpiryAnalyzer(prefix)
+	exp.CliffWindow = *window
+	if err := o.parseRecords(fs.Arg(0), exp, exp.Add); err != nil {
+		return err
+	}
+	return exp.Report(stdout, *cliffs)
+}
+
+func (o *options) runHotKeys(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("hotkeys", flag.ContinueOnError)
+	n := fs.Int("n", 10, "number of keys to report")
+	logFactor := fs.Int("lfu-log-factor", analyzer.DefaultLFULogFactor, "lfu-log-factor of the redis the dump comes from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: redis-monitor rdb hotkeys [-n N] [-lfu-log-factor 10] <dump.rdb>")
+	}
+
+	hot := analyzer.NewHotKeys(*n)
+	hot.LogFactor = *logFactor
+	if err := o.parseRecords(fs.Arg(0), nil, hot.Add); err != nil {
+		return err
+	}
+	return hot.Report(stdout)
+}
+
+func (o *options) runDiff(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	n := fs.Int("n", 20, "number of keys to list for each kind of change")
+	sep := fs.String("sep", ":", "key separator")
+	depth := fs.Int("depth", 2, "number of separated segments used as prefix, 0 to disable the per prefix report")
+	mem := fs.Int("mem", analyzer.DefaultDiffMemory>>20, "MB of key summaries sorted in memory before spilling to temporary files")
+	tmp := fs.String("tmp", "", "directory of temporary files, default the system temporary directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: redis-monitor rdb diff [-n 20] [-sep :] [-depth 2] [-mem 512] [-tmp dir] <old.rdb> <new.rdb>")
+	}
+
+	var prefix func(string) string
+	if *depth > 0 {
+		prefix = analyzer.NewPrefixAggregator(*sep, *depth, nil).Prefix
+	}
+	diff := analyzer.NewDiff(*n, prefix)
+	diff.MaxMemory, diff.TempDir = *mem<<20, *tmp
+	if err := o.parseFile(fs.Arg(0), diff.Index()); err != nil {
+		return err
+	}
+	if err := o.parseFile(fs.Arg(1), diff.Compare()); err != nil {
+		return err
+	}
+	if err := diff.Finish(); err != nil {
+		return err
+	}
+	return diff.Report(stdout)
+}
+
+func (o *options) runJSON(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("json", flag.ContinueOnError)
+	filter := filterFlags(fs)
+	b64 := fs.Bool("base64", false, "base64 encode keys and values instead of escaping binary bytes")
+	output := fs.String("o", "", "output file, default stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: redis-monitor rdb json [-db 0,1] [-key glob | -regex re] [-type hash,set] [-base64] [-o file] <dump.rdb>")
+	}
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	out, closeOut, err := createOutput(*output, stdout)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	e := exporter.NewJSONLExporter(out)
+	e.Filter = f
+	e.Base64 = *b64
+	if err := o.parseParallel(fs.Arg(0), e.Pipeline(o.workers), e); err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+func (o *options) runRESP(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("resp", flag.ContinueOnError)
+	filter := filterFlags(fs)
+	batch := fs.Int("batch", exporter.DefaultBatchSize, "max elements per command for large collections")
+	del := fs.Bool("del", true, "emit DEL before each collection key so replaying replaces existing keys")
+	output := fs.String("o", "", "output file, default stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: redis-monitor rdb resp [-db 0,1] [-key glob | -regex re] [-type hash,set] [-batch 128] [-del=false] [-o file] <dump.rdb>")
+	}
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	out, closeOut, err := createOutput(*output, stdout)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	e := exporter.NewRESPExporter(out)
+	e.Filter = f
+	e.BatchSize = *batch
+	e.Del = *del
+	if err := o.parseFile(fs.Arg(0), e); err != nil {
+		return err
+	}
+	reportSkippedModules(fs.Arg(0), e.SkippedModules())
+	return e.Flush()
+}
+
+// filterFlags 注册导出类命令共用的过滤参数，返回的函数在参数解析完成后构造 Filter
+func filterFlags(fs *flag.FlagSet) func() (*exporter.Filter, error) {
+	dbs := fs.String("db", "", "comma separated databases to export, empty for all")
+	key := fs.String("key", "", "export keys matching the glob pattern, e.g. 'user:*'")
+	keyRegex := fs.String("regex", "", "export keys matching the regex")
+	types := fs.String("type", "", "comma separated types to export, e.g. hash,zset")
+	return func() (*exporter.Filter, error) {
+		if *key != "" && *keyRegex != "" {
+			return nil, errors.New("-key and -regex can not be used together")
+		}
+		filter := &exporter.Filter{Types: exporter.ParseTypes(*types)}
+		var err error
+		if filter.DBs, err = exporter.ParseDBs(*dbs); err != nil {
+			return nil, fmt.Errorf("invalid -db: %v", err)
+		}
+		switch {
+		case *key != "":
+			filter.Key, err = exporter.GlobToRegexp(*key)
+		case *keyRegex != "":
+			filter.Key, err = regexp.Compile(*keyRegex)
+		}
+		return filter, err
+	}
+}
+
+// createOutput 打开输出文件，path 为空时使用 stdout
+func createOutput(path string, stdout io.Writer) (io.Writer, func() error, error) {
+	if path == "" {
+		return stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+func runFetch(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	configPath := fs.String("config", "", "config file, used with -line to pick the redis")
+	line := fs.String("line", "", "business line of the redis to fetch from")
+	index := fs.Int("index", 0, "index of the address in the line's addr list")
+	output := fs.String("o", "dump.rdb", "output file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var fetcher *replica.Fetcher
+	switch {
+	case *line != "" && fs.NArg() == 0:
+		host, err := lineHost(*configPath, *line)
+		if err != nil {
+			return err
+		}
+		if fetcher, err = replica.NewHostFetcher(*host, *index); err != nil {
+			return err
+		}
+	case *line == "" && fs.NArg() == 1:
+		var err error
+		if fetcher, err = urlFetcher(fs.Arg(0)); err != nil {
+			return err
+		}
+	default:
+		return errors.New("usage: redis-monitor rdb fetch [-o dump.rdb] (-config file -line name [-index 0] | redis://[:password@]host:port)")
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := fetcher.Download(f)
+	if err != nil {
+		return fmt.Errorf("fetch from %s: %v", fetcher.Addr, err)
+	}
+	fmt.Fprintf(stdout, "saved %d bytes from %s to %s\n", n, fetcher.Addr, *output)
+	return f.Close()
+}
+
+// urlFetcher 根据 redis://[:password@]host:port 创建 Fetcher
+func urlFetcher(rawurl string) (*replica.Fetcher, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("invalid redis url %q", rawurl)
+	}
+	password, _ := u.User.Password()
+	return replica.NewFetcher(u.Host, password), nil
+}
+
+// lineHost 从配置文件中读取某个业务线的 Redis 配置
+func lineHost(path, line string) (*cfg.RedisHost, error) {
+	var c *cfg.Config
+	if err := cfgfile.Read(&c, path); err != nil {
+		return nil, err
+	}
+	for i := range c.Redis {
+		if c.Redis[i].Line == line {
+			return &c.Redis[i], nil
+		}
+	}
+	return nil, fmt.Errorf("line %q not found in config", line)
+}
+
+// linePatterns 从配置文件中读取某个业务线的 key_patterns
+func linePatterns(path, line string) ([]string, error) {
+	host, err := lineHost(path, line)
+	if err != nil {
+		return nil, err
+	}
+	return host.KeyPatterns, nil
+}
+
+func compilePatterns(exprs []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// parseFile 用 handler 解析一个 RDB 文件，path 为 redis:// 地址时通过复制协议直接从 Redis 拉取
+func (o *options) parseFile(path string, handler rdb.Handler) error {
+	if strings.HasPrefix(path, "redis://") {
+		fetcher, err := urlFetcher(path)
+		if err != nil {
+			return err
+		}
+		if err = fetcher.Parse(handler); err != nil {
+			return fmt.Errorf("parse snapshot of %s: %v", fetcher.Addr, err)
+		}
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := rdb.NewRDB(bufio.NewReader(f), handler)
+	r.Tolerant = o.tolerant
+	err = r.Parse()
+	if err != nil {
+		return fmt.Errorf("parse %s: %v", path, err)
+	}
+	reportErrors(path, r.Errors())
+	return nil
+}
+
+// parseRecords 估算 RDB 中每个 key 的内存并按顺序交给 callback，handler 不为 nil 时还接收 key 以外的事件
+func (o *options) parseRecords(path string, handler rdb.Handler, callback func(*analyzer.MemoryRecord)) error {
+	p := analyzer.NewMemoryPipeline(o.workers, callback)
+	p.Handler = handler
+	estimator := analyzer.NewMemoryEstimator(callback)
+	if handler == nil {
+		return o.parseParallel(path, p, estimator)
+	}
+	return o.parseParallel(path, p, rdb.MultiHandler(handler, estimator))
+}
+
+// parseParallel 在 -workers 大于 1 且 path 为本地文件时用 p 并行解析，否则用 handler 顺序解析
+func (o *options) parseParallel(path string, p *rdb.Pipeline, handler rdb.Handler) error {
+	if o.workers <= 1 || strings.HasPrefix(path, "redis://") {
+		return o.parseFile(path, handler)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p.Tolerant = o.tolerant
+	if err = p.Parse(bufio.NewReader(f)); err != nil {
+		return fmt.Errorf("parse %s: %v", path, err)
+	}
+	reportErrors(path, p.Errors())
+	return nil
+}
+
+// reportSkippedModules 输出没有导出的 module 类型的 key 的个数
+func reportSkippedModules(path string, skipped map[string]uint64) {
+	names := make([]string, 0, len(skipped))
+	for name := range skipped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "%s: %d keys of module %s can not be converted to commands and were skipped\n", path, skipped[name], name)
+	}
+}
+
+// reportErrors 把容错模式下跳过的错误输出到标准错误
+func reportErrors(path string, errs []*rdb.ParseError) {
+	if len(errs) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d errors, the report is partial\n", path, len(errs))
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "  %v\n", e)
+	}
+}
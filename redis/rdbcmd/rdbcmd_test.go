@@ -0,0 +1,99 @@
+package rdbcmd
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"testing"
+)
+
+func dump(name string) string {
+	return path.Join("..", "rdb", "dumps", name)
+}
+
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		args  []string
+		want  []string // 输出中必须包含的行，exact 为 true 时为完整的输出
+		exact bool
+	}{
+		{
+			name: "bigkeys",
+			args: []string{"bigkeys", "-n", "2", dump("dump.rdb")},
+			want: []string{
+				"keys  expiring keys  total bytes\n3     1              256\n",
+				"# Top 2 keys by memory\n" +
+					"db  type    key         bytes  elements  largest element  encoding\n" +
+					"0   hash    \"hset_key\"  96     1         5                ziplist\n" +
+					"0   string  \"key\"       96     1         8                string\n",
+			},
+		},
+		{
+			name: "json",
+			args: []string{"json", dump("dump.rdb")},
+			want: []string{
+				`{"db":0,"key":"key","type":"string","encoding":"string","expiry":1574093693054,"value":"123"}` + "\n" +
+					`{"db":0,"key":"hello","type":"string","encoding":"string","expiry":0,"value":"world"}` + "\n" +
+					`{"db":0,"key":"hset_key","type":"hash","encoding":"ziplist","expiry":0,"value":{"field":"value"}}` + "\n",
+			},
+			exact: true,
+		},
+		{
+			name: "json filter",
+			args: []string{"json", "-key", "key*", dump("dump-lru.rdb")},
+			want: []string{
+				`{"db":0,"key":"key1","type":"string","encoding":"string","expiry":0,"idle":1914611,"value":"value1"}` + "\n" +
+					`{"db":0,"key":"key","type":"string","encoding":"string","expiry":1528592665231,"idle":4,"value":"value"}` + "\n",
+			},
+			exact: true,
+		},
+		{
+			name: "resp",
+			args: []string{"resp", dump("dump.rdb")},
+			want: []string{
+				"*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n" +
+					"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$3\r\n123\r\n" +
+					"*3\r\n$9\r\nPEXPIREAT\r\n$3\r\nkey\r\n$13\r\n1574093693054\r\n" +
+					"*3\r\n$3\r\nSET\r\n$5\r\nhello\r\n$5\r\nworld\r\n" +
+					"*2\r\n$3\r\nDEL\r\n$8\r\nhset_key\r\n" +
+					"*4\r\n$4\r\nHSET\r\n$8\r\nhset_key\r\n$5\r\nfield\r\n$5\r\nvalue\r\n",
+			},
+			exact: true,
+		},
+	} {
+		// 全局参数只影响解析方式，输出必须相同
+		for _, global := range [][]string{nil, {"-workers", "4"}, {"-tolerant", "-workers", "2"}} {
+			var out bytes.Buffer
+			if err := Run(append(append([]string(nil), global...), tc.args...), &out); err != nil {
+				t.Fatalf("%s %v: %v", tc.name, global, err)
+			}
+			got := out.String()
+			if tc.exact {
+				if got != tc.want[0] {
+					t.Fatalf("%s %v: got %q, want %q", tc.name, global, got, tc.want[0])
+				}
+				continue
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Fatalf("%s %v: output does not contain %q:\n%s", tc.name, global, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"bigkeys"},
+		{"-workers"},
+		{"json", dump("missing.rdb")},
+	} {
+		if err := Run(args, &bytes.Buffer{}); err == nil {
+			t.Fatalf("%v: expected an error", args)
+		}
+	}
+}
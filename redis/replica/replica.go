@@ -0,0 +1,249 @@
+package replica
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	cfg "github.com/ssp4599815/monitors/redis/config"
+	"github.com/ssp4599815/monitors/redis/rdb"
+	"github.com/ssp4599815/monitors/redis/resp"
+)
+
+// eofMarkLen 是无盘复制时标记 RDB 结束的随机字符串长度
+const eofMarkLen = 40
+
+// Fetcher 伪装成一个从库，通过复制协议从 Redis 拉取 RDB 快照。
+//
+// 依次发送 AUTH、REPLCONF 和 PSYNC ? -1，老版本不支持 PSYNC 时退回到 SYNC。主库返回的 RDB 有两种格式：
+//
+//	$<len>\r\n<payload>              先生成 RDB 文件再发送
+//	$EOF:<40 字节标记>\r\n<payload><标记>  无盘复制，长度未知，以标记结束
+//
+// 在主库生成 RDB 期间会每秒发送一个 \n 作为心跳。
+type Fetcher struct {
+	Addr     string
+	Password string
+	// Timeout 为连接和每次读写的超时，主库生成 RDB 期间有心跳，不会因为 RDB 大而超时
+	Timeout time.Duration
+	// ListeningPort 为 REPLCONF listening-port 上报的端口，只用于在主库的 INFO replication 中展示
+	ListeningPort int
+}
+
+func NewFetcher(addr, password string) *Fetcher {
+	return &Fetcher{Addr: addr, Password: password, Timeout: time.Minute}
+}
+
+// NewHostFetcher 使用配置文件中的 Redis 地址和密码，index 为 Addr 中的第几个地址
+func NewHostFetcher(host cfg.RedisHost, index int) (*Fetcher, error) {
+	if index < 0 || index >= len(host.Addr) {
+		return nil, fmt.Errorf("line %q has %d addresses, no address at index %d", host.Line, len(host.Addr), index)
+	}
+	return NewFetcher(host.Addr[index], host.Password), nil
+}
+
+// Parse 拉取 RDB 并直接交给 handler 解析，不落盘
+func (f *Fetcher) Parse(handler rdb.Handler) error {
+	return f.fetch(func(payload io.Reader) error {
+		err := rdb.NewRDB(bufio.NewReader(payload), handler).Parse()
+		if err != nil {
+			return err
+		}
+		// RDB 结束后可能还有 $<len> 中未读完的数据或无盘复制的结束标记
+		_, err = io.Copy(ioutil.Discard, payload)
+		return err
+	})
+}
+
+// Download 拉取 RDB 并写入 w，返回写入的字节数
+func (f *Fetcher) Download(w io.Writer) (n int64, err error) {
+	err = f.fetch(func(payload io.Reader) error {
+		n, err = io.Copy(w, payload)
+		return err
+	})
+	return
+}
+
+// fetch 完成复制握手，把 RDB 的内容交给 consume
+func (f *Fetcher) fetch(consume func(payload io.Reader) error) error {
+	conn, err := resp.Dial(f.Addr, f.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err = conn.Auth(f.Password); err != nil {
+		return fmt.Errorf("auth %s: %v", f.Addr, err)
+	}
+	if f.ListeningPort > 0 {
+		if _, err = conn.Do("REPLCONF", "listening-port", strconv.Itoa(f.ListeningPort)); err != nil {
+			log.Warnf("REPLCONF listening-port: %v", err)
+		}
+	}
+
+	// 老版本不认识 REPLCONF capa 时不影响 SYNC，只能拿到 $<len> 格式
+	if _, err = conn.Do("REPLCONF", "capa", "eof", "capa", "psync2"); err != nil && !isRecoverable(err) {
+		return err
+	}
+
+	if err = f.sync(conn); err != nil {
+		return err
+	}
+	payload, err := readPayloadHeader(conn)
+	if err != nil {
+		return err
+	}
+	return consume(&deadlineReader{r: payload, conn: conn})
+}
+
+// sync 发送 PSYNC ? -1，不支持时退回到 SYNC
+func (f *Fetcher) sync(conn *resp.Conn) error {
+	if err := conn.Send("PSYNC", "?", "-1"); err != nil {
+		return err
+	}
+	line, err := readLine(conn)
+	if err != nil {
+		return err
+	}
+	switch {
+	case bytes.HasPrefix(line, []byte("+FULLRESYNC")):
+		log.Infof("%s: %s", f.Addr, line[1:])
+		return nil
+	case bytes.HasPrefix(line, []byte("-")) && resp.IsUnknownCommand(resp.Error(line[1:])):
+		log.Infof("%s does not support PSYNC, fallback to SYNC", f.Addr)
+		return conn.Send("SYNC")
+	case bytes.HasPrefix(line, []byte("-")):
+		return resp.Error(line[1:])
+	}
+	return fmt.Errorf("unexpected PSYNC reply %q", line)
+}
+
+// readPayloadHeader 读取 $<len> 或 $EOF:<mark>，返回只包含 RDB 内容的 Reader
+func readPayloadHeader(conn *resp.Conn) (io.Reader, error) {
+	line, err := readLine(conn)
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '-':
+		return nil, resp.Error(line[1:])
+	case '$':
+	default:
+		return nil, fmt.Errorf("unexpected sync payload header %q", line)
+	}
+	header := string(line[1:])
+
+	if strings.HasPrefix(header, "EOF:") {
+		mark := []byte(header[4:])
+		if len(mark) != eofMarkLen {
+			return nil, fmt.Errorf("invalid EOF mark %q", mark)
+		}
+		return &eofMarkReader{r: conn.BufReader(), mark: mark}, nil
+	}
+	length, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid payload length %q", header)
+	}
+	return &exactReader{r: io.LimitReader(conn.BufReader(), length)}, nil
+}
+
+// readLine 读取一行，跳过主库生成 RDB 期间发送的 \n 心跳
+func readLine(conn *resp.Conn) ([]byte, error) {
+	for {
+		line, err := conn.BufReader().ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			return line, nil
+		}
+		if conn.Timeout > 0 {
+			conn.SetDeadline(time.Now().Add(conn.Timeout))
+		}
+	}
+}
+
+func isRecoverable(err error) bool {
+	_, ok := err.(resp.Error)
+	return ok
+}
+
+// eofMarkReader 读取无盘复制的 RDB，遇到结束标记时返回 io.EOF。
+// 和 Redis 一样只在每次读取的末尾检查标记，主库在从库回复 ACK 之前不会发送后续数据
+type eofMarkReader struct {
+	r       io.Reader
+	mark    []byte
+	pending []byte
+	buf     []byte
+	done    bool
+}
+
+func (e *eofMarkReader) Read(p []byte) (int, error) {
+	for {
+		// 保留最后 len(mark) 个字节，其余的可以返回
+		keep := len(e.mark)
+		if e.done {
+			keep = 0
+		}
+		if len(e.pending) > keep {
+			n := copy(p, e.pending[:len(e.pending)-keep])
+			e.pending = e.pending[n:]
+			return n, nil
+		}
+		if e.done {
+			return 0, io.EOF
+		}
+
+		if e.buf == nil {
+			e.buf = make([]byte, 32*1024)
+		}
+		n, err := e.r.Read(e.buf)
+		e.pending = append(e.pending, e.buf[:n]...)
+		if bytes.HasSuffix(e.pending, e.mark) {
+			e.pending = e.pending[:len(e.pending)-len(e.mark)]
+			e.done = true
+			continue
+		}
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// exactReader 在 $<len> 的数据没有读完就断开时返回 io.ErrUnexpectedEOF
+type exactReader struct {
+	r io.Reader
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		if l, ok := e.r.(*io.LimitedReader); ok && l.N > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+// deadlineReader 在每次读取前延长连接的超时时间
+type deadlineReader struct {
+	r    io.Reader
+	conn *resp.Conn
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	if d.conn.Timeout > 0 {
+		d.conn.SetDeadline(time.Now().Add(d.conn.Timeout))
+	}
+	return d.r.Read(p)
+}
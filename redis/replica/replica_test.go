@@ -0,0 +1,179 @@
+package replica
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ssp4599815/monitors/redis/rdb"
+	"github.com/ssp4599815/monitors/redis/resp"
+)
+
+const testMark = "0123456789abcdef0123456789abcdef01234567"
+
+// fakeMaster 是一个只支持全量同步的 Redis 主库，把 payload 作为 RDB 发送给第一个连接
+type fakeMaster struct {
+	ln       net.Listener
+	password string
+	psync    bool // 是否支持 PSYNC
+	diskless bool // 是否以 $EOF:<mark> 的格式发送
+	payload  []byte
+	commands []string
+	done     chan error
+}
+
+func startFakeMaster(t *testing.T, m *fakeMaster) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ln = ln
+	m.done = make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			m.done <- err
+			return
+		}
+		defer conn.Close()
+		m.done <- m.serve(conn)
+	}()
+	return ln.Addr().String()
+}
+
+func (m *fakeMaster) serve(conn net.Conn) error {
+	r := resp.NewReader(bufio.NewReader(conn))
+	authed := m.password == ""
+	for {
+		reply, err := r.ReadReply()
+		if err != nil {
+			return err
+		}
+		args, _ := reply.([]interface{})
+		var parts []string
+		for _, arg := range args {
+			parts = append(parts, string(arg.([]byte)))
+		}
+		cmd := strings.ToUpper(parts[0])
+		m.commands = append(m.commands, cmd)
+
+		switch {
+		case cmd == "AUTH":
+			if parts[1] != m.password {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authed = true
+			fmt.Fprint(conn, "+OK\r\n")
+		case !authed:
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+		case cmd == "REPLCONF":
+			fmt.Fprint(conn, "+OK\r\n")
+		case cmd == "PSYNC" && !m.psync:
+			fmt.Fprint(conn, "-ERR unknown command 'PSYNC'\r\n")
+		case cmd == "PSYNC", cmd == "SYNC":
+			if cmd == "PSYNC" {
+				fmt.Fprint(conn, "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n")
+			}
+			// 生成 RDB 期间的心跳
+			fmt.Fprint(conn, "\n\n")
+			if m.diskless {
+				fmt.Fprintf(conn, "$EOF:%s\r\n", testMark)
+				conn.Write(m.payload)
+				conn.Write([]byte(testMark))
+			} else {
+				fmt.Fprintf(conn, "$%d\r\n", len(m.payload))
+				conn.Write(m.payload)
+			}
+			return nil
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", parts[0])
+		}
+	}
+}
+
+type keysHandler struct {
+	rdb.NopHandler
+	keys []string
+}
+
+func (h *keysHandler) Set(key, value []byte, info *rdb.KeyInfo) {
+	h.keys = append(h.keys, string(key))
+}
+func (h *keysHandler) StartHash(key []byte, length int64, info *rdb.KeyInfo) {
+	h.keys = append(h.keys, string(key))
+}
+
+func TestFetcher(t *testing.T) {
+	payload, err := ioutil.ReadFile(path.Join("..", "rdb", "dumps", "dump.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		psync    bool
+		diskless bool
+		commands string
+	}{
+		{"psync", true, false, "AUTH REPLCONF PSYNC"},
+		{"diskless", true, true, "AUTH REPLCONF PSYNC"},
+		{"sync", false, false, "AUTH REPLCONF PSYNC SYNC"},
+	}
+	for _, c := range cases {
+		m := &fakeMaster{password: "secret", psync: c.psync, diskless: c.diskless, payload: payload}
+		addr := startFakeMaster(t, m)
+
+		h := &keysHandler{}
+		if err := NewFetcher(addr, "secret").Parse(h); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		m.ln.Close()
+		if err := <-m.done; err != nil {
+			t.Fatalf("%s: fake master: %v", c.name, err)
+		}
+		if strings.Join(h.keys, " ") != "key hello hset_key" {
+			t.Fatalf("%s: unexpected keys %v", c.name, h.keys)
+		}
+		if strings.Join(m.commands, " ") != c.commands {
+			t.Fatalf("%s: unexpected commands %v", c.name, m.commands)
+		}
+	}
+}
+
+func TestFetcherDownload(t *testing.T) {
+	payload, err := ioutil.ReadFile(path.Join("..", "rdb", "dumps", "dump.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, diskless := range []bool{false, true} {
+		m := &fakeMaster{psync: true, diskless: diskless, payload: payload}
+		addr := startFakeMaster(t, m)
+
+		var buf bytes.Buffer
+		n, err := NewFetcher(addr, "").Download(&buf)
+		m.ln.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(payload)) || !bytes.Equal(buf.Bytes(), payload) {
+			t.Fatalf("diskless=%v: downloaded %d bytes, want %d", diskless, n, len(payload))
+		}
+	}
+}
+
+func TestFetcherAuthError(t *testing.T) {
+	m := &fakeMaster{password: "secret", psync: true}
+	addr := startFakeMaster(t, m)
+	defer m.ln.Close()
+
+	err := NewFetcher(addr, "wrong").Parse(&keysHandler{})
+	if err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Fatalf("expected auth error, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package resp
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+)
+
+// Conn 是一个简单的 Redis 连接，按请求、响应的方式执行命令
+type Conn struct {
+	// Timeout 为每条命令的读写超时，0 表示不超时
+	Timeout time.Duration
+
+	conn net.Conn
+	rd   *bufio.Reader
+	r    *Reader
+	w    *Writer
+}
+
+// Dial 连接 Redis，timeout 同时作为连接超时和命令超时
+func Dial(addr string, timeout time.Duration) (*Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	rd := bufio.NewReader(conn)
+	return &Conn{
+		Timeout: timeout,
+		conn:    conn,
+		rd:      rd,
+		r:       NewReader(rd),
+		w:       NewWriter(conn),
+	}, nil
+}
+
+// Do 执行一条命令并返回回复，错误回复以 Error 类型的 error 返回
+func (c *Conn) Do(args ...string) (interface{}, error) {
+	if err := c.Send(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.r.ReadReply()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// Send 发送一条命令，不读取回复
+func (c *Conn) Send(args ...string) error {
+	c.extendDeadline()
+	if err := c.w.WriteStrings(args...); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// Auth 在 password 不为空时执行 AUTH
+func (c *Conn) Auth(password string) error {
+	if password == "" {
+		return nil
+	}
+	_, err := c.Do("AUTH", password)
+	return err
+}
+
+// Reader 返回连接的 Reader，用于读取非请求响应式的数据
+func (c *Conn) Reader() *Reader {
+	return c.r
+}
+
+// BufReader 返回连接底层的 bufio.Reader，用于直接读取原始数据
+func (c *Conn) BufReader() *bufio.Reader {
+	return c.rd
+}
+
+// SetDeadline 设置连接的读写截止时间，零值表示不超时
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) extendDeadline() {
+	if c.Timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+}
+
+// IsUnknownCommand 判断 err 是否为服务端不支持该命令
+func IsUnknownCommand(err error) bool {
+	e, ok := err.(Error)
+	return ok && strings.HasPrefix(string(e), "ERR unknown command")
+}
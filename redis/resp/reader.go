@@ -0,0 +1,90 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Error 是服务端返回的错误回复，例如 "-ERR unknown command"
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+var errProtocol = errors.New("resp: invalid reply")
+
+// Reader 读取 RESP 协议的回复
+type Reader struct {
+	rd *bufio.Reader
+}
+
+func NewReader(rd *bufio.Reader) *Reader {
+	return &Reader{rd: rd}
+}
+
+// ReadLine 读取一行，不包含结尾的 \r\n
+func (r *Reader) ReadLine() ([]byte, error) {
+	line, err := r.rd.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, errProtocol
+		}
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// ReadReply 读取一个回复：简单字符串返回 string，错误返回 Error，整数返回 int64，
+// bulk string 返回 []byte（null 为 nil），数组返回 []interface{}（null 为 nil）
+func (r *Reader) ReadReply() (interface{}, error) {
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r.rd, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = r.ReadReply(); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	}
+	return nil, fmt.Errorf("resp: unknown reply type %q", line[0])
+}
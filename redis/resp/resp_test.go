@@ -0,0 +1,38 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteStrings("SET", "k", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n" {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	in := "+OK\r\n-ERR bad\r\n:42\r\n$-1\r\n*2\r\n$3\r\nfoo\r\n*1\r\n:1\r\n"
+	r := NewReader(bufio.NewReader(strings.NewReader(in)))
+	want := []interface{}{"OK", Error("ERR bad"), int64(42), nil, []interface{}{[]byte("foo"), []interface{}{int64(1)}}}
+	for _, w := range want {
+		got, err := r.ReadReply()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Fatalf("got %#v, want %#v", got, w)
+		}
+	}
+}
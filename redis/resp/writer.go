@@ -0,0 +1,50 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// Writer 把命令编码为 RESP 协议的数组，例如 SET k v 编码为
+//
+//	*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WriteCommand 写入一条命令
+func (w *Writer) WriteCommand(args ...[]byte) error {
+	w.writeHeader('*', len(args))
+	for _, arg := range args {
+		w.writeHeader('$', len(arg))
+		w.w.Write(arg)
+		w.w.WriteString("\r\n")
+	}
+	// bufio.Writer 出错后会一直返回同一个错误，这里只需要检查一次
+	_, err := w.w.Write(nil)
+	return err
+}
+
+// WriteStrings 以字符串参数写入一条命令
+func (w *Writer) WriteStrings(args ...string) error {
+	bs := make([][]byte, len(args))
+	for i, arg := range args {
+		bs[i] = []byte(arg)
+	}
+	return w.WriteCommand(bs...)
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) writeHeader(prefix byte, n int) {
+	w.w.WriteByte(prefix)
+	w.w.WriteString(strconv.Itoa(n))
+	w.w.WriteString("\r\n")
+}
@@ -0,0 +1,213 @@
+package slowlog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssp4599815/monitors/libmonitor/alert"
+	cfg "github.com/ssp4599815/monitors/redis/config"
+)
+
+// 慢日志报警的规则名
+const (
+	RuleSlowPerMinute    = "slow_per_minute"
+	RuleMaxDuration      = "max_duration"
+	RuleDangerousCommand = "dangerous_command"
+)
+
+// DefaultSuppress 是 AlertRules.Suppress 为 0 时使用的报警抑制时间
+const DefaultSuppress = 10 * time.Minute
+
+// Alerter 按主机所属业务线的报警规则检查每个窗口内的慢日志。
+//
+// 每分钟的慢日志条数跨窗口累计，一分钟的慢日志分散在多个窗口中时也能发现，
+// 同一台主机的同一分钟只报警一次。max_duration 和 dangerous_command 按慢日志的时间抑制：
+// 同一台主机上同一个指纹的同一条规则报警之后，Suppress 时间内不再报警。
+type Alerter struct {
+	Config *cfg.Config
+
+	mu      sync.Mutex
+	minutes map[string]*hostMinutes // 主机 -> 最近两分钟的慢日志条数
+	fired   map[firedKey]time.Time  // 报警之后抑制到什么时间（按慢日志的时间）
+}
+
+// firedKey 是报警抑制的范围
+type firedKey struct {
+	host, fingerprint, rule string
+}
+
+// hostMinutes 是一台主机最近的每分钟慢日志条数，比 latest 早一分钟以上的分钟已经结束，会被删除
+type hostMinutes struct {
+	latest time.Time
+	counts map[time.Time]*minuteCount
+}
+
+type minuteCount struct {
+	n     int
+	fired bool
+}
+
+func NewAlerter(config *cfg.Config) *Alerter {
+	if config == nil {
+		config = &cfg.Config{}
+	}
+	return &Alerter{Config: config, minutes: make(map[string]*hostMinutes), fired: make(map[firedKey]time.Time)}
+}
+
+// Check 检查一个窗口内的慢日志，返回触发的报警。
+// 同一个窗口内同一台主机上同一个指纹的同一条规则只报警一次；跨窗口时 slow_per_minute 每台主机每分钟只报警一次，
+// max_duration 和 dangerous_command 每台主机每个指纹在 Suppress 时间内只报警一次
+func (a *Alerter) Check(slowlogs []*Slowlog) []*alert.AlertEvent {
+	hosts := make(map[string][]*Slowlog)
+	var order []string
+	for _, s := range slowlogs {
+		if _, ok := hosts[s.Hostname]; !ok {
+			order = append(order, s.Hostname)
+		}
+		hosts[s.Hostname] = append(hosts[s.Hostname], s)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// 删除抑制时间已经过去的记录
+	var latest time.Time
+	for _, s := range slowlogs {
+		if s.Timestamp.After(latest) {
+			latest = s.Timestamp
+		}
+	}
+	for k, until := range a.fired {
+		if !latest.Before(until) {
+			delete(a.fired, k)
+		}
+	}
+	var events []*alert.AlertEvent
+	for _, host := range order {
+		line := a.Config.LineOf(host)
+		events = append(events, a.checkHost(a.Config.RulesFor(line), line, host, hosts[host])...)
+	}
+	return events
+}
+
+func (a *Alerter) checkHost(rules cfg.AlertRules, line, host string, slowlogs []*Slowlog) []*alert.AlertEvent {
+	var events []*alert.AlertEvent
+	newEvent := func(rule, level, subject string) *alert.AlertEvent {
+		e := &alert.AlertEvent{Rule: rule, Level: level, Line: line, Host: host, Subject: subject}
+		events = append(events, e)
+		return e
+	}
+
+	if rules.SlowPerMinute > 0 {
+		for _, minute := range a.countMinutes(host, slowlogs) {
+			c := a.minutes[host].counts[minute]
+			if c.fired || c.n <= rules.SlowPerMinute {
+				continue
+			}
+			c.fired = true
+			e := newEvent(RuleSlowPerMinute, alert.LevelWarning, minute.Format("2006-01-02 15:04"))
+			e.Value, e.Threshold, e.Time = float64(c.n), float64(rules.SlowPerMinute), minute
+			e.Message = fmt.Sprintf("%s 有 %d 条慢日志，超过了每分钟 %d 条", e.Subject, c.n, rules.SlowPerMinute)
+		}
+	}
+
+	suppress := rules.Suppress
+	if suppress <= 0 {
+		suppress = DefaultSuppress
+	}
+	for _, d := range Aggregate(slowlogs) {
+		if rules.MaxDuration > 0 && d.Max > rules.MaxDuration && a.fire(host, d, RuleMaxDuration, suppress) {
+			e := newEvent(RuleMaxDuration, alert.LevelWarning, d.Fingerprint)
+			e.Value, e.Threshold, e.Time = ms(d.Max), ms(rules.MaxDuration), d.Sample.Timestamp
+			e.Message = fmt.Sprintf("%s 耗时 %s，超过了 %s，共 %d 条，示例：%s",
+				d.Fingerprint, d.Max, rules.MaxDuration, countOver(slowlogs, d.Fingerprint, rules.MaxDuration), sample(d.Sample))
+		}
+
+		cmd := strings.ToUpper(d.Sample.Redis.Cmd)
+		for _, dc := range rules.DangerousCommands {
+			if strings.ToUpper(dc.Cmd) != cmd || d.Max < dc.MinDuration {
+				continue
+			}
+			if !a.fire(host, d, RuleDangerousCommand, suppress) {
+				break
+			}
+			e := newEvent(RuleDangerousCommand, alert.LevelCritical, d.Fingerprint)
+			e.Value, e.Threshold, e.Time = float64(d.Count), ms(dc.MinDuration), d.Last
+			e.Message = fmt.Sprintf("执行了危险命令 %s %d 次，最长耗时 %s，示例：%s", d.Fingerprint, d.Count, d.Max, sample(d.Sample))
+			break
+		}
+	}
+	return events
+}
+
+// fire 判断 host 上 d 的指纹能否触发 rule 的报警，还在上次报警的抑制时间内时不报警
+func (a *Alerter) fire(host string, d *Digest, rule string, suppress time.Duration) bool {
+	key := firedKey{host: host, fingerprint: d.Fingerprint, rule: rule}
+	if until, ok := a.fired[key]; ok && d.Last.Before(until) {
+		return false
+	}
+	a.fired[key] = d.Last.Add(suppress)
+	return true
+}
+
+// countMinutes 把 host 的慢日志累加到每分钟的条数中，返回这一批涉及到的分钟。
+// 已经结束的分钟被删除，之后才到达的这些分钟的慢日志不再计数
+func (a *Alerter) countMinutes(host string, slowlogs []*Slowlog) []time.Time {
+	m, ok := a.minutes[host]
+	if !ok {
+		m = &hostMinutes{counts: make(map[time.Time]*minuteCount)}
+		a.minutes[host] = m
+	}
+	for _, s := range slowlogs {
+		if minute := s.Timestamp.Truncate(time.Minute); minute.After(m.latest) {
+			m.latest = minute
+		}
+	}
+	for minute := range m.counts {
+		if m.latest.Sub(minute) > time.Minute {
+			delete(m.counts, minute)
+		}
+	}
+
+	var order []time.Time
+	seen := make(map[time.Time]bool)
+	for _, s := range slowlogs {
+		minute := s.Timestamp.Truncate(time.Minute)
+		if m.latest.Sub(minute) > time.Minute {
+			continue
+		}
+		c, ok := m.counts[minute]
+		if !ok {
+			c = &minuteCount{}
+			m.counts[minute] = c
+		}
+		c.n++
+		if !seen[minute] {
+			seen[minute] = true
+			order = append(order, minute)
+		}
+	}
+	return order
+}
+
+// countOver 返回指纹为 fp 且耗时超过 limit 的慢日志条数
+func countOver(slowlogs []*Slowlog, fp string, limit time.Duration) int {
+	n := 0
+	for _, s := range slowlogs {
+		if s.DurationTime() > limit && Fingerprint(s) == fp {
+			n++
+		}
+	}
+	return n
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// sample 把慢日志格式化为一条命令，太长时截断
+func sample(s *Slowlog) string {
+	cmd := strings.TrimSpace(strings.Join(append([]string{s.Redis.Cmd, s.Redis.Key}, s.Redis.Args...), " "))
+	return fmt.Sprintf("%.80q", cmd)
+}
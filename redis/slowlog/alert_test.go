@@ -0,0 +1,152 @@
+package slowlog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ssp4599815/monitors/libmonitor/alert"
+	cfg "github.com/ssp4599815/monitors/redis/config"
+	"gopkg.in/yaml.v2"
+)
+
+const alertConfig = `
+redis:
+  - line: "order"
+    addr:
+      - "10.0.0.1:6379"
+    hosts:
+      - "redis-order-1"
+    alert:
+      slow_per_minute: 3
+      max_duration: 50ms
+  - line: "user"
+    addr:
+      - "10.0.0.2:6379"
+alert:
+  slow_per_minute: 10
+  max_duration: 100ms
+  dangerous_commands:
+    - cmd: "keys"
+    - cmd: "HGETALL"
+      min_duration: 10ms
+`
+
+func TestAlerter(t *testing.T) {
+	var config cfg.Config
+	if err := yaml.Unmarshal([]byte(alertConfig), &config); err != nil {
+		t.Fatal(err)
+	}
+	if rules := config.RulesFor("order"); rules.SlowPerMinute != 3 || rules.MaxDuration != 50*time.Millisecond || len(rules.DangerousCommands) != 2 {
+		t.Fatalf("unexpected rules for order: %+v", rules)
+	}
+	if rules := config.RulesFor("user"); rules.SlowPerMinute != 10 || rules.DangerousCommands[1].MinDuration != 10*time.Millisecond {
+		t.Fatalf("unexpected rules for user: %+v", rules)
+	}
+	if config.LineOf("10.0.0.1") != "order" || config.LineOf("10.0.0.2:6379") != "user" || config.LineOf("redis-9") != "" ||
+		config.LineOf("REDIS-ORDER-1") != "order" {
+		t.Fatal("unexpected lines")
+	}
+
+	at := func(s *Slowlog, sec int) *Slowlog {
+		s.Timestamp = time.Date(2020, 3, 1, 10, 0, sec, 0, time.UTC)
+		return s
+	}
+	var slowlogs []*Slowlog
+	// order 业务线：一分钟 4 条，超过了 3 条；有一条 60ms 的 GET
+	for i := 0; i < 4; i++ {
+		slowlogs = append(slowlogs, at(newSlowlog("10.0.0.1", "GET", fmt.Sprintf("order:%d", i), int64(20000+i*20000)), i))
+	}
+	// user 业务线：60ms 没有超过默认的 100ms，HGETALL 5ms 不报警，KEYS 报警
+	slowlogs = append(slowlogs,
+		at(newSlowlog("10.0.0.2", "GET", "user:1", 60000), 1),
+		at(newSlowlog("10.0.0.2", "HGETALL", "user:1:tags", 5000), 2),
+		at(newSlowlog("10.0.0.2", "keys", "user:*", 20000), 3),
+	)
+	// Filebeat 上报的主机名通过 hosts 找到 order 业务线，使用 50ms 的阈值
+	slowlogs = append(slowlogs, at(newSlowlog("redis-order-1", "SET", "order:9", 70000), 5))
+	// 不属于任何业务线的主机使用全局规则
+	slowlogs = append(slowlogs, at(newSlowlog("redis-9", "HGETALL", "big:1", 15000), 4))
+
+	events := NewAlerter(&config).Check(slowlogs)
+	var got []string
+	for _, e := range events {
+		got = append(got, fmt.Sprintf("%s %s %s %s %v", e.Line, e.Host, e.Rule, e.Subject, e.Value))
+	}
+	want := []string{
+		"order 10.0.0.1 slow_per_minute 2020-03-01 10:00 4",
+		"order 10.0.0.1 max_duration GET order:{id} 80",
+		"user 10.0.0.2 dangerous_command KEYS user:* 1",
+		"order redis-order-1 max_duration SET order:{id} 70",
+		" redis-9 dangerous_command HGETALL big:{id} 1",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("unexpected events:\n%q\nwant:\n%q", got, want)
+	}
+	if events[2].Level != alert.LevelCritical || events[1].Threshold != 50 || events[1].Message == "" {
+		t.Fatalf("unexpected event %+v %+v", events[1], events[2])
+	}
+}
+
+func TestAlerterSlowPerMinute(t *testing.T) {
+	config := &cfg.Config{Alert: cfg.AlertRules{SlowPerMinute: 3}}
+	a := NewAlerter(config)
+	at := func(min, sec int) *Slowlog {
+		s := newSlowlog("redis-1", "GET", "k", 100)
+		s.Timestamp = time.Date(2020, 3, 1, 10, min, sec, 0, time.UTC)
+		return s
+	}
+	check := func(step string, want int, slowlogs ...*Slowlog) {
+		t.Helper()
+		events := a.Check(slowlogs)
+		if len(events) != want {
+			t.Fatalf("%s: got %d events, want %d: %v", step, len(events), want, events)
+		}
+	}
+
+	// 10:00 的 4 条慢日志分在两个窗口中，第二个窗口累计超过 3 条时报警
+	check("first batch", 0, at(0, 1), at(0, 2))
+	check("second batch", 1, at(0, 3), at(0, 4))
+	// 同一分钟只报警一次
+	check("same minute", 0, at(0, 5))
+	// 10:02 开始后 10:00 已经结束，迟到的慢日志不再计数
+	check("next minutes", 0, at(2, 1), at(0, 58), at(0, 59))
+	if m := a.minutes["redis-1"]; len(m.counts) != 1 {
+		t.Fatalf("closed minutes were not pruned: %v", m.counts)
+	}
+	check("new minute", 1, at(2, 2), at(2, 3), at(2, 4))
+}
+
+func TestAlerterSuppress(t *testing.T) {
+	config := &cfg.Config{Alert: cfg.AlertRules{
+		MaxDuration:       10 * time.Millisecond,
+		DangerousCommands: []cfg.DangerousCommand{{Cmd: "KEYS"}},
+		Suppress:          5 * time.Minute,
+	}}
+	a := NewAlerter(config)
+	at := func(host, cmd string, min int) *Slowlog {
+		s := newSlowlog(host, cmd, "user:1", 20000)
+		s.Timestamp = time.Date(2020, 3, 1, 10, min, 0, 0, time.UTC)
+		return s
+	}
+	check := func(step string, want string, slowlogs ...*Slowlog) {
+		t.Helper()
+		var got []string
+		for _, e := range a.Check(slowlogs) {
+			got = append(got, e.Host+" "+e.Rule)
+		}
+		if fmt.Sprint(got) != want {
+			t.Fatalf("%s: got %v, want %s", step, got, want)
+		}
+	}
+
+	check("first", "[redis-1 max_duration redis-1 dangerous_command]", at("redis-1", "KEYS", 0))
+	// 抑制时间内同一台主机的同一个指纹不再报警，其它主机和其它指纹不受影响
+	check("suppressed", "[redis-2 max_duration]", at("redis-1", "KEYS", 1), at("redis-1", "KEYS", 4), at("redis-2", "GET", 4))
+	check("other fingerprint", "[redis-1 max_duration]", at("redis-1", "GET", 4))
+	// 过了抑制时间之后再次报警，过期的记录被删除
+	check("expired", "[redis-1 max_duration redis-1 dangerous_command]", at("redis-1", "KEYS", 9))
+	if len(a.fired) != 2 {
+		t.Fatalf("expired suppressions were not pruned: %v", a.fired)
+	}
+}
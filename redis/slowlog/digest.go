@@ -0,0 +1,108 @@
+package slowlog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Digest 是一个窗口内同一指纹的慢日志汇总
+type Digest struct {
+	Fingerprint string
+	Count       int
+	Total       time.Duration
+	Avg         time.Duration
+	P50         time.Duration
+	P95         time.Duration
+	P99         time.Duration
+	Max         time.Duration
+	Hosts       []string  // 出现过这个指纹的主机，按名字排序
+	First       time.Time // 窗口内最早的一条慢日志的时间
+	Last        time.Time // 窗口内最晚的一条慢日志的时间
+	Sample      *Slowlog  // 耗时最长的一条慢日志
+}
+
+// Aggregate 按指纹汇总一个窗口内的慢日志，结果按总耗时从大到小排序
+func Aggregate(slowlogs []*Slowlog) []*Digest {
+	groups := make(map[string][]*Slowlog)
+	var fingerprints []string
+	for _, s := range slowlogs {
+		fp := Fingerprint(s)
+		if _, ok := groups[fp]; !ok {
+			fingerprints = append(fingerprints, fp)
+		}
+		groups[fp] = append(groups[fp], s)
+	}
+
+	digests := make([]*Digest, 0, len(groups))
+	for _, fp := range fingerprints {
+		digests = append(digests, digest(fp, groups[fp]))
+	}
+	sort.SliceStable(digests, func(i, j int) bool {
+		if digests[i].Total != digests[j].Total {
+			return digests[i].Total > digests[j].Total
+		}
+		return digests[i].Fingerprint < digests[j].Fingerprint
+	})
+	return digests
+}
+
+func digest(fp string, slowlogs []*Slowlog) *Digest {
+	d := &Digest{Fingerprint: fp, Count: len(slowlogs)}
+	durations := make([]time.Duration, len(slowlogs))
+	hosts := make(map[string]bool)
+	for i, s := range slowlogs {
+		duration := s.DurationTime()
+		durations[i] = duration
+		d.Total += duration
+		if d.Sample == nil || duration > d.Max {
+			d.Max, d.Sample = duration, s
+		}
+		if d.First.IsZero() || s.Timestamp.Before(d.First) {
+			d.First = s.Timestamp
+		}
+		if s.Timestamp.After(d.Last) {
+			d.Last = s.Timestamp
+		}
+		if s.Hostname != "" && !hosts[s.Hostname] {
+			hosts[s.Hostname] = true
+			d.Hosts = append(d.Hosts, s.Hostname)
+		}
+	}
+	sort.Strings(d.Hosts)
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	d.Avg = d.Total / time.Duration(d.Count)
+	d.P50 = percentile(durations, 50)
+	d.P95 = percentile(durations, 95)
+	d.P99 = percentile(durations, 99)
+	return d
+}
+
+// percentile 用 nearest-rank 方法计算已排序的 durations 的第 p 百分位数
+func percentile(durations []time.Duration, p int) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	rank := (p*len(durations) + 99) / 100 // ceil(p/100*n)
+	if rank < 1 {
+		rank = 1
+	}
+	return durations[rank-1]
+}
+
+// Report 以文本表格的形式输出前 n 个指纹，n <= 0 时输出全部
+func Report(out io.Writer, digests []*Digest, n int) error {
+	if n > 0 && len(digests) > n {
+		digests = digests[:n]
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "fingerprint\tcount\ttotal\tavg\tp50\tp95\tp99\tmax\thosts\tsample\n")
+	for _, d := range digests {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			d.Fingerprint, d.Count, d.Total, d.Avg, d.P50, d.P95, d.P99, d.Max, len(d.Hosts), sample(d.Sample))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,83 @@
+package slowlog
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSlowlog(host, cmd, key string, us int64, args ...string) *Slowlog {
+	s := &Slowlog{Hostname: host, Timestamp: time.Unix(1583056800+us, 0)}
+	s.Redis.Cmd, s.Redis.Key, s.Redis.Duration, s.Redis.Args = cmd, key, us, args
+	return s
+}
+
+func TestKeyPattern(t *testing.T) {
+	tests := map[string]string{
+		"user:1001:profile":                            "user:{id}:profile",
+		"order_20200301_17":                            "order_{id}_{id}",
+		"session:0f8fad5b-d9cb-469f-a165-70867728950e": "session:{uuid}",
+		"cache:v2:item/42":                             "cache:v2:item/{id}",
+		"feed{123}":                                    "feed{{id}}",
+		"plain":                                        "plain",
+		"7":                                            "{id}",
+		"0F8FAD5B-D9CB-469F-A165-70867728950E:1:shard9": "{uuid}:{id}:shard9",
+	}
+	for key, want := range tests {
+		if got := KeyPattern(key); got != want {
+			t.Errorf("KeyPattern(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	a := newSlowlog("h", "get", "user:1", 10, "ignored")
+	b := newSlowlog("h", "GET", "user:2", 10)
+	if Fingerprint(a) != "GET user:{id}" || Fingerprint(a) != Fingerprint(b) {
+		t.Fatalf("unexpected fingerprints %q %q", Fingerprint(a), Fingerprint(b))
+	}
+	if fp := Fingerprint(newSlowlog("h", "info", "", 10)); fp != "INFO" {
+		t.Fatalf("unexpected fingerprint %q", fp)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	var slowlogs []*Slowlog
+	// GET user:{id} 100 条，耗时 1..100 微秒，分布在两台主机上
+	for i := 1; i <= 100; i++ {
+		slowlogs = append(slowlogs, newSlowlog(fmt.Sprintf("redis-%d", i%2), "GET", fmt.Sprintf("user:%d", i), int64(i), "x"))
+	}
+	slowlogs = append(slowlogs, newSlowlog("redis-9", "KEYS", "*", 50000))
+
+	digests := Aggregate(slowlogs)
+	if len(digests) != 2 {
+		t.Fatalf("expected 2 digests, got %d", len(digests))
+	}
+	keys := digests[0]
+	if keys.Fingerprint != "KEYS *" || keys.Count != 1 || keys.P99 != 50*time.Millisecond || keys.Sample != slowlogs[100] {
+		t.Fatalf("unexpected digest %+v", keys)
+	}
+
+	get := digests[1]
+	if get.Fingerprint != "GET user:{id}" || get.Count != 100 {
+		t.Fatalf("unexpected digest %+v", get)
+	}
+	us := time.Microsecond
+	if get.Total != 5050*us || get.Avg != 50500*time.Nanosecond || get.P50 != 50*us || get.P95 != 95*us || get.P99 != 99*us || get.Max != 100*us {
+		t.Fatalf("unexpected durations %+v", get)
+	}
+	if strings.Join(get.Hosts, ",") != "redis-0,redis-1" || get.Sample.Redis.Key != "user:100" {
+		t.Fatalf("unexpected hosts or sample %v %+v", get.Hosts, get.Sample)
+	}
+	if !get.First.Equal(slowlogs[0].Timestamp) || !get.Last.Equal(slowlogs[99].Timestamp) {
+		t.Fatalf("unexpected window %v - %v", get.First, get.Last)
+	}
+
+	var out bytes.Buffer
+	if err := Report(&out, digests, 1); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "KEYS *") {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+}
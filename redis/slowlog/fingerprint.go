@@ -0,0 +1,42 @@
+package slowlog
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// IDPlaceholder 替换 key 中的数字 id
+	IDPlaceholder = "{id}"
+	// UUIDPlaceholder 替换 key 中的 UUID
+	UUIDPlaceholder = "{uuid}"
+)
+
+var (
+	uuidRe  = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	tokenRe = regexp.MustCompile(`[0-9A-Za-z]+`)
+)
+
+// Fingerprint 返回慢日志归一化后的指纹：大写的命令名加上 key 的模式，参数的值不参与指纹，
+// 例如 "get user:1001:profile" 和 "GET user:2002:profile" 的指纹都是 "GET user:{id}:profile"
+func Fingerprint(s *Slowlog) string {
+	cmd := strings.ToUpper(s.Redis.Cmd)
+	if s.Redis.Key == "" {
+		return cmd
+	}
+	return cmd + " " + KeyPattern(s.Redis.Key)
+}
+
+// KeyPattern 把 key 中的 UUID 替换为 {uuid}，由分隔符隔开的纯数字替换为 {id}，
+// "v2" 这样字母和数字混合的部分保持不变
+func KeyPattern(key string) string {
+	key = uuidRe.ReplaceAllString(key, UUIDPlaceholder)
+	return tokenRe.ReplaceAllStringFunc(key, func(token string) string {
+		for i := 0; i < len(token); i++ {
+			if token[i] < '0' || token[i] > '9' {
+				return token
+			}
+		}
+		return IDPlaceholder
+	})
+}
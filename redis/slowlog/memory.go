@@ -0,0 +1,56 @@
+package slowlog
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// MemorySource 是内存中的 Source，发送通过 Push 加入的慢日志，用于测试或者在程序中直接提交慢日志
+type MemorySource struct {
+	ch    chan *Slowlog
+	acked int64
+}
+
+// NewMemorySource 最多缓存 size 条还没有发送的慢日志
+func NewMemorySource(size int) *MemorySource {
+	return &MemorySource{ch: make(chan *Slowlog, size)}
+}
+
+// Push 加入一条慢日志，缓存已满时阻塞
+func (m *MemorySource) Push(s *Slowlog) {
+	m.ch <- s
+}
+
+// Close 表示没有更多的慢日志，Run 发送完缓存中的慢日志之后返回
+func (m *MemorySource) Close() {
+	close(m.ch)
+}
+
+// Acked 返回已经处理完的慢日志条数
+func (m *MemorySource) Acked() int64 {
+	return atomic.LoadInt64(&m.acked)
+}
+
+func (m *MemorySource) Run(ctx context.Context, events chan<- *Event) error {
+	ack := func() { atomic.AddInt64(&m.acked, 1) }
+	for {
+		select {
+		case s, ok := <-m.ch:
+			if !ok {
+				return nil
+			}
+			e := &Event{Slowlog: s, Ack: ack}
+			select {
+			case events <- e: // 有空位时直接发送，已经取出的慢日志不会因为 ctx 被取消而丢失
+			default:
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package slowlog
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	cfg "github.com/ssp4599815/monitors/redis/config"
+	"github.com/ssp4599815/monitors/redis/resp"
+)
+
+const (
+	// DefaultPollInterval 是默认的 SLOWLOG GET 间隔
+	DefaultPollInterval = 10 * time.Second
+	// DefaultPollCount 是每次 SLOWLOG GET 最多读取的条数
+	DefaultPollCount = 1024
+)
+
+// Poller 是一个 Source，定时在每个 Redis 节点上执行 SLOWLOG LEN 和 SLOWLOG GET，输出新的慢日志，
+// 用于没有 Filebeat 和 Kafka 的环境。
+//
+// 每个节点记录已经读过的最大慢日志 id，只输出 id 更大的慢日志。第一次连接到节点时只记录当前的 id，
+// 不输出之前的慢日志。id 变小说明节点重启过，这时输出节点上所有的慢日志；两次读取之间新增的慢日志
+// 超过了 slowlog-max-len 或者 Count 时，多出的部分已经被丢弃，只记录丢失的条数。
+// 慢日志的 Hostname 为节点的地址，和配置文件中的 addr 一致。
+type Poller struct {
+	Interval time.Duration // SLOWLOG GET 的间隔，默认为 DefaultPollInterval
+	Count    int           // 每次最多读取的条数，默认为 DefaultPollCount
+	Timeout  time.Duration // 连接和每条命令的超时
+
+	nodes []*pollNode
+}
+
+// pollNode 是一个节点的连接和读取进度
+type pollNode struct {
+	addr     string
+	password string
+	conn     *resp.Conn
+	lastID   int64
+	started  bool  // 已经记录了第一次读取时的 id
+	lost     int64 // 因为慢日志队列溢出而丢失的条数
+}
+
+// NewPoller 轮询 hosts 中所有业务线的所有地址
+func NewPoller(hosts []cfg.RedisHost) *Poller {
+	p := &Poller{
+		Interval: DefaultPollInterval,
+		Count:    DefaultPollCount,
+		Timeout:  5 * time.Second,
+	}
+	for _, host := range hosts {
+		for _, addr := range host.Addr {
+			p.nodes = append(p.nodes, &pollNode{addr: addr, password: host.Password})
+		}
+	}
+	return p
+}
+
+// Run 立即轮询一次，之后每隔 Interval 轮询一次，直到 ctx 被取消
+func (p *Poller) Run(ctx context.Context, events chan<- *Event) error {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer p.close()
+
+	for {
+		p.poll(ctx, events)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// poll 并发地轮询所有节点一次
+func (p *Poller) poll(ctx context.Context, events chan<- *Event) {
+	var wg sync.WaitGroup
+	for _, node := range p.nodes {
+		wg.Add(1)
+		go func(node *pollNode) {
+			defer wg.Done()
+			if err := p.pollNode(ctx, node, events); err != nil {
+				log.Warnf("poll slowlog from %s: %v", node.addr, err)
+				if node.conn != nil {
+					node.conn.Close()
+					node.conn = nil
+				}
+			}
+		}(node)
+	}
+	wg.Wait()
+}
+
+func (p *Poller) pollNode(ctx context.Context, node *pollNode, events chan<- *Event) error {
+	if node.conn == nil {
+		conn, err := resp.Dial(node.addr, p.Timeout)
+		if err != nil {
+			return err
+		}
+		node.conn = conn
+		if err = conn.Auth(node.password); err != nil {
+			return err
+		}
+	}
+
+	reply, err := node.conn.Do("SLOWLOG", "LEN")
+	if err != nil {
+		return err
+	}
+	length, ok := reply.(int64)
+	if !ok {
+		return fmt.Errorf("unexpected SLOWLOG LEN reply %v", reply)
+	}
+	if length == 0 {
+		if !node.started {
+			// 队列为空，之后的慢日志都是新的
+			node.started, node.lastID = true, -1
+		}
+		return nil
+	}
+	count := p.Count
+	if count <= 0 {
+		count = DefaultPollCount
+	}
+	if length < int64(count) {
+		count = int(length)
+	}
+	reply, err = node.conn.Do("SLOWLOG", "GET", strconv.Itoa(count))
+	if err != nil {
+		return err
+	}
+	entries, err := parseEntries(reply, node.addr)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// SLOWLOG GET 按 id 从大到小返回
+	newest, oldest := entries[0].Redis.ID, entries[len(entries)-1].Redis.ID
+	if !node.started {
+		node.started, node.lastID = true, newest
+		return nil
+	}
+	if newest < node.lastID {
+		log.Warnf("slowlog id of %s went back from %d to %d, node restarted", node.addr, node.lastID, newest)
+		node.lastID = -1
+	}
+	if lost := oldest - node.lastID - 1; lost > 0 {
+		node.lost += lost
+		log.Warnf("%d slowlog entries of %s were dropped before polling, increase slowlog-max-len or poll more often", lost, node.addr)
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		s := entries[i]
+		if s.Redis.ID <= node.lastID {
+			continue
+		}
+		select {
+		case events <- &Event{Slowlog: s}:
+		case <-ctx.Done():
+			return nil
+		}
+		node.lastID = s.Redis.ID
+	}
+	return nil
+}
+
+func (p *Poller) close() {
+	for _, node := range p.nodes {
+		if node.conn != nil {
+			node.conn.Close()
+			node.conn = nil
+		}
+	}
+}
+
+// Lost 返回每个节点因为慢日志队列溢出而丢失的慢日志条数，需要在 Run 返回之后调用
+func (p *Poller) Lost() map[string]int64 {
+	lost := make(map[string]int64, len(p.nodes))
+	for _, node := range p.nodes {
+		lost[node.addr] = node.lost
+	}
+	return lost
+}
+
+// parseEntries 解析 SLOWLOG GET 的回复，每条慢日志为
+// [id, unix 时间戳, 耗时(微秒), [命令和参数...], 客户端地址, 客户端名字]，后两项在 4.0 之后才有
+func parseEntries(reply interface{}, addr string) ([]*Slowlog, error) {
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected SLOWLOG GET reply %v", reply)
+	}
+	slowlogs := make([]*Slowlog, 0, len(items))
+	for _, item := range items {
+		fields, ok := item.([]interface{})
+		if !ok || len(fields) < 4 {
+			return nil, fmt.Errorf("unexpected slowlog entry %v", item)
+		}
+		id, ok1 := fields[0].(int64)
+		ts, ok2 := fields[1].(int64)
+		duration, ok3 := fields[2].(int64)
+		args, ok4 := fields[3].([]interface{})
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return nil, fmt.Errorf("unexpected slowlog entry %v", item)
+		}
+
+		s := &Slowlog{Timestamp: time.Unix(ts, 0), Hostname: addr}
+		s.Redis.ID, s.Redis.Duration = id, duration
+		for i, arg := range args {
+			b, _ := arg.([]byte)
+			switch i {
+			case 0:
+				s.Redis.Cmd = string(b)
+			case 1:
+				s.Redis.Key = string(b)
+			default:
+				s.Redis.Args = append(s.Redis.Args, string(b))
+			}
+		}
+		slowlogs = append(slowlogs, s)
+	}
+	return slowlogs, nil
+}
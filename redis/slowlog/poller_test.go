@@ -0,0 +1,205 @@
+package slowlog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cfg "github.com/ssp4599815/monitors/redis/config"
+	"github.com/ssp4599815/monitors/redis/resp"
+)
+
+// fakeRedis 是一个只支持 AUTH 和 SLOWLOG 的 Redis，慢日志队列最多保存 maxLen 条
+type fakeRedis struct {
+	password string
+	maxLen   int
+
+	mu      sync.Mutex
+	nextID  int64
+	entries []string // 按 id 从大到小排列的 RESP 编码的慢日志
+}
+
+func startFakeRedis(t *testing.T, r *fakeRedis) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// add 模拟执行了一条慢命令
+func (r *fakeRedis) add(us int64, args ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := fmt.Sprintf("*6\r\n:%d\r\n:%d\r\n:%d\r\n*%d\r\n", r.nextID, 1583056800+r.nextID, us, len(args))
+	for _, arg := range args {
+		entry += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	entry += "$15\r\n127.0.0.1:52000\r\n$0\r\n\r\n"
+	r.nextID++
+	r.entries = append([]string{entry}, r.entries...)
+	if len(r.entries) > r.maxLen {
+		r.entries = r.entries[:r.maxLen]
+	}
+}
+
+// restart 模拟重启，慢日志被清空，id 从 0 开始
+func (r *fakeRedis) restart() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID, r.entries = 0, nil
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := resp.NewReader(bufio.NewReader(conn))
+	authed := r.password == ""
+	for {
+		reply, err := rd.ReadReply()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, strings.ToUpper(string(arg.([]byte))))
+		}
+		r.mu.Lock()
+		switch {
+		case args[0] == "AUTH" && args[1] == strings.ToUpper(r.password):
+			authed = true
+			fmt.Fprint(conn, "+OK\r\n")
+		case !authed:
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+		case args[0] == "SLOWLOG" && args[1] == "LEN":
+			fmt.Fprintf(conn, ":%d\r\n", len(r.entries))
+		case args[0] == "SLOWLOG" && args[1] == "GET":
+			n, _ := strconv.Atoi(args[2])
+			if n > len(r.entries) {
+				n = len(r.entries)
+			}
+			fmt.Fprintf(conn, "*%d\r\n%s", n, strings.Join(r.entries[:n], ""))
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		r.mu.Unlock()
+	}
+}
+
+// received 返回通道中已有的慢日志，格式为 "addr id cmd key args"。
+// 各个节点是并发轮询的，结果按节点排序，同一个节点保持发送的顺序
+func received(ch chan *Event, addrs map[string]string) []string {
+	var got []string
+	for {
+		select {
+		case e := <-ch:
+			s := e.Slowlog
+			got = append(got, fmt.Sprintf("%s %d %s %s %v", addrs[s.Hostname], s.Redis.ID, s.Redis.Cmd, s.Redis.Key, s.Redis.Args))
+		default:
+			sort.SliceStable(got, func(i, j int) bool { return got[i][0] < got[j][0] })
+			return got
+		}
+	}
+}
+
+func TestPoller(t *testing.T) {
+	a := &fakeRedis{maxLen: 5}
+	b := &fakeRedis{maxLen: 5, password: "secret"}
+	for i := 0; i < 3; i++ {
+		a.add(20000, "GET", fmt.Sprintf("old:%d", i))
+	}
+	addrA, addrB := startFakeRedis(t, a), startFakeRedis(t, b)
+	names := map[string]string{addrA: "a", addrB: "b"}
+
+	ch := make(chan *Event, 100)
+	p := NewPoller([]cfg.RedisHost{
+		{Line: "a", Addr: []string{addrA}},
+		{Line: "b", Password: "secret", Addr: []string{addrB}},
+	})
+	ctx := context.Background()
+	check := func(step string, want ...string) {
+		t.Helper()
+		p.poll(ctx, ch)
+		if got := received(ch, names); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: got %q, want %q", step, got, want)
+		}
+	}
+
+	// 第一次只记录已有慢日志的 id
+	check("first poll")
+
+	a.add(30000, "HSET", "user:1", "name", "x")
+	a.add(40000, "KEYS", "*")
+	b.add(50000, "FLUSHALL")
+	check("new entries", "a 3 HSET user:1 [name x]", "a 4 KEYS * []", "b 0 FLUSHALL  []")
+	check("no new entries")
+
+	// 两次读取之间新增了 8 条，队列只保存 5 条，丢失了 3 条
+	for i := 0; i < 8; i++ {
+		a.add(10000, "GET", fmt.Sprintf("k:%d", i))
+	}
+	check("overflow", "a 8 GET k:3 []", "a 9 GET k:4 []", "a 10 GET k:5 []", "a 11 GET k:6 []", "a 12 GET k:7 []")
+	if lost := p.Lost(); lost[addrA] != 3 || lost[addrB] != 0 {
+		t.Fatalf("unexpected lost counts %v", lost)
+	}
+
+	// 重启后 id 变小，输出所有慢日志
+	a.restart()
+	a.add(10000, "GET", "after:restart")
+	check("restart", "a 0 GET after:restart []")
+
+	var s *Slowlog
+	a.add(12345, "SET", "t", "v")
+	p.poll(ctx, ch)
+	select {
+	case e := <-ch:
+		s = e.Slowlog
+	default:
+		t.Fatal("expected a slowlog")
+	}
+	if s.Hostname != addrA || s.Redis.Duration != 12345 || !s.Timestamp.Equal(time.Unix(1583056801, 0)) {
+		t.Fatalf("unexpected slowlog %+v", s)
+	}
+}
+
+func TestPollerRun(t *testing.T) {
+	r := &fakeRedis{maxLen: 10}
+	addr := startFakeRedis(t, r)
+	ch := make(chan *Event, 10)
+	p := NewPoller([]cfg.RedisHost{{Addr: []string{addr}}})
+	p.Interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.poll(ctx, ch) // 队列为空，之后的慢日志都会输出
+	done := make(chan error, 1)
+	go func() { done <- p.Run(ctx, ch) }()
+	r.add(10000, "GET", "k")
+	select {
+	case e := <-ch:
+		if s := e.Slowlog; s.Redis.Key != "k" {
+			t.Fatalf("unexpected slowlog %+v", e.Slowlog)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("slowlog was not polled")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,79 +1,184 @@
 package slowlog
 
 import (
+	"context"
 	"fmt"
-	"github.com/Shopify/sarama"
-	"github.com/tidwall/gjson"
-	"reflect"
+	"strings"
 	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ssp4599815/monitors/libmonitor/alert"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// DefaultMaxSize 是每批慢日志的默认条数，达到后立即交给分析
+	DefaultMaxSize = 100
+	// DefaultIdleTimeout 是默认的刷新间隔，距离上次刷新超过这个时间就把已有的慢日志交给分析
+	DefaultIdleTimeout = 10 * time.Second
+	// eventQueue 是 Source 和 Processer 之间的通道的长度
+	eventQueue = 1000
 )
 
 type Processer struct {
-	AlertChan           chan struct{}
-	messageChan         chan *sarama.ConsumerMessage
-	Slowlog             *Slowlog
-	Slowlogs            []*Slowlog
+	AlertChan           chan *alert.AlertEvent // 接收 Alerter 触发的报警
+	Alerter             *Alerter               // 不为 nil 时检查每个窗口的慢日志
+	Source              Source                 // 慢日志的来源
+	Slowlogs            []*Slowlog             // 当前批次中还没有交给分析的慢日志
+	acks                []func()               // 当前批次中的事件的 Ack，按事件的顺序排列
 	MaxSize             int
 	IdleTimeoutDuration time.Duration // 刷新的空闲时间
+	// Flush 接收每一批慢日志，默认为 analyseMessage；batch 在调用之后不会再被修改
+	Flush func(batch []*Slowlog)
+	// Digests 接收 analyseMessage 按指纹汇总的每个窗口的结果，为 nil 时输出到日志中
+	Digests func(digests []*Digest)
 }
 
-func NewProcesser(msgChan chan *sarama.ConsumerMessage) *Processer {
+func NewProcesser(source Source) *Processer {
 	p := &Processer{
-		messageChan: msgChan,
-		MaxSize:     100, // 达到100条就报警
-		Slowlog:     new(Slowlog),
-		Slowlogs:    make([]*Slowlog, 0),
+		Source:              source,
+		MaxSize:             DefaultMaxSize, // 达到100条就报警
+		IdleTimeoutDuration: DefaultIdleTimeout,
+		Slowlogs:            make([]*Slowlog, 0),
 	}
+	p.Flush = p.analyseMessage
 	return p
 }
 
-func (p *Processer) Run() {
-	fmt.Println("开始处理 messagesChan 通道中的数据")
+// Run 在后台运行 Source，持续处理它产生的事件，直到 ctx 被取消或者 Source 没有更多的事件，
+// 返回 Source 的错误。慢日志攒够 MaxSize 条或者距离上次刷新超过 IdleTimeoutDuration 时交给 Flush，
+// Flush 返回之后才确认这一批事件。ctx 被取消后立即刷新当前批次，之后 Source 发出的事件也尽快刷新，
+// Source 可以等这些事件被确认之后再返回
+func (p *Processer) Run(ctx context.Context) error {
+	log.Infof("开始处理慢日志")
+
+	events := make(chan *Event, eventQueue)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.Source.Run(ctx, events)
+		close(events)
+	}()
 
-	ticker := time.NewTicker(p.IdleTimeoutDuration)
+	timeout := p.IdleTimeoutDuration
+	if timeout <= 0 {
+		timeout = DefaultIdleTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-	select {
-	case message := <-p.messageChan:
-		slowlog := p.parseMessage(message)
-		p.Slowlogs = append(p.Slowlogs, slowlog)
-		if len(p.Slowlogs) == p.MaxSize {
-			p.analyseMessage(p.Slowlogs)
+	done := ctx.Done()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				p.flush()
+				return <-errc
+			}
+			// ctx 被取消之后通道中暂时没有事件时就刷新，不等待 IdleTimeoutDuration
+			if p.add(e) || done == nil && len(events) == 0 {
+				p.flush()
+				resetTimer(timer, timeout)
+			}
+		case <-done:
+			p.flush()
+			done = nil
+		case <-timer.C:
+			p.flush()
+			timer.Reset(timeout)
 		}
-	case <-ticker.C:
-		p.analyseMessage(p.Slowlogs)
 	}
 }
 
-func (p *Processer) parseMessage(msg *sarama.ConsumerMessage) *Slowlog {
-	messageValue := msg.Value
+// add 把一个事件加入当前批次，批次已满时返回 true
+func (p *Processer) add(e *Event) bool {
+	if e.Ack != nil {
+		p.acks = append(p.acks, e.Ack)
+	}
+	if e.Slowlog == nil {
+		return false
+	}
+	p.Slowlogs = append(p.Slowlogs, e.Slowlog)
+	maxSize := p.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return len(p.Slowlogs) >= maxSize
+}
+
+// flush 把当前批次交给 Flush，确认其中的事件，并开始一个新的批次
+func (p *Processer) flush() {
+	batch, acks := p.Slowlogs, p.acks
+	p.Slowlogs, p.acks = make([]*Slowlog, 0, len(batch)), nil
+	if len(batch) > 0 && p.Flush != nil {
+		p.Flush(batch)
+	}
+	for _, ack := range acks {
+		ack()
+	}
+}
+
+// resetTimer 重置一个可能已经触发但还没有被读取的 timer
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
 
-	fmt.Println(string(messageValue))
+// ParseSlowlog 解析 Filebeat 的 redis slowlog 模块输出的一条 JSON 事件
+func ParseSlowlog(value []byte) (*Slowlog, error) {
+	if !gjson.ValidBytes(value) {
+		return nil, fmt.Errorf("invalid json: %.100q", value)
+	}
+	slowlog := new(Slowlog)
 
-	formatTimeStr := gjson.GetBytes(messageValue, "@timestamp").String()
+	formatTimeStr := gjson.GetBytes(value, "@timestamp").String()
 	formatTime, err := time.Parse(time.RFC3339Nano, formatTimeStr)
 	if err != nil {
-		fmt.Println(err)
-	}
-	fmt.Println(formatTime)
-	fmt.Println(reflect.TypeOf(formatTime))
-	p.Slowlog.Timestamp = formatTime
-
-	p.Slowlog.Hostname = gjson.GetBytes(messageValue, "host.name").String()
-	p.Slowlog.Redis.ID = gjson.GetBytes(messageValue, "redis.slowlog.id").Int()
-	p.Slowlog.Redis.Cmd = gjson.GetBytes(messageValue, "redis.slowlog.cmd").String()
-	p.Slowlog.Redis.Key = gjson.GetBytes(messageValue, "redis.slowlog.key").String()
-	p.Slowlog.Redis.Duration = gjson.GetBytes(messageValue, "redis.slowlog.duration.us").Int()
-	for _, arg := range gjson.GetBytes(messageValue, "redis.slowlog.args").Array() {
-		p.Slowlog.Redis.Args = append(p.Slowlog.Redis.Args, arg.String())
-	}
-	fmt.Printf("%#v", p.Slowlog)
-	return p.Slowlog
+		return nil, fmt.Errorf("invalid @timestamp: %v", err)
+	}
+	slowlog.Timestamp = formatTime
+
+	slowlog.Hostname = gjson.GetBytes(value, "host.name").String()
+	slowlog.Redis.ID = gjson.GetBytes(value, "redis.slowlog.id").Int()
+	slowlog.Redis.Cmd = gjson.GetBytes(value, "redis.slowlog.cmd").String()
+	slowlog.Redis.Key = gjson.GetBytes(value, "redis.slowlog.key").String()
+	slowlog.Redis.Duration = gjson.GetBytes(value, "redis.slowlog.duration.us").Int()
+	for _, arg := range gjson.GetBytes(value, "redis.slowlog.args").Array() {
+		slowlog.Redis.Args = append(slowlog.Redis.Args, arg.String())
+	}
+	return slowlog, nil
 }
 
+// analyseMessage 检查一个窗口内的慢日志是否需要报警，并按指纹汇总，交给 Digests 或者输出到日志中
 func (p *Processer) analyseMessage(msgs []*Slowlog) {
-	// 如果没有数据 就天国
-	if len(msgs) > 0 {
-		// TODO：处理数据
+	// 如果没有数据 就跳过
+	if len(msgs) == 0 {
+		return
+	}
+	if p.Alerter != nil && p.AlertChan != nil {
+		for _, e := range p.Alerter.Check(msgs) {
+			p.AlertChan <- e
+		}
+	}
+	digests := Aggregate(msgs)
+	if p.Digests != nil {
+		p.Digests(digests)
+		return
+	}
+	for _, d := range digests {
+		log.WithFields(log.Fields{
+			"count": d.Count,
+			"total": d.Total,
+			"avg":   d.Avg,
+			"p50":   d.P50,
+			"p95":   d.P95,
+			"p99":   d.P99,
+			"max":   d.Max,
+			"hosts": strings.Join(d.Hosts, ","),
+		}).Infof("slowlog digest %s", d.Fingerprint)
 	}
-
 }
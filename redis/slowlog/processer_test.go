@@ -0,0 +1,154 @@
+package slowlog
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testEvent = `{"@timestamp":"2020-03-01T10:00:01.123Z","host":{"name":"redis-1"},` +
+	`"redis":{"slowlog":{"id":7,"cmd":"GET","key":"user:1","args":["x"],"duration":{"us":1500}}}}`
+
+// slowlogMessage 返回 Filebeat 输出的第 id 条慢日志，id 为奇数和偶数时来自不同的主机
+func slowlogMessage(id int) []byte {
+	return []byte(fmt.Sprintf(`{"@timestamp":"2020-03-01T10:00:0%d.123Z","host":{"name":"redis-%d"},`+
+		`"redis":{"slowlog":{"id":%d,"cmd":"GET","key":"user:%d","args":["x"],"duration":{"us":%d}}}}`,
+		id%10, id%2, id, id, 1000+id))
+}
+
+// runProcesser 在后台运行 p，返回接收每一批慢日志的通道和等待 Run 返回的函数
+func runProcesser(ctx context.Context, p *Processer) (chan []*Slowlog, func() error) {
+	batches := make(chan []*Slowlog, 100)
+	p.Flush = func(batch []*Slowlog) { batches <- batch }
+	done := make(chan error, 1)
+	go func() { done <- p.Run(ctx) }()
+	return batches, func() error { return <-done }
+}
+
+func TestParseSlowlog(t *testing.T) {
+	s, err := ParseSlowlog([]byte(testEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Hostname != "redis-1" || s.Redis.ID != 7 || s.Redis.Key != "user:1" || s.DurationTime() != 1500*time.Microsecond ||
+		fmt.Sprint(s.Redis.Args) != "[x]" || s.Timestamp.Nanosecond() != 123000000 {
+		t.Fatalf("unexpected slowlog %+v", s)
+	}
+	if _, err = ParseSlowlog([]byte("not json")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestProcesserSizeFlush(t *testing.T) {
+	source := NewMemorySource(10)
+	p := NewProcesser(source)
+	p.MaxSize = 3
+	p.IdleTimeoutDuration = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches, _ := runProcesser(ctx, p)
+
+	// 和 Kafka 的消费者一样逐条解析消息
+	for i := 0; i < 3; i++ {
+		s, err := ParseSlowlog(slowlogMessage(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		source.Push(s)
+	}
+	select {
+	case batch := <-batches:
+		if len(batch) != 3 {
+			t.Fatalf("expected 3 slowlogs, got %d", len(batch))
+		}
+		for i, s := range batch {
+			// 每条消息都应该是独立的记录
+			if s.Redis.ID != int64(i) || s.Redis.Key != fmt.Sprintf("user:%d", i) || s.Redis.Duration != int64(1000+i) {
+				t.Fatalf("unexpected slowlog %d: %+v", i, s)
+			}
+		}
+		if batch[0] == batch[1] || batch[0].Hostname != "redis-0" || batch[1].Hostname != "redis-1" {
+			t.Fatalf("slowlogs share the same record: %+v %+v", batch[0], batch[1])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch was not flushed after MaxSize messages")
+	}
+}
+
+func TestProcesserIdleFlush(t *testing.T) {
+	source := NewMemorySource(10)
+	p := NewProcesser(source)
+	p.IdleTimeoutDuration = 20 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches, _ := runProcesser(ctx, p)
+
+	source.Push(newSlowlog("redis-1", "GET", "k", 10, "x"))
+	select {
+	case batch := <-batches:
+		if len(batch) != 1 || batch[0].Redis.Key != "k" || len(batch[0].Redis.Args) != 1 {
+			t.Fatalf("unexpected batch %+v", batch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch was not flushed after the idle timeout")
+	}
+}
+
+func TestProcesserAck(t *testing.T) {
+	source := NewMemorySource(10)
+	for i := 0; i < 5; i++ {
+		source.Push(newSlowlog("redis-1", "GET", fmt.Sprintf("k:%d", i), 10))
+	}
+	source.Close()
+	p := NewProcesser(source)
+	p.MaxSize = 2
+	p.IdleTimeoutDuration = time.Hour
+	var keys []string
+	flushed := 0
+	p.Flush = func(batch []*Slowlog) {
+		// 只有处理完的批次才会被确认
+		if acked := source.Acked(); acked != int64(flushed) {
+			t.Errorf("%d events acked before flushing, want %d", acked, flushed)
+		}
+		for _, s := range batch {
+			keys = append(keys, s.Redis.Key)
+		}
+		flushed += len(batch)
+	}
+	// Source 没有更多的事件时刷新最后一批并返回
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[k:0 k:1 k:2 k:3 k:4]" || source.Acked() != 5 {
+		t.Fatalf("unexpected keys %v, %d acked", keys, source.Acked())
+	}
+}
+
+func TestProcesserCancel(t *testing.T) {
+	source := NewMemorySource(10)
+	p := NewProcesser(source)
+	p.IdleTimeoutDuration = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	batches, wait := runProcesser(ctx, p)
+	for i := 0; i < 3; i++ {
+		source.Push(newSlowlog("redis-1", "GET", fmt.Sprintf("k:%d", i), 10))
+	}
+	for len(source.ch) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	close(batches)
+
+	// 已经交给 Processer 的事件在退出前处理完并确认
+	n := 0
+	for batch := range batches {
+		n += len(batch)
+	}
+	if n != 3 || source.Acked() != 3 {
+		t.Fatalf("expected 3 slowlogs flushed and acked, got %d and %d", n, source.Acked())
+	}
+}
@@ -13,3 +13,8 @@ type Slowlog struct {
 	}
 	Hostname string // 主机名
 }
+
+// DurationTime 返回慢日志的耗时，Redis.Duration 的单位是微秒
+func (s *Slowlog) DurationTime() time.Duration {
+	return time.Duration(s.Redis.Duration) * time.Microsecond
+}
@@ -0,0 +1,18 @@
+package slowlog
+
+import "context"
+
+// Event 是 Source 产生的一条已经解析好的慢日志
+type Event struct {
+	Slowlog *Slowlog
+	// Ack 在事件处理完之后调用，例如提交 kafka 的 offset，不需要确认时为 nil。
+	// 同一个 Source 的事件按产生的顺序确认
+	Ack func()
+}
+
+// Source 是慢日志的来源，例如 kafka、文件、标准输入或者直接轮询 Redis
+type Source interface {
+	// Run 把事件发送到 events，直到 ctx 被取消或者没有更多的事件，不会关闭 events。
+	// ctx 被取消之后 Processer 仍然会处理和确认已经发出的事件，Run 可以等待它们被确认之后再返回
+	Run(ctx context.Context, events chan<- *Event) error
+}
@@ -0,0 +1,238 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ssp4599815/monitors/redis/slowlog"
+)
+
+const (
+	DefaultFilePoll       = time.Second // 读到文件末尾后检查新内容的默认间隔
+	DefaultCommitInterval = time.Second // 保存已经处理完的位置的默认间隔
+)
+
+// FileSource 像 tail -f 一样读取 Filebeat 输出的 JSON 行格式的慢日志文件。
+//
+// 事件确认时只在内存中记录已经处理完的位置，每隔 CommitInterval 和 Run 返回时把位置和文件的设备号、inode
+// 写入 OffsetFile，重启后从这里继续读取（异常退出时可能重复读取最后一个间隔内的慢日志），
+// 没有保存位置、文件已经不是保存时的文件或者比保存的位置小时从文件开头读取。
+// 运行中文件被截断（变得比读取的位置小）或者被轮转（路径指向了新的文件）时从新文件的开头读取。
+type FileSource struct {
+	Path       string
+	OffsetFile string        // 为空时不保存位置
+	Poll       time.Duration // 读到文件末尾后检查新内容的间隔，默认为 DefaultFilePoll
+	// CommitInterval 是保存位置的间隔，默认为 DefaultCommitInterval
+	CommitInterval time.Duration
+
+	mu      sync.Mutex // 保护下面的字段和 OffsetFile 的写入
+	acked   position   // 已经确认的最大位置
+	dirty   bool       // acked 还没有写入 OffsetFile
+	stopped bool       // Run 已经返回，之后的确认立即写入
+}
+
+func NewFileSource(path, offsetFile string) *FileSource {
+	return &FileSource{Path: path, OffsetFile: offsetFile, Poll: DefaultFilePoll, CommitInterval: DefaultCommitInterval}
+}
+
+func (f *FileSource) Run(ctx context.Context, events chan<- *slowlog.Event) error {
+	poll := f.Poll
+	if poll <= 0 {
+		poll = DefaultFilePoll
+	}
+	saved, err := f.loadOffset()
+	if err != nil {
+		return err
+	}
+	offset := saved.offset
+	f.mu.Lock()
+	f.acked, f.dirty, f.stopped = saved, false, false
+	f.mu.Unlock()
+
+	interval := f.CommitInterval
+	if interval <= 0 {
+		interval = DefaultCommitInterval
+	}
+	ticker := time.NewTicker(interval)
+	stopCommit := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				f.save()
+			case <-stopCommit:
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stopCommit)
+		ticker.Stop()
+		f.mu.Lock()
+		f.stopped = true
+		f.mu.Unlock()
+		f.save()
+	}()
+
+	var (
+		file     *os.File
+		dev, ino uint64 // file 的标识，和位置一起保存
+		rd       *bufio.Reader
+		partial  []byte // 文件末尾还没有写完的一行
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	wait := func() bool {
+		select {
+		case <-time.After(poll):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for ctx.Err() == nil {
+		if file == nil {
+			file, err = os.Open(f.Path)
+			if os.IsNotExist(err) {
+				if !wait() {
+					return nil
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			info, err := file.Stat()
+			if err != nil {
+				return err
+			}
+			dev, ino = fileID(info)
+			if offset > 0 && (dev != saved.dev || ino != saved.ino || info.Size() < offset) {
+				log.Infof("%s is not the file of the saved offset, read from the beginning", f.Path)
+				offset = 0
+			}
+			if _, err = file.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			rd, partial = bufio.NewReader(file), nil
+		}
+
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			partial = append(partial, line...)
+			if f.rotated(file, offset+int64(len(partial))) {
+				log.Infof("%s was truncated or rotated, read from the beginning", f.Path)
+				file.Close()
+				file, offset = nil, 0
+				continue
+			}
+			if !wait() {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(partial) > 0 {
+			line = append(partial, line...)
+			partial = nil
+		}
+		offset += int64(len(line))
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		end := position{dev: dev, ino: ino, offset: offset}
+		e := &slowlog.Event{Slowlog: decodeLine(line, f.Path), Ack: func() { f.ack(end) }}
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// rotated 判断 Path 是否被截断或者指向了新的文件，read 为 file 中已经读取的字节数
+func (f *FileSource) rotated(file *os.File, read int64) bool {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return false // 轮转期间文件可能暂时不存在，继续等待
+	}
+	current, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(info, current) || info.Size() < read
+}
+
+// position 是保存在 OffsetFile 中的内容，格式为 "设备号 inode 位置"
+type position struct {
+	dev, ino uint64
+	offset   int64
+}
+
+func (f *FileSource) loadOffset() (position, error) {
+	var pos position
+	if f.OffsetFile == "" {
+		return pos, nil
+	}
+	data, err := ioutil.ReadFile(f.OffsetFile)
+	if os.IsNotExist(err) {
+		return pos, nil
+	}
+	if err != nil {
+		return pos, err
+	}
+	if _, err = fmt.Sscan(string(data), &pos.dev, &pos.ino, &pos.offset); err != nil {
+		return pos, fmt.Errorf("invalid offset file %s: %v", f.OffsetFile, err)
+	}
+	return pos, nil
+}
+
+// ack 记录已经处理完的位置，文件轮转之后新文件的位置直接替换旧文件的位置
+func (f *FileSource) ack(pos position) {
+	f.mu.Lock()
+	if pos.dev != f.acked.dev || pos.ino != f.acked.ino || pos.offset > f.acked.offset {
+		f.acked, f.dirty = pos, true
+	}
+	stopped := f.stopped
+	f.mu.Unlock()
+	if stopped {
+		f.save()
+	}
+}
+
+// save 把确认过的位置写入 OffsetFile，先写临时文件再重命名，避免写入一半时退出
+func (f *FileSource) save() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.OffsetFile == "" || !f.dirty {
+		return
+	}
+	pos := f.acked
+	tmp := f.OffsetFile + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d %d %d\n", pos.dev, pos.ino, pos.offset)), 0644)
+	if err == nil {
+		err = os.Rename(tmp, f.OffsetFile)
+	}
+	if err != nil {
+		log.Warnf("save offset of %s: %v", f.Path, err)
+		return
+	}
+	f.dirty = false
+}
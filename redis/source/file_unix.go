@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package source
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID 返回文件所在的设备和 inode，用来判断保存的位置是否属于同一个文件
+func fileID(info os.FileInfo) (dev, ino uint64) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), uint64(st.Ino)
+	}
+	return 0, 0
+}
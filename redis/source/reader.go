@@ -0,0 +1,53 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ssp4599815/monitors/redis/slowlog"
+)
+
+// maxLineSize 是一行 JSON 的最大长度
+const maxLineSize = 16 << 20
+
+// ReaderSource 逐行读取 JSON 行格式的慢日志，读到 EOF 时结束，例如从标准输入读取。
+// 没有可以提交的位置，事件不需要确认
+type ReaderSource struct {
+	r    io.Reader
+	name string // 用于日志中的来源
+}
+
+func NewReaderSource(r io.Reader, name string) *ReaderSource {
+	return &ReaderSource{r: r, name: name}
+}
+
+// Run 在读到 EOF 或者 ctx 被取消时返回，阻塞在读取上时要等到下一行到达才能返回
+func (s *ReaderSource) Run(ctx context.Context, events chan<- *slowlog.Event) error {
+	scanner := bufio.NewScanner(s.r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		select {
+		case events <- &slowlog.Event{Slowlog: decodeLine(line, s.name)}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// decodeLine 解析一行 Filebeat 的慢日志，无法解析时返回 nil，事件仍然按顺序确认
+func decodeLine(line []byte, name string) *slowlog.Slowlog {
+	s, err := slowlog.ParseSlowlog(line)
+	if err != nil {
+		log.Warnf("skip slowlog line in %s: %v", name, err)
+		return nil
+	}
+	return s
+}
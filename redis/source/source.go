@@ -0,0 +1,42 @@
+package source
+
+import (
+	"fmt"
+	"os"
+
+	cfg "github.com/ssp4599815/monitors/redis/config"
+	"github.com/ssp4599815/monitors/redis/hunter"
+	"github.com/ssp4599815/monitors/redis/slowlog"
+)
+
+// memoryQueue 是 memory 来源缓存的慢日志条数
+const memoryQueue = 1000
+
+// New 根据配置文件中的 slowlog.input 创建慢日志的来源。
+// memory 返回的 *slowlog.MemorySource 由调用者 Push 慢日志，只用于测试或者空跑
+func New(config *cfg.Config) (slowlog.Source, error) {
+	c := config.Slowlog
+	switch c.Input {
+	case "", "kafka":
+		return hunter.NewHunter(config.Kafka), nil
+	case "poll":
+		poller := slowlog.NewPoller(config.Redis)
+		if c.PollInterval > 0 {
+			poller.Interval = c.PollInterval
+		}
+		if c.PollCount > 0 {
+			poller.Count = c.PollCount
+		}
+		return poller, nil
+	case "file":
+		if c.File == "" {
+			return nil, fmt.Errorf("slowlog.file is required when slowlog.input is file")
+		}
+		return NewFileSource(c.File, c.OffsetFile), nil
+	case "stdin":
+		return NewReaderSource(os.Stdin, "stdin"), nil
+	case "memory":
+		return slowlog.NewMemorySource(memoryQueue), nil
+	}
+	return nil, fmt.Errorf("unknown slowlog input %q, expected kafka, poll, file, stdin or memory", c.Input)
+}
@@ -0,0 +1,188 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cfg "github.com/ssp4599815/monitors/redis/config"
+	"github.com/ssp4599815/monitors/redis/hunter"
+	"github.com/ssp4599815/monitors/redis/slowlog"
+)
+
+func eventLine(id int, key string) string {
+	return fmt.Sprintf(`{"@timestamp":"2020-03-01T10:00:00Z","host":{"name":"redis-1"},`+
+		`"redis":{"slowlog":{"id":%d,"cmd":"GET","key":"%s","duration":{"us":100}}}}`+"\n", id, key)
+}
+
+func appendFile(t *testing.T, path, data string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next 等待下一个事件并确认，返回慢日志的 key，无法解析的行返回 "-"
+func next(t *testing.T, events chan *slowlog.Event) string {
+	t.Helper()
+	select {
+	case e := <-events:
+		if e.Ack != nil {
+			e.Ack()
+		}
+		if e.Slowlog == nil {
+			return "-"
+		}
+		return e.Slowlog.Redis.Key
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return ""
+}
+
+func readOffset(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slowlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, offsetFile := filepath.Join(dir, "slowlog.json"), filepath.Join(dir, "slowlog.offset")
+	first := eventLine(1, "a") + "not json\n"
+	appendFile(t, path, first)
+
+	run := func(commit time.Duration) (chan *slowlog.Event, func()) {
+		f := NewFileSource(path, offsetFile)
+		f.Poll = 5 * time.Millisecond
+		f.CommitInterval = commit
+		events := make(chan *slowlog.Event, 10)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- f.Run(ctx, events) }()
+		return events, func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	events, stop := run(time.Hour)
+	if got := next(t, events) + next(t, events); got != "a-" {
+		t.Fatalf("unexpected events %q", got)
+	}
+	// 确认时不写文件，位置在间隔到了或者停止时保存
+	if _, err := os.Stat(offsetFile); !os.IsNotExist(err) {
+		t.Fatalf("expected no offset file before the commit interval, got %v", err)
+	}
+
+	// 追加的内容分两次写入，只有完整的一行才会输出
+	line := eventLine(2, "b")
+	appendFile(t, path, line[:10])
+	time.Sleep(20 * time.Millisecond)
+	appendFile(t, path, line[10:])
+	if got := next(t, events); got != "b" {
+		t.Fatalf("unexpected event %q", got)
+	}
+	stop()
+	if offset := readOffset(t, offsetFile); !strings.HasSuffix(offset, " "+fmt.Sprint(len(first)+len(line))) {
+		t.Fatalf("unexpected offset %s", offset)
+	}
+
+	// 重启后从保存的位置继续读取
+	appendFile(t, path, eventLine(3, "c"))
+	events, stop = run(5 * time.Millisecond)
+	if got := next(t, events); got != "c" {
+		t.Fatalf("expected to resume at c, got %q", got)
+	}
+
+	// 轮转后从新文件的开头读取
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, eventLine(4, "d"))
+	if got := next(t, events); got != "d" {
+		t.Fatalf("expected d after rotation, got %q", got)
+	}
+	// 运行中按间隔保存新文件的位置
+	want := " " + fmt.Sprint(len(eventLine(4, "d")))
+	for deadline := time.Now().Add(5 * time.Second); !strings.HasSuffix(readOffset(t, offsetFile), want); {
+		if time.Now().After(deadline) {
+			t.Fatalf("offset %s was not saved", readOffset(t, offsetFile))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stop()
+
+	// 停止期间文件被轮转，新文件比保存的位置大，也要从新文件的开头读取
+	if err = os.Rename(path, path+".2"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, eventLine(5, "e")+eventLine(6, "f"))
+	events, stop = run(5 * time.Millisecond)
+	if got := next(t, events) + next(t, events); got != "ef" {
+		t.Fatalf("expected to restart at e after rotation, got %q", got)
+	}
+	stop()
+}
+
+func TestReaderSource(t *testing.T) {
+	events := make(chan *slowlog.Event, 10)
+	input := eventLine(1, "a") + "\n" + eventLine(2, "b")
+	if err := NewReaderSource(strings.NewReader(input), "test").Run(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	close(events)
+	var keys []string
+	for e := range events {
+		if e.Ack != nil {
+			t.Fatal("reader events need no ack")
+		}
+		keys = append(keys, e.Slowlog.Redis.Key)
+	}
+	if fmt.Sprint(keys) != "[a b]" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for input, want := range map[string]interface{}{
+		"":       &hunter.Hunter{},
+		"kafka":  &hunter.Hunter{},
+		"poll":   &slowlog.Poller{},
+		"file":   &FileSource{},
+		"stdin":  &ReaderSource{},
+		"memory": &slowlog.MemorySource{},
+	} {
+		c := &cfg.Config{Slowlog: cfg.SlowlogConfig{Input: input, File: "slowlog.json"}}
+		src, err := New(c)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if fmt.Sprintf("%T", src) != fmt.Sprintf("%T", want) {
+			t.Fatalf("%s: got %T, want %T", input, src, want)
+		}
+	}
+	if _, err := New(&cfg.Config{Slowlog: cfg.SlowlogConfig{Input: "file"}}); err == nil {
+		t.Fatal("expected an error without slowlog.file")
+	}
+	if _, err := New(&cfg.Config{Slowlog: cfg.SlowlogConfig{Input: "redis"}}); err == nil {
+		t.Fatal("expected an error for an unknown input")
+	}
+}